@@ -1,15 +1,18 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"image/color"
-	"io"
 	"log"
 	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 	"time"
 	
 	"fyne.io/fyne/v2"
@@ -32,7 +35,9 @@ import (
 
 // AppState stores the global state of the application
 type AppState struct {
-	llmClient       *llm.Client
+	llmClient       llm.Provider
+	// provider is the name of the provider of llmClient, as in LLM_PROVIDER
+	provider        string
 	intentProcessor *intent.Processor
 	astProcessor    *ast.Processor
 	semanticModel   *semantics.Model
@@ -42,24 +47,18 @@ type AppState struct {
 	models          []llm.Model
 	ui              *uiElements
 	isDarkTheme     bool
-}
-
-// OpenRouter API models response structure
-type OpenRouterModelsResponse struct {
-	Data []OpenRouterModel `json:"data"`
-}
-
-type OpenRouterModel struct {
-	ID            string `json:"id"`
-	Name          string `json:"name"`
-	Created       int64  `json:"created"`
-	Description   string `json:"description"`
-	ContextLength int    `json:"context_length"`
+	// usage counts the usage and cost of the requests to the LLM
+	usage           *llm.UsageTracker
+	// intentCtx is the context of the intent being processed, and
+	// cancelIntent cancels it; both are guarded by intentMu
+	intentMu     sync.Mutex
+	intentCtx    context.Context
+	cancelIntent context.CancelFunc
 }
 
 // Cache for storing models with a timestamp
 type ModelsCache struct {
-	Models    []OpenRouterModel
+	Models    []llm.Model
 	Timestamp time.Time
 }
 
@@ -69,11 +68,13 @@ var modelsCache ModelsCache
 // uiElements stores references to important UI elements for updating
 type uiElements struct {
 	statusBar          *widget.Label
+	usageLabel         *widget.Label
 	codeOutput         *widget.Entry
 	astOutput          *widget.Entry
 	semanticOutput     *widget.Entry
 	modelSelector      *widget.Select
 	intentInput        *widget.Entry
+	cancelButton       *widget.Button
 	fileExplorer       *FileExplorer
 	fileContentDisplay *widget.Entry
 	filePathLabel      *widget.Label
@@ -101,9 +102,10 @@ func (t *codeTheme) Color(name fyne.ThemeColorName, variant fyne.ThemeVariant) c
 	}
 }
 
-// fetchAvailableModels retrieves models from OpenRouter API without requiring API key
-// and returns a list of model IDs, automatically refreshing cache every 12 hours
-func fetchAvailableModels() ([]string, error) {
+// fetchAvailableModels retrieves the models of the provider, or of OpenRouter
+// without requiring an API key when there is no provider yet, and returns a
+// list of model IDs, automatically refreshing cache every 12 hours
+func fetchAvailableModels(provider llm.Provider) ([]string, error) {
 	// Check if cache is still valid (less than 12 hours old)
 	if !modelsCache.Timestamp.IsZero() && time.Since(modelsCache.Timestamp) < 12*time.Hour && len(modelsCache.Models) > 0 {
 		// Use cached models
@@ -114,43 +116,26 @@ func fetchAvailableModels() ([]string, error) {
 		return modelIDs, nil
 	}
 
-	// Cache expired or empty, fetch new data
-	client := &http.Client{
-		Timeout: 10 * time.Second,
-	}
-
-	req, err := http.NewRequest("GET", "https://openrouter.ai/api/v1/models", nil)
-	if err != nil {
-		return nil, err
-	}
-
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("API request failed with status code: %d", resp.StatusCode)
+	// Cache expired or empty, fetch new data. OpenRouter lists its
+	// models without an API key.
+	if provider == nil {
+		provider = &llm.Client{
+			HTTPClient: &http.Client{Timeout: 10 * time.Second},
+		}
 	}
 
-	body, err := io.ReadAll(resp.Body)
+	models, err := provider.GetAvailableModelsContext(context.Background())
 	if err != nil {
 		return nil, err
 	}
 
-	var modelsResp OpenRouterModelsResponse
-	if err := json.Unmarshal(body, &modelsResp); err != nil {
-		return nil, err
-	}
-
 	// Update cache
-	modelsCache.Models = modelsResp.Data
+	modelsCache.Models = models
 	modelsCache.Timestamp = time.Now()
 
 	// Extract model IDs
-	modelIDs := make([]string, len(modelsResp.Data))
-	for i, model := range modelsResp.Data {
+	modelIDs := make([]string, len(models))
+	for i, model := range models {
 		modelIDs[i] = model.ID
 	}
 
@@ -163,7 +148,7 @@ func checkOpenRouterConnectivity() error {
 		Timeout: 10 * time.Second,
 	}
 
-	req, err := http.NewRequest("GET", "https://openrouter.ai/api/v1/models", nil)
+	req, err := http.NewRequest("GET", llm.OpenRouterModelsURL, nil)
 	if err != nil {
 		return fmt.Errorf("failed to create request: %v", err)
 	}
@@ -207,8 +192,8 @@ func main() {
 		isDarkTheme:   true, // Default to dark theme
 	}
 	
-	// Initialize the semantic model
-	appState.semanticModel = semantics.NewModel()
+	// Initialize the semantic model from the workspace's saved copy
+	appState.semanticModel = loadSemanticModel(workspaceDir)
 	
 	// Initialize the AST processor
 	appState.astProcessor = ast.NewProcessor(appState.semanticModel)
@@ -216,20 +201,35 @@ func main() {
 	// Initialize the intent processor
 	appState.intentProcessor = intent.NewProcessor(appState.astProcessor, appState.semanticModel)
 	
-	// Initialize LLM client if API key is available
-	if appState.apiKey != "" {
-		// Check connectivity to OpenRouter
-		connErr := checkOpenRouterConnectivity()
-		if connErr != nil {
-			log.Printf("Warning: Cannot connect to OpenRouter API: %v", connErr)
-			fmt.Println("Warning: Cannot connect to OpenRouter API - check your internet connection")
+	// Initialize usage accounting, kept with the workspace
+	appState.usage, err = llm.NewUsageTracker(filepath.Join(workspaceDir, llm.UsageFile), fs.WorkingDirectory)
+	if err != nil {
+		log.Printf("Warning: Could not load usage totals: %v", err)
+	}
+	appState.usage.SetBudget(llm.BudgetFromEnv())
+	
+	// Initialize the LLM provider if an API key or a provider is configured
+	providerName := strings.ToLower(os.Getenv("LLM_PROVIDER"))
+	if appState.apiKey != "" || providerName != "" {
+		if providerName == "" || providerName == llm.ProviderOpenRouter {
+			// Check connectivity to OpenRouter
+			connErr := checkOpenRouterConnectivity()
+			if connErr != nil {
+				log.Printf("Warning: Cannot connect to OpenRouter API: %v", connErr)
+				fmt.Println("Warning: Cannot connect to OpenRouter API - check your internet connection")
+			}
 		}
 		
-		client, err := llm.NewClient()
+		provider, err := llm.NewProviderFromEnv()
 		if err == nil {
+			client := llm.NewMeteredProvider(provider, appState.usage)
 			appState.llmClient = client
+			appState.provider = providerName
 			appState.intentProcessor.SetLLMClient(client)
-			fmt.Println("OpenRouter API key found - AI code generation is enabled")
+			if model := os.Getenv("LLM_MODEL"); model != "" {
+				appState.selectedModel = model
+			}
+			fmt.Println("LLM provider configured - AI code generation is enabled")
 		} else {
 			log.Printf("Error initializing LLM client: %v", err)
 			fmt.Println("Error: Failed to initialize LLM client - check your API key")
@@ -282,6 +282,9 @@ func setupMainMenu(w fyne.Window, state *AppState) {
 		fyne.NewMenuItem("Save Output", func() {
 			saveOutput(w, state)
 		}),
+		fyne.NewMenuItem("Export Semantic Graph...", func() {
+			exportSemanticGraph(w, state)
+		}),
 		fyne.NewMenuItemSeparator(),
 		fyne.NewMenuItem("Exit", func() {
 			w.Close()
@@ -473,9 +476,51 @@ func openProject(w fyne.Window, state *AppState) {
 			state.ui.statusBar.SetText(fmt.Sprintf("Project opened at %s", path))
 		}
 		
+		// Count usage for the project from now on
+		state.usage.SetProject(path)
+		updateUsageLabel(state)
+		
+		// Index the project in the background
+		go refreshSemanticModel(state.semanticModel, path)
+		
 	}, w)
 }
 
+// loadSemanticModel loads the semantic model saved in a workspace and
+// brings it up to date with the files on disk in the background. A missing
+// or damaged file starts a fresh model.
+func loadSemanticModel(dir string) *semantics.Model {
+	model, err := semantics.LoadModel(filepath.Join(dir, semantics.StoreFile))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Warning: Could not load semantic model, re-indexing: %v", err)
+		}
+		model = semantics.NewModel()
+	}
+	
+	go refreshSemanticModel(model, dir)
+	return model
+}
+
+// refreshSemanticModel re-indexes the Go files of dir, type-checking them
+// when dir is a module and from their syntax otherwise, and saves the model
+// again
+func refreshSemanticModel(model *semantics.Model, dir string) {
+	updated, removed, err := model.LoadDir(dir)
+	if err != nil {
+		log.Printf("Warning: Could not index %s: %v", dir, err)
+		return
+	}
+	if updated == 0 && removed == 0 {
+		return
+	}
+	log.Printf("Semantic model: %d files indexed, %d removed", updated, removed)
+	
+	if err := model.Save(filepath.Join(dir, semantics.StoreFile)); err != nil {
+		log.Printf("Warning: Could not save semantic model: %v", err)
+	}
+}
+
 // saveOutput saves the generated code to a file
 func saveOutput(w fyne.Window, state *AppState) {
 	if state.ui.codeOutput == nil || state.ui.codeOutput.Text == "" {
@@ -516,6 +561,65 @@ func saveOutput(w fyne.Window, state *AppState) {
 	fd.Show()
 }
 
+// exportSemanticGraph asks for a format and an optional query and saves
+// the semantic graph, or the part of it the query selects, to a file
+func exportSemanticGraph(w fyne.Window, state *AppState) {
+	extensions := map[string]string{
+		semantics.FormatDOT:     ".dot",
+		semantics.FormatGraphML: ".graphml",
+		semantics.FormatMermaid: ".mmd",
+	}
+	
+	formatSelect := widget.NewSelect(semantics.ExportFormats, nil)
+	formatSelect.SetSelected(semantics.FormatMermaid)
+	queryEntry := widget.NewEntry()
+	queryEntry.SetPlaceHolder("package=auth follow:* (empty exports everything)")
+	
+	dialog.ShowForm("Export Semantic Graph", "Export", "Cancel",
+		[]*widget.FormItem{
+			widget.NewFormItem("Format", formatSelect),
+			widget.NewFormItem("Query", queryEntry),
+		},
+		func(submit bool) {
+			if !submit {
+				return
+			}
+			
+			var query *semantics.Query
+			if queryEntry.Text != "" {
+				var err error
+				if query, err = semantics.ParseQuery(queryEntry.Text); err != nil {
+					dialog.ShowError(fmt.Errorf("Invalid query: %v", err), w)
+					return
+				}
+			}
+			format := formatSelect.Selected
+			
+			fd := dialog.NewFileSave(func(writer fyne.URIWriteCloser, err error) {
+				if err != nil {
+					dialog.ShowError(err, w)
+					return
+				}
+				if writer == nil {
+					return
+				}
+				defer writer.Close()
+				
+				if err := state.semanticModel.Export(writer, format, query); err != nil {
+					dialog.ShowError(fmt.Errorf("Failed to export semantic graph: %v", err), w)
+					return
+				}
+				
+				// Update status
+				if state.ui.statusBar != nil {
+					state.ui.statusBar.SetText(fmt.Sprintf("Semantic graph exported to %s", writer.URI().Path()))
+				}
+			}, w)
+			fd.SetFileName("semantics" + extensions[format])
+			fd.Show()
+		}, w)
+}
+
 // showSettings displays a settings dialog for API key and model settings
 func showSettings(w fyne.Window, state *AppState) {
 	// Create API key input with improved styling
@@ -571,8 +675,9 @@ func showSettings(w fyne.Window, state *AppState) {
 			}
 			
 			// If successful, update the state
-			state.llmClient = client
-			state.intentProcessor.SetLLMClient(client)
+			state.llmClient = llm.NewMeteredProvider(client, state.usage)
+			state.provider = llm.ProviderOpenRouter
+			state.intentProcessor.SetLLMClient(state.llmClient)
 			
 			progress.Hide()
 			dialog.ShowInformation("API Key Saved", "Your API key has been verified and saved. AI code generation is now enabled.", w)
@@ -813,9 +918,21 @@ func createUI(w fyne.Window, state *AppState) fyne.CanvasObject {
 	})
 	executeButton.Importance = widget.HighImportance // Highlight the button
 	
+	// Cancel button stopping the intent being processed
+	cancelButton := widget.NewButtonWithIcon("Cancel", theme.CancelIcon(), func() {
+		state.intentMu.Lock()
+		cancelIntent := state.cancelIntent
+		state.intentMu.Unlock()
+		if cancelIntent != nil {
+			cancelIntent()
+		}
+	})
+	cancelButton.Disable()
+	
 	// Create a button container with right alignment
 	buttonContainer := container.NewHBox(
 		layout.NewSpacer(),
+		cancelButton,
 		executeButton,
 	)
 	
@@ -989,6 +1106,9 @@ func createUI(w fyne.Window, state *AppState) fyne.CanvasObject {
 	})
 	settingsBtn.Importance = widget.LowImportance
 	
+	// Usage and cost of the requests to the LLM
+	usageLabel := widget.NewLabelWithStyle("", fyne.TextAlignTrailing, fyne.TextStyle{})
+	
 	// Create an improved status bar with multiple sections and better styling
 	statusBackground := canvas.NewRectangle(color.NRGBA{R: 40, G: 40, B: 45, A: 255})
 	statusContainer := container.NewMax(
@@ -998,6 +1118,7 @@ func createUI(w fyne.Window, state *AppState) fyne.CanvasObject {
 				statusIcon,
 				statusMessage,
 				layout.NewSpacer(),
+				usageLabel,
 				settingsBtn, // Add settings button to status bar
 				currentTime,
 			),
@@ -1043,15 +1164,18 @@ func createUI(w fyne.Window, state *AppState) fyne.CanvasObject {
 	// Store UI elements in the state for later access
 	state.ui = &uiElements{
 		statusBar:          statusMessage,
+		usageLabel:         usageLabel,
 		codeOutput:         codeOutput,
 		astOutput:          astOutput,
 		semanticOutput:     semanticOutput,
 		modelSelector:      nil,
 		intentInput:        intentInput,
+		cancelButton:       cancelButton,
 		fileExplorer:       fileExplorer,
 		fileContentDisplay: fileContentDisplay,
 		filePathLabel:      filePathLabel,
 	}
+	updateUsageLabel(state)
 	
 	return content
 }
@@ -1059,7 +1183,7 @@ func createUI(w fyne.Window, state *AppState) fyne.CanvasObject {
 // showModelInfo displays information about the currently selected model
 func showModelInfo(w fyne.Window, state *AppState) {
 	// Find the selected model in cache
-	var selectedModel *OpenRouterModel
+	var selectedModel *llm.Model
 	for _, model := range modelsCache.Models {
 		if model.ID == state.selectedModel {
 			selectedModel = &model
@@ -1095,7 +1219,7 @@ func refreshModelsList(w fyne.Window, state *AppState) {
 	
 	// Start asynchronous operation
 	go func() {
-		modelIDs, err := fetchAvailableModels()
+		modelIDs, err := fetchAvailableModels(state.llmClient)
 		
 		// Close progress dialog
 		progress.Hide()
@@ -1125,16 +1249,13 @@ func createModelSelector(state *AppState) *widget.Select {
 	// Create selector with default model
 	selector := widget.NewSelect(modelNames, func(selected string) {
 		state.selectedModel = selected
-		if state.llmClient != nil {
-			state.llmClient.SetModel(selected)
-		}
 	})
 	
 	selector.Selected = state.selectedModel
 	
 	// Asynchronously fetch models from API and update the selector
 	go func() {
-		modelIDs, err := fetchAvailableModels()
+		modelIDs, err := fetchAvailableModels(state.llmClient)
 		if err != nil {
 			log.Printf("Failed to fetch models: %v", err)
 			return
@@ -1157,9 +1278,6 @@ func createModelSelector(state *AppState) *widget.Select {
 			if !found && len(modelIDs) > 0 {
 				state.selectedModel = modelIDs[0]
 				selector.Selected = modelIDs[0]
-				if state.llmClient != nil {
-					state.llmClient.SetModel(modelIDs[0])
-				}
 			}
 			
 			selector.Refresh()
@@ -1199,8 +1317,27 @@ func executeIntent(intentText string, state *AppState, w fyne.Window) {
 		return
 	}
 	
-	// Show loading dialog
-	progress := dialog.NewProgress("Processing Intent", "Analyzing your development intent...", w)
+	// Cancel an intent that is still running
+	ctx, cancel := context.WithCancel(context.Background())
+	state.intentMu.Lock()
+	if state.cancelIntent != nil {
+		state.cancelIntent()
+	}
+	state.intentCtx, state.cancelIntent = ctx, cancel
+	state.intentMu.Unlock()
+	state.ui.cancelButton.Enable()
+	
+	// The intent is processed with the model selected when it starts
+	opts := intent.Options{Model: state.selectedModel}
+	
+	// Show loading dialog with a button cancelling the intent
+	progress := dialog.NewCustomWithoutButtons("Processing Intent", container.NewVBox(
+		widget.NewLabel("Analyzing your development intent..."),
+		widget.NewProgressBarInfinite(),
+	), w)
+	progress.SetButtons([]fyne.CanvasObject{
+		widget.NewButtonWithIcon("Cancel", theme.CancelIcon(), cancel),
+	})
 	progress.Show()
 	
 	// Update status
@@ -1208,32 +1345,43 @@ func executeIntent(intentText string, state *AppState, w fyne.Window) {
 	
 	// Start asynchronous operation
 	go func() {
-		// Parse the intent with timeout and error handling
-		var parsedIntent interface{}
-		var parseErr error
-		
-		// Create a timeout channel
-		parseTimeout := time.After(30 * time.Second)
-		parseComplete := make(chan bool, 1)
-		
-		// Execute intent parsing in a separate goroutine
-		go func() {
-			parsedIntent, parseErr = state.intentProcessor.ParseIntent(intentText)
-			parseComplete <- true
+		defer func() {
+			cancel()
+			// Unless another intent was started in the meantime
+			state.intentMu.Lock()
+			current := state.intentCtx == ctx
+			state.intentMu.Unlock()
+			if current {
+				state.ui.cancelButton.Disable()
+			}
+			updateUsageLabel(state)
 		}()
 		
-		// Wait for either completion or timeout
-		select {
-		case <-parseComplete:
-			// Continue processing
-		case <-parseTimeout:
+		// Parse the intent with timeout and error handling
+		parseCtx, parseCancel := context.WithTimeout(ctx, 30*time.Second)
+		parsedIntent, parseErr := state.intentProcessor.ParseIntentContext(parseCtx, intentText, opts)
+		parseCancel()
+		
+		// Check for parse errors
+		if errors.Is(parseErr, context.Canceled) {
+			progress.Hide()
+			state.ui.statusBar.SetText("Intent cancelled")
+			return
+		}
+		if errors.Is(parseErr, context.DeadlineExceeded) {
 			progress.Hide()
 			dialog.ShowError(fmt.Errorf("Intent parsing timed out after 30 seconds"), w)
 			state.ui.statusBar.SetText("Error: Intent parsing timed out")
 			return
 		}
-		
-		// Check for parse errors
+		if hint := llmErrorHint(parseErr, state.provider); hint != "" {
+			progress.Hide()
+			log.Printf("Intent parsing error: %v", parseErr)
+			dialog.ShowError(fmt.Errorf("%s", hint), w)
+			state.ui.statusBar.SetText("Error: " + hint)
+			state.ui.codeOutput.SetText("// " + hint + "\n\n// Error: " + parseErr.Error())
+			return
+		}
 		if parseErr != nil {
 			progress.Hide()
 			log.Printf("Intent parsing error: %v", parseErr)
@@ -1249,41 +1397,41 @@ func executeIntent(intentText string, state *AppState, w fyne.Window) {
 			return
 		}
 		
-		// Execute the intent with timeout
-		var result interface{}
-		var execErr error
-		
-		// Create a timeout channel for execution
-		execTimeout := time.After(60 * time.Second)
-		execComplete := make(chan bool, 1)
+		// Execute the intent with timeout, showing the code as it is
+		// generated instead of the progress dialog
+		execCtx, execCancel := context.WithTimeout(ctx, 60*time.Second)
+		defer execCancel()
 		
-		// Execute intent in a separate goroutine
-		go func() {
-			// Type assertion for parsedIntent
-			intentPtr, ok := parsedIntent.(*intent.Intent)
-			if !ok {
-				execErr = fmt.Errorf("unexpected intent type: %T", parsedIntent)
-				execComplete <- true
-				return
+		var code strings.Builder
+		result, execErr := state.intentProcessor.ExecuteIntentStream(execCtx, parsedIntent, opts, func(piece string) {
+			if code.Len() == 0 {
+				progress.Hide()
+				state.ui.statusBar.SetText("Generating code... (press Cancel to stop)")
 			}
-			result, execErr = state.intentProcessor.ExecuteIntent(intentPtr)
-			execComplete <- true
-		}()
+			code.WriteString(piece)
+			state.ui.codeOutput.SetText(code.String())
+		})
 		
-		// Wait for either completion or timeout
-		select {
-		case <-execComplete:
-			// Continue processing
-		case <-execTimeout:
-			progress.Hide()
+		// Update UI after execution is complete
+		progress.Hide()
+		
+		if errors.Is(execErr, context.Canceled) {
+			state.ui.statusBar.SetText("Intent cancelled")
+			return
+		}
+		if errors.Is(execErr, context.DeadlineExceeded) {
 			dialog.ShowError(fmt.Errorf("Intent execution timed out after 60 seconds"), w)
 			state.ui.statusBar.SetText("Error: Intent execution timed out")
 			return
 		}
 		
-		// Update UI after execution is complete
-		progress.Hide()
-		
+		if hint := llmErrorHint(execErr, state.provider); hint != "" {
+			log.Printf("Intent execution error: %v", execErr)
+			dialog.ShowError(fmt.Errorf("%s", hint), w)
+			state.ui.statusBar.SetText("Error: " + hint)
+			state.ui.codeOutput.SetText("// " + hint + "\n\n// Error: " + execErr.Error())
+			return
+		}
 		if execErr != nil {
 			log.Printf("Intent execution error: %v", execErr)
 			dialog.ShowError(fmt.Errorf("Failed to execute intent: %v", execErr), w)
@@ -1296,7 +1444,13 @@ func executeIntent(intentText string, state *AppState, w fyne.Window) {
 		}
 		
 		// Handle the result
-		if resultMap, ok := result.(map[string]interface{}); ok {
+		if impact, ok := result.(*intent.ImpactResult); ok {
+			// Modifications and deletions show what they would affect
+			state.ui.codeOutput.SetText("// No code was generated for this intent")
+			state.ui.astOutput.SetText("// No AST representation was generated")
+			state.ui.semanticOutput.SetText(impact.Report)
+			state.ui.statusBar.SetText(fmt.Sprintf("Intent processed: %d entities found", len(impact.Entities)))
+		} else if resultMap, ok := result.(map[string]interface{}); ok {
 			// Update code output
 			if code, ok := resultMap["code"].(string); ok && code != "" {
 				state.ui.codeOutput.SetText(code)
@@ -1342,7 +1496,12 @@ func executeIntent(intentText string, state *AppState, w fyne.Window) {
 				state.ui.semanticOutput.SetText("// No semantic model was generated")
 			}
 			
-			state.ui.statusBar.SetText("Intent processed successfully")
+			// Tell the user when the model's answer is incomplete
+			if truncated, ok := resultMap["truncated"]; ok {
+				state.ui.statusBar.SetText("Intent processed, but " + truncated + ". Select a model with a larger output limit.")
+			} else {
+				state.ui.statusBar.SetText("Intent processed successfully")
+			}
 		} else {
 			// Handle unexpected result format
 			log.Printf("Unexpected result format: %T", result)
@@ -1389,6 +1548,57 @@ func executeIntent(intentText string, state *AppState, w fyne.Window) {
 	}()
 }
 
+// updateUsageLabel shows the cost of the requests to the LLM in the status
+// bar, for the session, the day and the project
+func updateUsageLabel(state *AppState) {
+	if state.ui == nil || state.ui.usageLabel == nil {
+		return
+	}
+	report := state.usage.Report()
+	text := fmt.Sprintf("Session $%.4f · Today $%.4f · Project $%.4f (%d tokens)",
+		report.Session.Cost, report.Today.Cost, report.Total.Cost,
+		report.Total.PromptTokens+report.Total.CompletionTokens)
+	if len(report.Unpriced) > 0 {
+		// Their requests are counted as free
+		text += " · no prices for " + strings.Join(report.Unpriced, ", ")
+	}
+	state.ui.usageLabel.SetText(text)
+}
+
+// providerTitles are the names of the LLM providers shown to the user
+var providerTitles = map[string]string{
+	llm.ProviderOpenRouter: "OpenRouter",
+	llm.ProviderOpenAI:     "OpenAI",
+	llm.ProviderAnthropic:  "Anthropic",
+	llm.ProviderOllama:     "Ollama",
+}
+
+// llmErrorHint tells the user what to do about an error of the LLM API, or
+// returns "" for other errors
+func llmErrorHint(err error, provider string) string {
+	switch {
+	case errors.Is(err, llm.ErrAuth):
+		switch provider {
+		case "", llm.ProviderOpenRouter:
+			return "Your OpenRouter API key was rejected. Click the Settings icon in the status bar to enter a valid key."
+		case llm.ProviderOpenAI, llm.ProviderAnthropic:
+			return fmt.Sprintf("Your %s API key was rejected. Set a valid key in LLM_API_KEY or %s_API_KEY and restart.", providerTitles[provider], strings.ToUpper(provider))
+		default:
+			return fmt.Sprintf("The %s server rejected the API key. Set a valid key in LLM_API_KEY and restart.", providerTitles[provider])
+		}
+	case errors.Is(err, llm.ErrRateLimited):
+		return "The model is rate limited. Wait a moment and try again, or select another model."
+	case errors.Is(err, llm.ErrContextTooLong):
+		return "The request is too long for the selected model. Shorten the intent or select a model with a larger context."
+	case errors.Is(err, llm.ErrModelUnavailable):
+		return "The selected model is currently unavailable. Select another model."
+	case errors.Is(err, llm.ErrBudgetExceeded):
+		return "The usage budget is spent. Raise LLM_BUDGET_SESSION, LLM_BUDGET_PROJECT or LLM_BUDGET_DAILY to continue."
+	default:
+		return ""
+	}
+}
+
 // convertToStringMap attempts to convert various result formats to a map[string]string
 func convertToStringMap(result interface{}) (map[string]string, bool) {
 	// Try to handle different output formats
@@ -0,0 +1,273 @@
+package ast
+
+import (
+	"bytes"
+	"go/ast"
+	"go/printer"
+	"go/token"
+	"reflect"
+	"strings"
+)
+
+// Metadata keys used on converted nodes
+const (
+	MetaRole     = "role"     // name of the go/ast field the node was found in
+	MetaPos      = "pos"      // Position of the first character of the node
+	MetaEnd      = "end"      // Position immediately after the node
+	MetaTokens   = "tokens"   // byte offsets of the node's token.Pos fields
+	MetaType     = "type"     // source form of the node's type expression
+	MetaDoc      = "doc"      // text of the node's doc comment
+	MetaComment  = "comment"  // text of the node's trailing line comment
+	MetaComments = "comments" // every comment in a file, see CommentInfo
+	MetaLines    = "lines"    // line start offsets of the file
+	MetaSize     = "size"     // size of the file in bytes
+	MetaKind     = "kind"     // token kind of a BasicLit
+	MetaFilename = "filename" // name of a parsed file
+)
+
+// Position describes a location in the parsed source
+type Position struct {
+	Offset int `json:"offset"`
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+// CommentInfo describes a single comment of a file. Comments sharing a
+// Group index belong to the same comment group.
+type CommentInfo struct {
+	Group  int    `json:"group"`
+	Offset int    `json:"offset"`
+	Text   string `json:"text"`
+}
+
+var (
+	nodeInterface = reflect.TypeOf((*ast.Node)(nil)).Elem()
+	posType       = reflect.TypeOf(token.Pos(0))
+	tokenType     = reflect.TypeOf(token.ILLEGAL)
+)
+
+// valueFields names the go/ast field mirrored in Node.Value for node types
+// whose Value carries source information
+var valueFields = map[string]string{
+	"Ident":      "Name",
+	"BasicLit":   "Value",
+	"BinaryExpr": "Op",
+	"UnaryExpr":  "Op",
+	"AssignStmt": "Tok",
+	"IncDecStmt": "Tok",
+	"BranchStmt": "Tok",
+	"GenDecl":    "Tok",
+	"RangeStmt":  "Tok",
+}
+
+// skippedFields lists go/ast fields that are derived from the rest of the
+// tree and are therefore not represented
+var skippedFields = map[string]bool{
+	"Obj":        true,
+	"Scope":      true,
+	"Imports":    true,
+	"Unresolved": true,
+	"Comments":   true,
+}
+
+// converter turns a go/ast tree into our Node representation
+type converter struct {
+	fset *token.FileSet
+}
+
+// convert converts n and its subtree; role is the go/ast field n was found in
+func (c *converter) convert(n ast.Node, role string) *Node {
+	v := reflect.ValueOf(n)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return nil
+	}
+	s := v.Elem()
+	t := s.Type()
+
+	node := &Node{
+		Type:     t.Name(),
+		Children: []*Node{},
+		Metadata: map[string]interface{}{},
+	}
+	if role != "" {
+		node.Metadata[MetaRole] = role
+	}
+	if pos, ok := c.position(n.Pos()); ok {
+		node.Metadata[MetaPos] = pos
+	}
+	if end, ok := c.position(n.End()); ok {
+		node.Metadata[MetaEnd] = end
+	}
+
+	tokens := map[string]int{}
+	valueField := valueFields[node.Type]
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		fv := s.Field(i)
+		if !field.IsExported() || skippedFields[field.Name] {
+			continue
+		}
+
+		switch {
+		case field.Type == posType:
+			if pos := fv.Interface().(token.Pos); pos.IsValid() {
+				tokens[field.Name] = c.fset.Position(pos).Offset
+			}
+
+		case field.Type == reflect.TypeOf((*ast.CommentGroup)(nil)):
+			group := fv.Interface().(*ast.CommentGroup)
+			if group == nil {
+				continue
+			}
+			key := MetaComment
+			if field.Name == "Doc" {
+				key = MetaDoc
+			}
+			node.Metadata[key] = group.Text()
+			tokens[field.Name] = c.fset.Position(group.Pos()).Offset
+
+		case field.Type.Kind() == reflect.Slice && isNodeType(field.Type.Elem()):
+			for j := 0; j < fv.Len(); j++ {
+				if child := c.convertValue(fv.Index(j), field.Name); child != nil {
+					child.Parent = node
+					node.Children = append(node.Children, child)
+				}
+			}
+
+		case isNodeType(field.Type):
+			if child := c.convertValue(fv, field.Name); child != nil {
+				child.Parent = node
+				node.Children = append(node.Children, child)
+				if field.Name == "Type" {
+					node.Metadata[MetaType] = c.source(fv.Interface().(ast.Node))
+				}
+			}
+
+		case field.Name == valueField:
+			if field.Type == tokenType {
+				tok := fv.Interface().(token.Token)
+				if tok != token.ILLEGAL {
+					node.Value = tok.String()
+				}
+			} else {
+				node.Value = fv.String()
+			}
+
+		case field.Type == tokenType:
+			node.Metadata[lowerFirst(field.Name)] = fv.Interface().(token.Token).String()
+
+		case field.Type.Kind() == reflect.Bool:
+			if fv.Bool() {
+				node.Metadata[lowerFirst(field.Name)] = true
+			}
+
+		case field.Type.Kind() == reflect.String:
+			if fv.String() != "" {
+				node.Metadata[lowerFirst(field.Name)] = fv.String()
+			}
+
+		case field.Type.Kind() == reflect.Int:
+			node.Metadata[lowerFirst(field.Name)] = int(fv.Int())
+		}
+	}
+
+	if len(tokens) > 0 {
+		node.Metadata[MetaTokens] = tokens
+	}
+
+	// Declarations carry their name in Value as well, which makes the tree
+	// easier to search; it is not used when generating code
+	switch decl := n.(type) {
+	case *ast.FuncDecl:
+		node.Value = decl.Name.Name
+	case *ast.TypeSpec:
+		node.Value = decl.Name.Name
+	case *ast.ImportSpec:
+		node.Value = decl.Path.Value
+	case *ast.File:
+		node.Value = decl.Name.Name
+		c.fileMetadata(decl, node)
+	}
+
+	return node
+}
+
+// convertValue converts a reflected field value holding a go/ast node
+func (c *converter) convertValue(v reflect.Value, role string) *Node {
+	if v.Kind() == reflect.Interface || v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return nil
+		}
+	}
+	n, ok := v.Interface().(ast.Node)
+	if !ok {
+		return nil
+	}
+	return c.convert(n, role)
+}
+
+// fileMetadata records the comments and line table of a file so that the
+// source layout can be restored when generating code
+func (c *converter) fileMetadata(file *ast.File, node *Node) {
+	var comments []CommentInfo
+	for i, group := range file.Comments {
+		for _, comment := range group.List {
+			comments = append(comments, CommentInfo{
+				Group:  i,
+				Offset: c.fset.Position(comment.Slash).Offset,
+				Text:   comment.Text,
+			})
+		}
+	}
+	if len(comments) > 0 {
+		node.Metadata[MetaComments] = comments
+	}
+
+	if tf := c.fset.File(file.Pos()); tf != nil {
+		node.Metadata[MetaLines] = tf.Lines()
+		node.Metadata[MetaSize] = tf.Size()
+		if tf.Name() != "" {
+			node.Metadata[MetaFilename] = tf.Name()
+		}
+	}
+}
+
+// position converts a token.Pos into a Position
+func (c *converter) position(pos token.Pos) (Position, bool) {
+	if !pos.IsValid() {
+		return Position{}, false
+	}
+	p := c.fset.Position(pos)
+	return Position{Offset: p.Offset, Line: p.Line, Column: p.Column}, true
+}
+
+// source renders a node back to source form
+func (c *converter) source(n ast.Node) string {
+	var buf bytes.Buffer
+	if err := printer.Fprint(&buf, c.fset, n); err != nil {
+		return ""
+	}
+	return buf.String()
+}
+
+// isNodeType reports whether values of type t hold go/ast nodes that we
+// represent as children
+func isNodeType(t reflect.Type) bool {
+	if t == reflect.TypeOf((*ast.CommentGroup)(nil)) {
+		return false
+	}
+	if t.Kind() == reflect.Interface {
+		return t.Implements(nodeInterface)
+	}
+	return t.Kind() == reflect.Ptr && t.Implements(nodeInterface)
+}
+
+// lowerFirst lower-cases the first letter of a field name for use as a
+// metadata key
+func lowerFirst(s string) string {
+	if s == "" {
+		return s
+	}
+	return strings.ToLower(s[:1]) + s[1:]
+}
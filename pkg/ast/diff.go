@@ -0,0 +1,92 @@
+package ast
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Difference describes one place where two trees disagree
+type Difference struct {
+	Path     string `json:"path"`
+	Expected string `json:"expected"`
+	Actual   string `json:"actual"`
+}
+
+// String formats the difference for display
+func (d Difference) String() string {
+	path := d.Path
+	if path == "" {
+		path = "/"
+	}
+	return fmt.Sprintf("%s: expected %s, got %s", path, d.Expected, d.Actual)
+}
+
+// Diff compares actual against expected and lists where they differ.
+// Like Equal it ignores positions and layout; below a node whose type
+// differs the subtrees are not compared any further.
+func Diff(expected, actual *Node) []Difference {
+	var diffs []Difference
+	diffNodes(expected, actual, "", &diffs)
+	return diffs
+}
+
+// diffNodes compares two nodes found at path
+func diffNodes(expected, actual *Node, path string, diffs *[]Difference) {
+	switch {
+	case expected == nil && actual == nil:
+		return
+	case expected == nil:
+		*diffs = append(*diffs, Difference{Path: path, Expected: "nothing", Actual: describe(actual)})
+		return
+	case actual == nil:
+		*diffs = append(*diffs, Difference{Path: path, Expected: describe(expected), Actual: "nothing"})
+		return
+	}
+
+	if expected.Type != actual.Type || expected.Value != actual.Value {
+		*diffs = append(*diffs, Difference{Path: path, Expected: describe(expected), Actual: describe(actual)})
+		if expected.Type != actual.Type {
+			return
+		}
+	}
+
+	count := len(expected.Children)
+	if len(actual.Children) > count {
+		count = len(actual.Children)
+	}
+	for i := 0; i < count; i++ {
+		var e, a *Node
+		if i < len(expected.Children) {
+			e = expected.Children[i]
+		}
+		if i < len(actual.Children) {
+			a = actual.Children[i]
+		}
+		diffNodes(e, a, childPath(path, e, a, i), diffs)
+	}
+}
+
+// childPath names the i-th child of the node at path in the form accepted
+// by FindPath. The file below a Program has no role and shares its path.
+func childPath(path string, expected, actual *Node, i int) string {
+	n := expected
+	if n == nil {
+		n = actual
+	}
+	role := roleOf(n)
+	if role == "" {
+		if n.Type == "File" {
+			return path
+		}
+		return strings.TrimPrefix(fmt.Sprintf("%s/%d", path, i), "/")
+	}
+	return strings.TrimPrefix(fmt.Sprintf("%s/%s[%d]", path, role, indexInRole(n)), "/")
+}
+
+// describe summarises a node for a Difference
+func describe(n *Node) string {
+	if n.Value == "" {
+		return n.Type
+	}
+	return fmt.Sprintf("%s %q", n.Type, n.Value)
+}
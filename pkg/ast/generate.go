@@ -0,0 +1,447 @@
+package ast
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/token"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// nodeTypes maps the Type of a Node to the go/ast type it is built from
+var nodeTypes = map[string]reflect.Type{}
+
+// tokens maps the source form of a token back to the token
+var tokens = map[string]token.Token{}
+
+func init() {
+	for _, n := range []ast.Node{
+		// Expressions and types
+		&ast.BadExpr{}, &ast.Ident{}, &ast.Ellipsis{}, &ast.BasicLit{},
+		&ast.FuncLit{}, &ast.CompositeLit{}, &ast.ParenExpr{},
+		&ast.SelectorExpr{}, &ast.IndexExpr{}, &ast.IndexListExpr{},
+		&ast.SliceExpr{}, &ast.TypeAssertExpr{}, &ast.CallExpr{},
+		&ast.StarExpr{}, &ast.UnaryExpr{}, &ast.BinaryExpr{},
+		&ast.KeyValueExpr{}, &ast.ArrayType{}, &ast.StructType{},
+		&ast.FuncType{}, &ast.InterfaceType{}, &ast.MapType{}, &ast.ChanType{},
+		&ast.Field{}, &ast.FieldList{},
+
+		// Statements
+		&ast.BadStmt{}, &ast.DeclStmt{}, &ast.EmptyStmt{}, &ast.LabeledStmt{},
+		&ast.ExprStmt{}, &ast.SendStmt{}, &ast.IncDecStmt{}, &ast.AssignStmt{},
+		&ast.GoStmt{}, &ast.DeferStmt{}, &ast.ReturnStmt{}, &ast.BranchStmt{},
+		&ast.BlockStmt{}, &ast.IfStmt{}, &ast.CaseClause{}, &ast.SwitchStmt{},
+		&ast.TypeSwitchStmt{}, &ast.CommClause{}, &ast.SelectStmt{},
+		&ast.ForStmt{}, &ast.RangeStmt{},
+
+		// Declarations
+		&ast.ImportSpec{}, &ast.ValueSpec{}, &ast.TypeSpec{},
+		&ast.BadDecl{}, &ast.GenDecl{}, &ast.FuncDecl{}, &ast.File{},
+	} {
+		t := reflect.TypeOf(n).Elem()
+		nodeTypes[t.Name()] = t
+	}
+
+	for tok := token.ILLEGAL; tok <= token.TILDE; tok++ {
+		tokens[tok.String()] = tok
+	}
+}
+
+// builder turns a Node tree back into a go/ast tree
+type builder struct {
+	file   *token.File
+	groups map[int]*ast.CommentGroup
+}
+
+// newBuilder prepares a builder for n, restoring the line table of the file
+// n belongs to so that the original layout can be reproduced
+func newBuilder(fset *token.FileSet, n *Node) *builder {
+	b := &builder{groups: map[int]*ast.CommentGroup{}}
+
+	file := n
+	for file != nil && file.Type != "File" {
+		if file.Type == "Program" && len(file.Children) > 0 {
+			file = file.Children[0]
+			continue
+		}
+		file = file.Parent
+	}
+	if file == nil {
+		return b
+	}
+
+	size, ok := intValue(file.Metadata[MetaSize])
+	lines := intSlice(file.Metadata[MetaLines])
+	if !ok || len(lines) == 0 {
+		return b
+	}
+	filename, _ := file.Metadata[MetaFilename].(string)
+	b.file = fset.AddFile(filename, -1, size)
+	if !b.file.SetLines(lines) {
+		b.file = nil
+		return b
+	}
+
+	for _, group := range b.commentGroups(file) {
+		b.groups[b.file.Offset(group.Pos())] = group
+	}
+	return b
+}
+
+// pos converts an offset back into a token.Pos
+func (b *builder) pos(offset int) token.Pos {
+	if b.file == nil || offset < 0 || offset > b.file.Size() {
+		return token.NoPos
+	}
+	return b.file.Pos(offset)
+}
+
+// commentGroups rebuilds the comment groups recorded on a File node
+func (b *builder) commentGroups(file *Node) []*ast.CommentGroup {
+	var groups []*ast.CommentGroup
+	current := -1
+	for _, info := range commentInfos(file.Metadata[MetaComments]) {
+		if info.Group != current || len(groups) == 0 {
+			groups = append(groups, &ast.CommentGroup{})
+			current = info.Group
+		}
+		group := groups[len(groups)-1]
+		group.List = append(group.List, &ast.Comment{Slash: b.pos(info.Offset), Text: info.Text})
+	}
+	return groups
+}
+
+// build converts n and its subtree into a go/ast node
+func (b *builder) build(n *Node) (ast.Node, error) {
+	t, ok := nodeTypes[n.Type]
+	if !ok {
+		return nil, fmt.Errorf("unknown node type %q", n.Type)
+	}
+	v := reflect.New(t)
+	s := v.Elem()
+
+	tokenOffsets := intMap(n.Metadata[MetaTokens])
+	valueField := valueFields[n.Type]
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		fv := s.Field(i)
+		if !field.IsExported() || skippedFields[field.Name] {
+			continue
+		}
+
+		switch {
+		case field.Type == posType:
+			if offset, ok := tokenOffsets[field.Name]; ok {
+				fv.Set(reflect.ValueOf(b.pos(offset)))
+			}
+
+		case field.Type == reflect.TypeOf((*ast.CommentGroup)(nil)):
+			if group := b.commentGroup(n, field.Name, tokenOffsets); group != nil {
+				fv.Set(reflect.ValueOf(group))
+			}
+
+		case field.Name == valueField:
+			if err := setScalar(fv, n.Value); err != nil {
+				return nil, fmt.Errorf("%s: %w", n.Type, err)
+			}
+
+		case field.Type == tokenType || field.Type.Kind() == reflect.Bool ||
+			field.Type.Kind() == reflect.String || field.Type.Kind() == reflect.Int:
+			if value, ok := n.Metadata[lowerFirst(field.Name)]; ok {
+				if err := setScalar(fv, value); err != nil {
+					return nil, fmt.Errorf("%s.%s: %w", n.Type, field.Name, err)
+				}
+			}
+		}
+	}
+
+	for _, child := range n.Children {
+		role, _ := child.Metadata[MetaRole].(string)
+		field, ok := t.FieldByName(role)
+		if !ok || skippedFields[role] {
+			return nil, fmt.Errorf("%s has no field %q for child %s", n.Type, role, child.Type)
+		}
+		built, err := b.build(child)
+		if err != nil {
+			return nil, err
+		}
+		cv := reflect.ValueOf(built)
+		fv := s.FieldByIndex(field.Index)
+
+		if field.Type.Kind() == reflect.Slice {
+			if !cv.Type().AssignableTo(field.Type.Elem()) {
+				return nil, fmt.Errorf("%s.%s cannot hold %s", n.Type, role, child.Type)
+			}
+			fv.Set(reflect.Append(fv, cv))
+			continue
+		}
+		if !cv.Type().AssignableTo(field.Type) {
+			return nil, fmt.Errorf("%s.%s cannot hold %s", n.Type, role, child.Type)
+		}
+		if !fv.IsZero() {
+			return nil, fmt.Errorf("%s.%s is set more than once", n.Type, role)
+		}
+		fv.Set(cv)
+	}
+
+	node := v.Interface().(ast.Node)
+	if file, ok := node.(*ast.File); ok {
+		b.finishFile(file)
+	}
+	return node, nil
+}
+
+// commentGroup restores the doc or line comment of a node. Comments that
+// were parsed are looked up by position; comments added to the tree later
+// only have their text and are rebuilt as line comments.
+func (b *builder) commentGroup(n *Node, field string, offsets map[string]int) *ast.CommentGroup {
+	if offset, ok := offsets[field]; ok {
+		if group, ok := b.groups[offset]; ok {
+			return group
+		}
+	}
+
+	key := MetaComment
+	if field == "Doc" {
+		key = MetaDoc
+	}
+	text, _ := n.Metadata[key].(string)
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return nil
+	}
+
+	group := &ast.CommentGroup{}
+	for _, line := range strings.Split(text, "\n") {
+		group.List = append(group.List, &ast.Comment{Text: strings.TrimRight("// "+line, " ")})
+	}
+	return group
+}
+
+// finishFile fills in the fields of a file that are derived from its
+// declarations and comments
+func (b *builder) finishFile(file *ast.File) {
+	for _, decl := range file.Decls {
+		gen, ok := decl.(*ast.GenDecl)
+		if !ok || gen.Tok != token.IMPORT {
+			continue
+		}
+		for _, spec := range gen.Specs {
+			if imp, ok := spec.(*ast.ImportSpec); ok {
+				file.Imports = append(file.Imports, imp)
+			}
+		}
+	}
+
+	for _, group := range b.groups {
+		file.Comments = append(file.Comments, group)
+	}
+	sort.Slice(file.Comments, func(i, j int) bool {
+		return file.Comments[i].Pos() < file.Comments[j].Pos()
+	})
+}
+
+// GoFile rebuilds the go/ast file a Program or File node describes,
+// together with the file set its positions refer to
+func (n *Node) GoFile() (*token.FileSet, *ast.File, error) {
+	file := n
+	if file != nil && file.Type == "Program" && len(file.Children) > 0 {
+		file = file.Children[0]
+	}
+	if file == nil || file.Type != "File" {
+		return nil, nil, fmt.Errorf("node is not a Go file")
+	}
+
+	fset := token.NewFileSet()
+	built, err := newBuilder(fset, file).build(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	return fset, built.(*ast.File), nil
+}
+
+// generateCode builds the go/ast tree for node and formats it
+func generateCode(node *Node) (string, error) {
+	if node == nil {
+		return "", fmt.Errorf("no node to generate code from")
+	}
+	if node.Type == "Program" {
+		if len(node.Children) == 0 {
+			return "", fmt.Errorf("program has no file")
+		}
+		node = node.Children[0]
+	}
+
+	fset := token.NewFileSet()
+	b := newBuilder(fset, node)
+	built, err := b.build(node)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	if err := format.Node(&buf, fset, built); err != nil {
+		return "", fmt.Errorf("error formatting code: %w", err)
+	}
+	if _, ok := built.(*ast.File); !ok {
+		return buf.String(), nil
+	}
+
+	// Nodes added by ModifyAST have no positions, which can leave the
+	// printer's line decisions inconsistent; a second pass settles them
+	formatted, err := format.Source(buf.Bytes())
+	if err != nil {
+		return "", fmt.Errorf("error formatting code: %w", err)
+	}
+	return string(formatted), nil
+}
+
+// Equal reports whether two trees describe the same code. Positions and
+// layout information are ignored.
+func Equal(a, b *Node) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	if a.Type != b.Type || a.Value != b.Value || len(a.Children) != len(b.Children) {
+		return false
+	}
+	if !equalMetadata(a.Metadata, b.Metadata) || !equalMetadata(b.Metadata, a.Metadata) {
+		return false
+	}
+	for i := range a.Children {
+		if !Equal(a.Children[i], b.Children[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// layoutKeys are metadata keys that only describe where code was found
+var layoutKeys = map[string]bool{
+	MetaPos:      true,
+	MetaEnd:      true,
+	MetaTokens:   true,
+	MetaComments: true,
+	MetaLines:    true,
+	MetaSize:     true,
+	MetaFilename: true,
+}
+
+// equalMetadata reports whether every non-layout entry of a is in b
+func equalMetadata(a, b map[string]interface{}) bool {
+	for key, value := range a {
+		if layoutKeys[key] {
+			continue
+		}
+		other, ok := b[key]
+		if !ok || fmt.Sprint(value) != fmt.Sprint(other) {
+			return false
+		}
+	}
+	return true
+}
+
+// setScalar stores a metadata value in a scalar go/ast field
+func setScalar(fv reflect.Value, value interface{}) error {
+	switch {
+	case fv.Type() == tokenType:
+		s, _ := value.(string)
+		tok, ok := tokens[s]
+		if !ok && s != "" {
+			return fmt.Errorf("unknown token %q", s)
+		}
+		fv.Set(reflect.ValueOf(tok))
+	case fv.Kind() == reflect.String:
+		s, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("expected string, got %T", value)
+		}
+		fv.SetString(s)
+	case fv.Kind() == reflect.Bool:
+		b, ok := value.(bool)
+		if !ok {
+			return fmt.Errorf("expected bool, got %T", value)
+		}
+		fv.SetBool(b)
+	case fv.Kind() == reflect.Int:
+		i, ok := intValue(value)
+		if !ok {
+			return fmt.Errorf("expected number, got %T", value)
+		}
+		fv.SetInt(int64(i))
+	}
+	return nil
+}
+
+// intValue reads an integer stored in metadata, which holds float64 values
+// once it has been through encoding/json
+func intValue(v interface{}) (int, bool) {
+	switch n := v.(type) {
+	case int:
+		return n, true
+	case float64:
+		return int(n), true
+	}
+	return 0, false
+}
+
+// intSlice reads a list of integers stored in metadata
+func intSlice(v interface{}) []int {
+	switch list := v.(type) {
+	case []int:
+		return list
+	case []interface{}:
+		result := make([]int, 0, len(list))
+		for _, item := range list {
+			if n, ok := intValue(item); ok {
+				result = append(result, n)
+			}
+		}
+		return result
+	}
+	return nil
+}
+
+// intMap reads a map of integers stored in metadata
+func intMap(v interface{}) map[string]int {
+	switch m := v.(type) {
+	case map[string]int:
+		return m
+	case map[string]interface{}:
+		result := make(map[string]int, len(m))
+		for key, item := range m {
+			if n, ok := intValue(item); ok {
+				result[key] = n
+			}
+		}
+		return result
+	}
+	return nil
+}
+
+// commentInfos reads the comment list stored on a File node
+func commentInfos(v interface{}) []CommentInfo {
+	switch list := v.(type) {
+	case []CommentInfo:
+		return list
+	case []interface{}:
+		result := make([]CommentInfo, 0, len(list))
+		for _, item := range list {
+			m, ok := item.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			info := CommentInfo{}
+			info.Group, _ = intValue(m["group"])
+			info.Offset, _ = intValue(m["offset"])
+			info.Text, _ = m["text"].(string)
+			result = append(result, info)
+		}
+		return result
+	}
+	return nil
+}
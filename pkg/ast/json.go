@@ -0,0 +1,218 @@
+package ast
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// SchemaVersion is the version of the JSON format written by MarshalJSON.
+// Documents without a version are read as the plain nested format used
+// before the schema was introduced.
+const SchemaVersion = 1
+
+// document is the top-level JSON form of a tree
+//
+//	{
+//	  "version": 1,
+//	  "root": {
+//	    "id": "n0",
+//	    "type": "Program",
+//	    "children": [
+//	      {"id": "n1", "parentId": "n0", "type": "File", "value": "main",
+//	       "pos": {"offset": 0, "line": 1, "column": 1}, ...}
+//	    ]
+//	  }
+//	}
+type document struct {
+	Version int       `json:"version"`
+	Root    *jsonNode `json:"root"`
+}
+
+// jsonNode is the JSON form of a single node. IDs are assigned in
+// depth-first order and are only meaningful within one document.
+type jsonNode struct {
+	ID       string                 `json:"id,omitempty"`
+	ParentID string                 `json:"parentId,omitempty"`
+	Type     string                 `json:"type"`
+	Value    string                 `json:"value,omitempty"`
+	Pos      *Position              `json:"pos,omitempty"`
+	End      *Position              `json:"end,omitempty"`
+	Metadata map[string]interface{} `json:"metadata,omitempty"`
+	Children []*jsonNode            `json:"children,omitempty"`
+}
+
+// MarshalJSON encodes the tree below n as a versioned document
+func (n *Node) MarshalJSON() ([]byte, error) {
+	next := 0
+	return json.Marshal(document{
+		Version: SchemaVersion,
+		Root:    toJSONNode(n, "", &next),
+	})
+}
+
+// UnmarshalJSON decodes a document written by MarshalJSON and rebuilds the
+// parent links of the tree
+func (n *Node) UnmarshalJSON(data []byte) error {
+	var probe struct {
+		Version *int            `json:"version"`
+		Root    json.RawMessage `json:"root"`
+	}
+	if err := json.Unmarshal(data, &probe); err != nil {
+		return err
+	}
+
+	var root jsonNode
+	switch {
+	case probe.Version == nil:
+		// Unversioned trees are plain nested nodes
+		if err := json.Unmarshal(data, &root); err != nil {
+			return err
+		}
+	case *probe.Version == SchemaVersion:
+		if len(probe.Root) == 0 {
+			return fmt.Errorf("ast document has no root")
+		}
+		if err := json.Unmarshal(probe.Root, &root); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unsupported ast schema version %d", *probe.Version)
+	}
+
+	decoded, err := fromJSONNode(&root, nil, map[string]bool{})
+	if err != nil {
+		return err
+	}
+	*n = *decoded
+	for _, child := range n.Children {
+		child.Parent = n
+	}
+	return nil
+}
+
+// toJSONNode converts n and its subtree, numbering nodes from *next
+func toJSONNode(n *Node, parentID string, next *int) *jsonNode {
+	if n == nil {
+		return nil
+	}
+	jn := &jsonNode{
+		ID:       "n" + strconv.Itoa(*next),
+		ParentID: parentID,
+		Type:     n.Type,
+		Value:    n.Value,
+		Metadata: map[string]interface{}{},
+	}
+	*next++
+
+	for key, value := range n.Metadata {
+		switch key {
+		case MetaPos:
+			if pos, ok := positionValue(value); ok {
+				jn.Pos = &pos
+			}
+		case MetaEnd:
+			if pos, ok := positionValue(value); ok {
+				jn.End = &pos
+			}
+		default:
+			jn.Metadata[key] = value
+		}
+	}
+	if len(jn.Metadata) == 0 {
+		jn.Metadata = nil
+	}
+
+	for _, child := range n.Children {
+		jn.Children = append(jn.Children, toJSONNode(child, jn.ID, next))
+	}
+	return jn
+}
+
+// fromJSONNode converts a decoded node and its subtree, checking that IDs
+// are unique and that parent IDs match the nesting of the document
+func fromJSONNode(jn *jsonNode, parent *jsonNode, seen map[string]bool) (*Node, error) {
+	if jn == nil {
+		return nil, fmt.Errorf("ast document contains a null node")
+	}
+	if jn.Type == "" {
+		return nil, fmt.Errorf("ast node %q has no type", jn.ID)
+	}
+	if jn.ID != "" {
+		if seen[jn.ID] {
+			return nil, fmt.Errorf("duplicate ast node id %q", jn.ID)
+		}
+		seen[jn.ID] = true
+	}
+	if jn.ParentID != "" && (parent == nil || parent.ID != jn.ParentID) {
+		return nil, fmt.Errorf("ast node %q has parentId %q but is not nested in it", jn.ID, jn.ParentID)
+	}
+
+	n := &Node{
+		Type:     jn.Type,
+		Value:    jn.Value,
+		Children: []*Node{},
+		Metadata: normalizeMetadata(jn.Metadata),
+	}
+	if jn.Pos != nil {
+		n.Metadata[MetaPos] = *jn.Pos
+	}
+	if jn.End != nil {
+		n.Metadata[MetaEnd] = *jn.End
+	}
+
+	for _, jc := range jn.Children {
+		child, err := fromJSONNode(jc, jn, seen)
+		if err != nil {
+			return nil, err
+		}
+		child.Parent = n
+		n.Children = append(n.Children, child)
+	}
+	return n, nil
+}
+
+// normalizeMetadata restores the Go types of metadata values after they
+// went through encoding/json, so decoded trees match freshly parsed ones
+func normalizeMetadata(metadata map[string]interface{}) map[string]interface{} {
+	result := make(map[string]interface{}, len(metadata))
+	for key, value := range metadata {
+		switch key {
+		case MetaPos, MetaEnd:
+			if pos, ok := positionValue(value); ok {
+				result[key] = pos
+			}
+		case MetaTokens:
+			result[key] = intMap(value)
+		case MetaLines:
+			result[key] = intSlice(value)
+		case MetaComments:
+			result[key] = commentInfos(value)
+		default:
+			if f, ok := value.(float64); ok && f == float64(int(f)) {
+				value = int(f)
+			}
+			result[key] = value
+		}
+	}
+	return result
+}
+
+// positionValue reads a Position stored in metadata
+func positionValue(v interface{}) (Position, bool) {
+	switch p := v.(type) {
+	case Position:
+		return p, true
+	case *Position:
+		if p != nil {
+			return *p, true
+		}
+	case map[string]interface{}:
+		pos := Position{}
+		pos.Offset, _ = intValue(p["offset"])
+		pos.Line, _ = intValue(p["line"])
+		pos.Column, _ = intValue(p["column"])
+		return pos, true
+	}
+	return Position{}, false
+}
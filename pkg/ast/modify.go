@@ -0,0 +1,811 @@
+package ast
+
+import (
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"strconv"
+	"strings"
+)
+
+// Operations supported by ModifyAST
+const (
+	OpAddFunction      = "add_function"
+	OpRemoveFunction   = "remove_function"
+	OpRenameFunction   = "rename_function"
+	OpAddParameter     = "add_parameter"
+	OpChangeReturnType = "change_return_type"
+	OpInsertStatement  = "insert_statement"
+	OpWrapErrorCheck   = "wrap_error_check"
+	OpAddStructField   = "add_struct_field"
+	OpAddImport        = "add_import"
+)
+
+// Errors reported by ModifyAST, wrapped in an *OperationError
+var (
+	ErrUnknownOperation = errors.New("unknown operation")
+	ErrInvalidParams    = errors.New("invalid parameters")
+	ErrTargetNotFound   = errors.New("target not found")
+)
+
+// OperationError describes why an edit operation could not be applied
+type OperationError struct {
+	Operation string
+	Target    string
+	Err       error
+	Detail    string
+}
+
+// Error implements the error interface
+func (e *OperationError) Error() string {
+	msg := e.Operation + ": " + e.Err.Error()
+	if e.Target != "" {
+		msg += " " + strconv.Quote(e.Target)
+	}
+	if e.Detail != "" {
+		msg += ": " + e.Detail
+	}
+	return msg
+}
+
+// Unwrap returns the underlying error
+func (e *OperationError) Unwrap() error {
+	return e.Err
+}
+
+// modification carries the state of a single ModifyAST call
+type modification struct {
+	operation string
+	file      *Node
+	params    map[string]interface{}
+}
+
+// fail builds an OperationError for the current operation
+func (m *modification) fail(err error, target, format string, args ...interface{}) error {
+	return &OperationError{
+		Operation: m.operation,
+		Target:    target,
+		Err:       err,
+		Detail:    fmt.Sprintf(format, args...),
+	}
+}
+
+// str returns a string parameter, reporting an error when a required one is missing
+func (m *modification) str(name string, required bool) (string, error) {
+	value, ok := m.params[name]
+	if !ok || value == nil {
+		if required {
+			return "", m.fail(ErrInvalidParams, "", "missing parameter %q", name)
+		}
+		return "", nil
+	}
+	s, ok := value.(string)
+	if !ok || (required && strings.TrimSpace(s) == "") {
+		return "", m.fail(ErrInvalidParams, "", "parameter %q must be a non-empty string", name)
+	}
+	return s, nil
+}
+
+// index returns an integer parameter, or def when it is not set
+func (m *modification) index(name string, def int) (int, error) {
+	value, ok := m.params[name]
+	if !ok || value == nil {
+		return def, nil
+	}
+	n, ok := intValue(value)
+	if !ok {
+		return 0, m.fail(ErrInvalidParams, "", "parameter %q must be a number", name)
+	}
+	return n, nil
+}
+
+// apply validates the parameters of an operation and applies it to the file
+func (m *modification) apply() error {
+	switch m.operation {
+	case OpAddFunction:
+		return m.addFunction()
+	case OpRemoveFunction:
+		return m.removeFunction()
+	case OpRenameFunction:
+		return m.renameFunction()
+	case OpAddParameter:
+		return m.addParameter()
+	case OpChangeReturnType:
+		return m.changeReturnType()
+	case OpInsertStatement:
+		return m.insertStatement()
+	case OpWrapErrorCheck:
+		return m.wrapErrorCheck()
+	case OpAddStructField:
+		return m.addStructField()
+	case OpAddImport:
+		return m.addImport()
+	}
+	return m.fail(ErrUnknownOperation, m.operation, "")
+}
+
+// addFunction appends a function declaration given as source in "code"
+func (m *modification) addFunction() error {
+	code, err := m.str("code", true)
+	if err != nil {
+		return err
+	}
+	fset, file, err := parseSnippet("package p\n" + code)
+	if err != nil || len(file.Decls) != 1 {
+		return m.fail(ErrInvalidParams, "", "code must hold exactly one function declaration")
+	}
+	decl, ok := file.Decls[0].(*ast.FuncDecl)
+	if !ok {
+		return m.fail(ErrInvalidParams, "", "code must hold exactly one function declaration")
+	}
+	name := funcName(decl)
+	if m.findFunction(name) != nil {
+		return m.fail(ErrInvalidParams, name, "function already exists")
+	}
+
+	insertChild(m.file, snippetNode(fset, decl, "Decls"), -1)
+	return nil
+}
+
+// removeFunction removes the function or method named by "name"
+func (m *modification) removeFunction() error {
+	name, err := m.str("name", true)
+	if err != nil {
+		return err
+	}
+	fn := m.findFunction(name)
+	if fn == nil {
+		return m.fail(ErrTargetNotFound, name, "no such function")
+	}
+	removeChild(fn)
+	return nil
+}
+
+// renameFunction renames a function and the references to it in the file
+func (m *modification) renameFunction() error {
+	name, err := m.str("name", true)
+	if err != nil {
+		return err
+	}
+	newName, err := m.str("new_name", true)
+	if err != nil {
+		return err
+	}
+	if !token.IsIdentifier(newName) {
+		return m.fail(ErrInvalidParams, newName, "not a valid identifier")
+	}
+	fn := m.findFunction(name)
+	if fn == nil {
+		return m.fail(ErrTargetNotFound, name, "no such function")
+	}
+	recv := childByRole(fn, "Recv")
+	qualified := newName
+	if recv != nil {
+		qualified = receiverType(fn) + "." + newName
+	}
+	if m.findFunction(qualified) != nil {
+		return m.fail(ErrInvalidParams, qualified, "function already exists")
+	}
+
+	oldName := fn.Value
+	fn.Value = newName
+	childByRole(fn, "Name").Value = newName
+
+	// Methods are only renamed at their declaration as resolving the
+	// receiver of a call needs type information
+	if recv == nil {
+		r := &renamer{oldName: oldName, newName: newName}
+		r.visit(m.file, &scope{})
+	}
+	return nil
+}
+
+// scope is a lexical scope holding the local names declared so far
+type scope struct {
+	names  map[string]bool
+	parent *scope
+}
+
+// declare adds a name to the scope
+func (s *scope) declare(name string) {
+	if s.names == nil {
+		s.names = map[string]bool{}
+	}
+	s.names[name] = true
+}
+
+// declareIdents declares every identifier among nodes
+func (s *scope) declareIdents(nodes []*Node) {
+	for _, n := range nodes {
+		if n.Type == "Ident" {
+			s.declare(n.Value)
+		}
+	}
+}
+
+// lookup reports whether name is declared in the scope or an enclosing one
+func (s *scope) lookup(name string) bool {
+	for ; s != nil; s = s.parent {
+		if s.names[name] {
+			return true
+		}
+	}
+	return false
+}
+
+// renamer rewrites the identifiers that refer to a top-level function.
+// Without type information a name is taken to refer to the function unless
+// it is shadowed by a local declaration or names something else entirely,
+// such as a field, method, label or composite literal key.
+type renamer struct {
+	oldName string
+	newName string
+}
+
+// visit renames the references below n, which is evaluated in scope s
+func (r *renamer) visit(n *Node, s *scope) {
+	switch n.Type {
+	case "Ident":
+		if n.Value == r.oldName && !s.lookup(n.Value) {
+			n.Value = r.newName
+		}
+		return
+
+	case "File":
+		r.visitRoles(n, s, "Decls")
+		return
+
+	case "ImportSpec", "BranchStmt":
+		return
+
+	case "SelectorExpr":
+		r.visitRoles(n, s, "X")
+		return
+
+	case "KeyValueExpr":
+		// Keys of struct literals are field names. Map keys could refer
+		// to the function but cannot be told apart without types.
+		if n.Parent != nil && n.Parent.Type == "CompositeLit" {
+			r.visitRoles(n, s, "Value")
+			return
+		}
+
+	case "LabeledStmt":
+		r.visitRoles(n, s, "Stmt")
+		return
+
+	case "Field":
+		// Field, parameter and method names are declared by the
+		// enclosing function or type
+		r.visitRoles(n, s, "Type", "Tag")
+		return
+
+	case "FuncDecl", "FuncLit":
+		inner := &scope{parent: s}
+		typ := childByRole(n, "Type")
+		lists := []*Node{
+			childByRole(n, "Recv"),
+			childByRole(typ, "TypeParams"),
+			childByRole(typ, "Params"),
+			childByRole(typ, "Results"),
+		}
+		for _, list := range lists {
+			for _, field := range childrenByRole(list, "List") {
+				inner.declareIdents(childrenByRole(field, "Names"))
+			}
+		}
+		r.visitRoles(n, inner, "Recv", "Type", "Body")
+		return
+
+	case "AssignStmt":
+		if n.Value == token.DEFINE.String() {
+			r.visitRoles(n, s, "Rhs")
+			s.declareIdents(childrenByRole(n, "Lhs"))
+			return
+		}
+
+	case "RangeStmt":
+		r.visitRoles(n, s, "X")
+		inner := &scope{parent: s}
+		if n.Value == token.DEFINE.String() {
+			inner.declareIdents(append(childrenByRole(n, "Key"), childrenByRole(n, "Value")...))
+		} else {
+			r.visitRoles(n, inner, "Key", "Value")
+		}
+		r.visitRoles(n, inner, "Body")
+		return
+
+	case "ValueSpec":
+		r.visitRoles(n, s, "Type", "Values")
+		s.declareIdents(childrenByRole(n, "Names"))
+		return
+
+	case "TypeSpec":
+		s.declareIdents(childrenByRole(n, "Name"))
+		r.visitRoles(n, s, "TypeParams", "Type")
+		return
+
+	case "BlockStmt", "IfStmt", "ForStmt", "SwitchStmt", "TypeSwitchStmt",
+		"SelectStmt", "CaseClause", "CommClause":
+		s = &scope{parent: s}
+	}
+
+	for _, child := range n.Children {
+		r.visit(child, s)
+	}
+}
+
+// visitRoles visits the children of n with the given roles in tree order
+func (r *renamer) visitRoles(n *Node, s *scope, roles ...string) {
+	for _, child := range n.Children {
+		for _, role := range roles {
+			if roleOf(child) == role {
+				r.visit(child, s)
+				break
+			}
+		}
+	}
+}
+
+// addParameter appends a parameter to a function's signature
+func (m *modification) addParameter() error {
+	fn, err := m.function()
+	if err != nil {
+		return err
+	}
+	name, err := m.str("name", true)
+	if err != nil {
+		return err
+	}
+	typ, err := m.str("type", true)
+	if err != nil {
+		return err
+	}
+	fset, field, err := parseField(name, typ, "")
+	if err != nil {
+		return m.fail(ErrInvalidParams, typ, "invalid parameter: %v", err)
+	}
+
+	params := childByRole(childByRole(fn, "Type"), "Params")
+	for _, existing := range childrenByRole(params, "List") {
+		for _, ident := range childrenByRole(existing, "Names") {
+			if ident.Value == name {
+				return m.fail(ErrInvalidParams, name, "parameter already exists")
+			}
+		}
+	}
+	insertChild(params, snippetNode(fset, field, "List"), -1)
+	refreshType(fn)
+	return nil
+}
+
+// changeReturnType replaces a function's result list, e.g. "(string, error)"
+func (m *modification) changeReturnType() error {
+	fn, err := m.function()
+	if err != nil {
+		return err
+	}
+	results, err := m.str("results", false)
+	if err != nil {
+		return err
+	}
+	fset, file, err := parseSnippet("package p\nfunc _() " + results + " {}")
+	if err != nil {
+		return m.fail(ErrInvalidParams, results, "invalid result list: %v", err)
+	}
+
+	funcType := childByRole(fn, "Type")
+	if old := childByRole(funcType, "Results"); old != nil {
+		removeChild(old)
+	}
+	if list := file.Decls[0].(*ast.FuncDecl).Type.Results; list != nil {
+		insertChild(funcType, snippetNode(fset, list, "Results"), -1)
+	}
+	refreshType(fn)
+	return nil
+}
+
+// insertStatement inserts the statements in "code" into the block at "path"
+func (m *modification) insertStatement() error {
+	block, err := m.target()
+	if err != nil {
+		return err
+	}
+	if block.Type != "BlockStmt" {
+		block = childByRole(block, "Body")
+	}
+	if block == nil || block.Type != "BlockStmt" {
+		return m.fail(ErrInvalidParams, Path(block), "target is not a block")
+	}
+	code, err := m.str("code", true)
+	if err != nil {
+		return err
+	}
+	fset, stmts, err := parseStatements(code)
+	if err != nil {
+		return m.fail(ErrInvalidParams, "", "invalid statement: %v", err)
+	}
+	index, err := m.index("index", -1)
+	if err != nil {
+		return err
+	}
+	count := len(childrenByRole(block, "List"))
+	if index > count {
+		return m.fail(ErrTargetNotFound, fmt.Sprintf("%s/List[%d]", Path(block), index), "block has %d statements", count)
+	}
+
+	for i, stmt := range stmts {
+		at := -1
+		if index >= 0 {
+			at = index + i
+		}
+		insertChild(block, snippetNode(fset, stmt, "List"), at)
+	}
+	return nil
+}
+
+// wrapErrorCheck adds an error check to the call statement at "path".
+// A bare call becomes "if err := call; err != nil { return ... }", an
+// assignment to err is followed by "if err != nil { return ... }".
+func (m *modification) wrapErrorCheck() error {
+	stmt, err := m.target()
+	if err != nil {
+		return err
+	}
+	if roleOf(stmt) != "List" || stmt.Parent == nil || stmt.Parent.Type != "BlockStmt" {
+		return m.fail(ErrInvalidParams, Path(stmt), "target is not a statement in a block")
+	}
+
+	returns, err := m.str("return", false)
+	if err != nil {
+		return err
+	}
+	if returns == "" {
+		if returns, err = m.errorReturn(stmt); err != nil {
+			return err
+		}
+	}
+
+	var code string
+	switch {
+	case stmt.Type == "ExprStmt" && len(stmt.Children) == 1 && stmt.Children[0].Type == "CallExpr":
+		call, err := generateCode(stmt.Children[0])
+		if err != nil {
+			return m.fail(ErrInvalidParams, Path(stmt), "%v", err)
+		}
+		code = fmt.Sprintf("if err := %s; err != nil {\nreturn %s\n}", call, returns)
+	case stmt.Type == "AssignStmt" && assignsErr(stmt):
+		code = fmt.Sprintf("if err != nil {\nreturn %s\n}", returns)
+	default:
+		return m.fail(ErrInvalidParams, Path(stmt), "statement is neither a call nor an assignment to err")
+	}
+
+	fset, stmts, err := parseStatements(code)
+	if err != nil {
+		return m.fail(ErrInvalidParams, returns, "invalid return values: %v", err)
+	}
+	check := snippetNode(fset, stmts[0], "List")
+
+	block := stmt.Parent
+	index := indexInRole(stmt)
+	if stmt.Type == "ExprStmt" {
+		removeChild(stmt)
+		insertChild(block, check, index)
+	} else {
+		insertChild(block, check, index+1)
+	}
+	return nil
+}
+
+// errorReturn builds the return values used by wrapErrorCheck from the
+// results of the function enclosing stmt
+func (m *modification) errorReturn(stmt *Node) (string, error) {
+	fn := stmt.Parent
+	for fn != nil && fn.Type != "FuncDecl" && fn.Type != "FuncLit" {
+		fn = fn.Parent
+	}
+	if fn == nil {
+		return "", m.fail(ErrInvalidParams, Path(stmt), "statement is not inside a function")
+	}
+
+	var values []string
+	returnsError := false
+	for _, field := range childrenByRole(childByRole(childByRole(fn, "Type"), "Results"), "List") {
+		typ, _ := field.Metadata[MetaType].(string)
+		count := len(childrenByRole(field, "Names"))
+		if count == 0 {
+			count = 1
+		}
+		for i := 0; i < count; i++ {
+			if typ == "error" {
+				values = append(values, "err")
+				returnsError = true
+			} else {
+				values = append(values, zeroValue(typ))
+			}
+		}
+	}
+	if !returnsError {
+		return "", m.fail(ErrInvalidParams, Path(fn), "enclosing function does not return an error")
+	}
+	return strings.Join(values, ", "), nil
+}
+
+// addStructField adds a field to the struct type named by "struct"
+func (m *modification) addStructField() error {
+	structName, err := m.str("struct", true)
+	if err != nil {
+		return err
+	}
+	name, err := m.str("name", true)
+	if err != nil {
+		return err
+	}
+	typ, err := m.str("type", true)
+	if err != nil {
+		return err
+	}
+	tag, err := m.str("tag", false)
+	if err != nil {
+		return err
+	}
+
+	var fields *Node
+	walk(m.file, func(n *Node) {
+		if fields == nil && n.Type == "TypeSpec" && n.Value == structName {
+			if st := childByRole(n, "Type"); st != nil && st.Type == "StructType" {
+				fields = childByRole(st, "Fields")
+			}
+		}
+	})
+	if fields == nil {
+		return m.fail(ErrTargetNotFound, structName, "no such struct type")
+	}
+	for _, existing := range childrenByRole(fields, "List") {
+		for _, ident := range childrenByRole(existing, "Names") {
+			if ident.Value == name {
+				return m.fail(ErrInvalidParams, name, "field already exists")
+			}
+		}
+	}
+
+	fset, field, err := parseField(name, typ, tag)
+	if err != nil {
+		return m.fail(ErrInvalidParams, typ, "invalid field: %v", err)
+	}
+	insertChild(fields, snippetNode(fset, field, "List"), -1)
+	return nil
+}
+
+// addImport adds an import of "path", optionally named "name"
+func (m *modification) addImport() error {
+	path, err := m.str("path", true)
+	if err != nil {
+		return err
+	}
+	name, err := m.str("name", false)
+	if err != nil {
+		return err
+	}
+	if name != "" && name != "." && !token.IsIdentifier(name) {
+		return m.fail(ErrInvalidParams, name, "import name must be an identifier, \"_\" or \".\"")
+	}
+	quoted := strconv.Quote(strings.Trim(path, `"`))
+
+	var importDecl *Node
+	for _, decl := range childrenByRole(m.file, "Decls") {
+		if decl.Type != "GenDecl" || decl.Value != "import" {
+			continue
+		}
+		if importDecl == nil {
+			importDecl = decl
+		}
+		for _, spec := range childrenByRole(decl, "Specs") {
+			if spec.Value == quoted {
+				return nil
+			}
+		}
+	}
+
+	fset, file, err := parseSnippet("package p\nimport " + name + " " + quoted)
+	if err != nil {
+		return m.fail(ErrInvalidParams, path, "invalid import: %v", err)
+	}
+	decl := file.Decls[0].(*ast.GenDecl)
+
+	if importDecl == nil {
+		insertChild(m.file, snippetNode(fset, decl, "Decls"), 0)
+		return nil
+	}
+	insertChild(importDecl, snippetNode(fset, decl.Specs[0], "Specs"), -1)
+	return nil
+}
+
+// function returns the function named by the "function" parameter
+func (m *modification) function() (*Node, error) {
+	name, err := m.str("function", true)
+	if err != nil {
+		return nil, err
+	}
+	fn := m.findFunction(name)
+	if fn == nil {
+		return nil, m.fail(ErrTargetNotFound, name, "no such function")
+	}
+	return fn, nil
+}
+
+// target resolves the "path" parameter, relative to the function named by
+// "function" when that is given and to the file otherwise
+func (m *modification) target() (*Node, error) {
+	path, err := m.str("path", false)
+	if err != nil {
+		return nil, err
+	}
+	base := m.file
+	if _, ok := m.params["function"]; ok {
+		if base, err = m.function(); err != nil {
+			return nil, err
+		}
+	}
+	node, err := FindPath(base, path)
+	if err != nil {
+		return nil, m.fail(ErrTargetNotFound, path, "%v", err)
+	}
+	return node, nil
+}
+
+// findFunction finds a function by name, or a method by "Type.Method"
+func (m *modification) findFunction(name string) *Node {
+	recv := ""
+	if i := strings.Index(name, "."); i >= 0 {
+		recv, name = name[:i], name[i+1:]
+	}
+	for _, decl := range childrenByRole(m.file, "Decls") {
+		if decl.Type == "FuncDecl" && decl.Value == name && receiverType(decl) == recv {
+			return decl
+		}
+	}
+	return nil
+}
+
+// receiverType returns the base type name of a method's receiver, or ""
+// for plain functions
+func receiverType(fn *Node) string {
+	recv := childByRole(fn, "Recv")
+	if recv == nil {
+		return ""
+	}
+	fields := childrenByRole(recv, "List")
+	if len(fields) == 0 {
+		return ""
+	}
+	typ, _ := fields[0].Metadata[MetaType].(string)
+	typ = strings.TrimPrefix(typ, "*")
+	if i := strings.Index(typ, "["); i >= 0 {
+		typ = typ[:i]
+	}
+	return typ
+}
+
+// funcName returns the name used by findFunction for a declaration
+func funcName(decl *ast.FuncDecl) string {
+	if decl.Recv == nil || len(decl.Recv.List) == 0 {
+		return decl.Name.Name
+	}
+	typ := decl.Recv.List[0].Type
+	if star, ok := typ.(*ast.StarExpr); ok {
+		typ = star.X
+	}
+	switch t := typ.(type) {
+	case *ast.IndexExpr:
+		typ = t.X
+	case *ast.IndexListExpr:
+		typ = t.X
+	}
+	if ident, ok := typ.(*ast.Ident); ok {
+		return ident.Name + "." + decl.Name.Name
+	}
+	return decl.Name.Name
+}
+
+// assignsErr reports whether an assignment has err on its left-hand side
+func assignsErr(stmt *Node) bool {
+	for _, lhs := range childrenByRole(stmt, "Lhs") {
+		if lhs.Type == "Ident" && lhs.Value == "err" {
+			return true
+		}
+	}
+	return false
+}
+
+// zeroValue returns the source of the zero value for a type expression
+func zeroValue(typ string) string {
+	switch typ {
+	case "bool":
+		return "false"
+	case "string":
+		return `""`
+	case "int", "int8", "int16", "int32", "int64",
+		"uint", "uint8", "uint16", "uint32", "uint64", "uintptr",
+		"float32", "float64", "complex64", "complex128", "byte", "rune":
+		return "0"
+	case "any", "error":
+		return "nil"
+	}
+	for _, prefix := range []string{"*", "[]", "map[", "chan", "<-chan", "func", "interface"} {
+		if strings.HasPrefix(typ, prefix) {
+			return "nil"
+		}
+	}
+	return "*new(" + typ + ")"
+}
+
+// parseSnippet parses a small piece of source given as a complete file
+func parseSnippet(source string) (*token.FileSet, *ast.File, error) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "", source, parser.ParseComments)
+	return fset, file, err
+}
+
+// parseStatements parses a list of statements
+func parseStatements(code string) (*token.FileSet, []ast.Stmt, error) {
+	fset, file, err := parseSnippet("package p\nfunc _() {\n" + code + "\n}")
+	if err != nil {
+		return nil, nil, err
+	}
+	stmts := file.Decls[0].(*ast.FuncDecl).Body.List
+	if len(stmts) == 0 {
+		return nil, nil, errors.New("no statements")
+	}
+	return fset, stmts, nil
+}
+
+// parseField parses a struct field or parameter declaration
+func parseField(name, typ, tag string) (*token.FileSet, *ast.Field, error) {
+	if !token.IsIdentifier(name) {
+		return nil, nil, fmt.Errorf("%q is not a valid identifier", name)
+	}
+	source := "package p\ntype _ struct {\n" + name + " " + typ
+	if tag != "" && !strings.Contains(tag, "`") {
+		source += " `" + tag + "`"
+	} else if tag != "" {
+		source += " " + strconv.Quote(tag)
+	}
+	fset, file, err := parseSnippet(source + "\n}")
+	if err != nil {
+		return nil, nil, err
+	}
+	fields := file.Decls[0].(*ast.GenDecl).Specs[0].(*ast.TypeSpec).Type.(*ast.StructType).Fields.List
+	if len(fields) != 1 {
+		return nil, nil, fmt.Errorf("%q is not a single type", typ)
+	}
+	return fset, fields[0], nil
+}
+
+// snippetNode converts a parsed snippet into a Node without layout
+// information, so that it can be inserted into another tree
+func snippetNode(fset *token.FileSet, n ast.Node, role string) *Node {
+	c := &converter{fset: fset}
+	node := c.convert(n, role)
+	stripLayout(node)
+	return node
+}
+
+// stripLayout removes the layout metadata of a subtree
+func stripLayout(n *Node) {
+	walk(n, func(n *Node) {
+		for key := range layoutKeys {
+			delete(n.Metadata, key)
+		}
+	})
+}
+
+// refreshType updates the type metadata of a function after its
+// signature changed
+func refreshType(fn *Node) {
+	if code, err := generateCode(childByRole(fn, "Type")); err == nil {
+		fn.Metadata[MetaType] = code
+	}
+}
@@ -0,0 +1,439 @@
+package ast
+
+import (
+	"errors"
+	"go/format"
+	"strings"
+	"testing"
+)
+
+// modifySource parses src, applies one operation and returns the formatted result
+func modifySource(t *testing.T, src, operation string, params map[string]interface{}) (string, error) {
+	t.Helper()
+
+	p := NewProcessor(nil)
+	root, err := p.ParseGoFile("test.go", src)
+	if err != nil {
+		t.Fatalf("ParseGoFile: %v", err)
+	}
+	if _, err := p.ModifyAST(root, operation, params); err != nil {
+		return "", err
+	}
+	code, err := p.GenerateCode(root)
+	if err != nil {
+		t.Fatalf("GenerateCode: %v", err)
+	}
+	return code, nil
+}
+
+// gofmt formats expected output so tests can be written loosely
+func gofmt(t *testing.T, src string) string {
+	t.Helper()
+
+	formatted, err := format.Source([]byte(src))
+	if err != nil {
+		t.Fatalf("gofmt: %v", err)
+	}
+	return string(formatted)
+}
+
+func TestRenameFunction(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{
+			name: "declaration and calls",
+			src: `package p
+func Login() error { return nil }
+func run() { _ = Login(); f := Login; _ = f }
+`,
+			want: `package p
+func SignIn() error { return nil }
+func run() { _ = SignIn(); f := SignIn; _ = f }
+`,
+		},
+		{
+			name: "composite literal keys",
+			src: `package p
+type S struct{ Login int }
+func Login() int { return 1 }
+var s = S{Login: Login()}
+`,
+			want: `package p
+type S struct{ Login int }
+func SignIn() int { return 1 }
+var s = S{Login: SignIn()}
+`,
+		},
+		{
+			name: "same-named method",
+			src: `package p
+type S struct{}
+func (s *S) Login() {}
+func Login() {}
+func run(s *S) { s.Login(); Login() }
+`,
+			want: `package p
+type S struct{}
+func (s *S) Login() {}
+func SignIn() {}
+func run(s *S) { s.Login(); SignIn() }
+`,
+		},
+		{
+			name: "shadowed by parameter",
+			src: `package p
+func Login() {}
+func run(Login func()) { Login() }
+func other() { Login() }
+`,
+			want: `package p
+func SignIn() {}
+func run(Login func()) { Login() }
+func other() { SignIn() }
+`,
+		},
+		{
+			name: "shadowed by local variable",
+			src: `package p
+func Login() int { return 0 }
+func run() int {
+	x := Login()
+	if x > 0 {
+		Login := 2
+		return Login
+	}
+	for _, Login := range []int{1} {
+		_ = Login
+	}
+	var Login = Login()
+	return Login
+}
+`,
+			want: `package p
+func SignIn() int { return 0 }
+func run() int {
+	x := SignIn()
+	if x > 0 {
+		Login := 2
+		return Login
+	}
+	for _, Login := range []int{1} {
+		_ = Login
+	}
+	var Login = SignIn()
+	return Login
+}
+`,
+		},
+		{
+			name: "shadowing ends with its scope",
+			src: `package p
+func Login() {}
+func run() {
+	{
+		Login := func() {}
+		Login()
+	}
+	Login()
+}
+`,
+			want: `package p
+func SignIn() {}
+func run() {
+	{
+		Login := func() {}
+		Login()
+	}
+	SignIn()
+}
+`,
+		},
+		{
+			name: "labels",
+			src: `package p
+func Login() {}
+func run() {
+Login:
+	for {
+		Login()
+		break Login
+	}
+}
+`,
+			want: `package p
+func SignIn() {}
+func run() {
+Login:
+	for {
+		SignIn()
+		break Login
+	}
+}
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := modifySource(t, tt.src, OpRenameFunction, map[string]interface{}{
+				"name":     "Login",
+				"new_name": "SignIn",
+			})
+			if err != nil {
+				t.Fatalf("ModifyAST: %v", err)
+			}
+			if want := gofmt(t, tt.want); got != want {
+				t.Errorf("got\n%s\nwant\n%s", got, want)
+			}
+		})
+	}
+}
+
+func TestRenameFunctionErrors(t *testing.T) {
+	src := `package p
+func Login() {}
+func SignIn() {}
+`
+	tests := []struct {
+		name   string
+		params map[string]interface{}
+		want   error
+	}{
+		{"missing function", map[string]interface{}{"name": "Logout", "new_name": "X"}, ErrTargetNotFound},
+		{"existing name", map[string]interface{}{"name": "Login", "new_name": "SignIn"}, ErrInvalidParams},
+		{"invalid identifier", map[string]interface{}{"name": "Login", "new_name": "sign in"}, ErrInvalidParams},
+		{"missing parameter", map[string]interface{}{"name": "Login"}, ErrInvalidParams},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := modifySource(t, src, OpRenameFunction, tt.params)
+			var opErr *OperationError
+			if !errors.As(err, &opErr) || !errors.Is(err, tt.want) {
+				t.Errorf("got error %v, want an *OperationError wrapping %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestModifyAST(t *testing.T) {
+	const src = `package p
+
+import "fmt"
+
+type User struct {
+	Name string
+}
+
+func (u *User) Save() error { return nil }
+
+func Login(name string) error {
+	fmt.Println(name)
+	return nil
+}
+
+func run() (int, error) {
+	save()
+	err := save()
+	return 0, err
+}
+
+func save() error { return nil }
+`
+
+	tests := []struct {
+		name      string
+		operation string
+		src       string
+		params    map[string]interface{}
+		want      string
+	}{
+		{
+			name:      "add function",
+			operation: OpAddFunction,
+			src:       "package p\nfunc A() {}\n",
+			params:    map[string]interface{}{"code": "func B() int { return 1 }"},
+			want:      "package p\nfunc A() {}\n\nfunc B() int {\n\treturn 1\n}\n",
+		},
+		{
+			name:      "add method",
+			operation: OpAddFunction,
+			src:       "package p\ntype T struct{}\nfunc (t T) A() {}\n",
+			params:    map[string]interface{}{"code": "func (t *T) B() {}"},
+			want:      "package p\ntype T struct{}\nfunc (t T) A() {}\n\nfunc (t *T) B() {\n}\n",
+		},
+		{
+			name:      "remove function",
+			operation: OpRemoveFunction,
+			src:       "package p\nfunc A() {}\nfunc B() {}\n",
+			params:    map[string]interface{}{"name": "A"},
+			want:      "package p\nfunc B() {}\n",
+		},
+		{
+			name:      "remove method",
+			operation: OpRemoveFunction,
+			src:       "package p\ntype T struct{}\nfunc (t *T) A() {}\nfunc A() {}\n",
+			params:    map[string]interface{}{"name": "T.A"},
+			want:      "package p\ntype T struct{}\nfunc A() {}\n",
+		},
+		{
+			name:      "add parameter",
+			operation: OpAddParameter,
+			src:       "package p\nfunc Login(name string) error { return nil }\n",
+			params:    map[string]interface{}{"function": "Login", "name": "password", "type": "[]byte"},
+			want:      "package p\nfunc Login(name string, password []byte) error { return nil }\n",
+		},
+		{
+			name:      "change return type",
+			operation: OpChangeReturnType,
+			src:       "package p\nfunc Login() error { return nil }\n",
+			params:    map[string]interface{}{"function": "Login", "results": "(string, error)"},
+			want:      "package p\nfunc Login() (string, error) { return nil }\n",
+		},
+		{
+			name:      "remove return type",
+			operation: OpChangeReturnType,
+			src:       "package p\nfunc Login() error { return nil }\n",
+			params:    map[string]interface{}{"function": "Login"},
+			want:      "package p\nfunc Login() { return nil }\n",
+		},
+		{
+			name:      "insert statements",
+			operation: OpInsertStatement,
+			src:       "package p\nfunc run() {\n\ta()\n}\nfunc a() {}\n",
+			params:    map[string]interface{}{"function": "run", "code": "x := 1\n_ = x", "index": 0},
+			want:      "package p\nfunc run() {\n\tx := 1\n\t_ = x\n\ta()\n}\nfunc a() {}\n",
+		},
+		{
+			name:      "append statement",
+			operation: OpInsertStatement,
+			src:       "package p\nfunc run() {\n\ta()\n}\nfunc a() {}\n",
+			params:    map[string]interface{}{"path": "Decls[0]/Body", "code": "a()"},
+			want:      "package p\nfunc run() {\n\ta()\n\ta()\n}\nfunc a() {}\n",
+		},
+		{
+			name:      "wrap call in error check",
+			operation: OpWrapErrorCheck,
+			src:       src,
+			params:    map[string]interface{}{"function": "run", "path": "Body/List[0]"},
+			want:      strings.Replace(src, "\tsave()\n", "\tif err := save(); err != nil {\n\t\treturn 0, err\n\t}\n", 1),
+		},
+		{
+			name:      "check assigned error",
+			operation: OpWrapErrorCheck,
+			src:       src,
+			params:    map[string]interface{}{"function": "run", "path": "Body/List[1]", "return": "-1, err"},
+			want:      strings.Replace(src, "\terr := save()\n", "\terr := save()\n\tif err != nil {\n\t\treturn -1, err\n\t}\n", 1),
+		},
+		{
+			name:      "add struct field",
+			operation: OpAddStructField,
+			src:       src,
+			params:    map[string]interface{}{"struct": "User", "name": "Age", "type": "int", "tag": `json:"age"`},
+			want:      strings.Replace(src, "\tName string\n", "\tName string\n\tAge  int `json:\"age\"`\n", 1),
+		},
+		{
+			name:      "add import",
+			operation: OpAddImport,
+			src:       src,
+			params:    map[string]interface{}{"path": "strings"},
+			want:      strings.Replace(src, `import "fmt"`, "import (\n\t\"fmt\"\n\t\"strings\"\n)", 1),
+		},
+		{
+			name:      "add blank import",
+			operation: OpAddImport,
+			src:       "package p\n",
+			params:    map[string]interface{}{"path": "embed", "name": "_"},
+			want:      "package p\n\nimport _ \"embed\"\n",
+		},
+		{
+			name:      "add existing import",
+			operation: OpAddImport,
+			src:       src,
+			params:    map[string]interface{}{"path": "fmt"},
+			want:      src,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := modifySource(t, tt.src, tt.operation, tt.params)
+			if err != nil {
+				t.Fatalf("ModifyAST: %v", err)
+			}
+			// Inserted code is laid out anew, which may add blank lines
+			if want := gofmt(t, tt.want); dropBlankLines(got) != dropBlankLines(want) {
+				t.Errorf("got\n%s\nwant\n%s", got, want)
+			}
+		})
+	}
+}
+
+// dropBlankLines removes the empty lines of src
+func dropBlankLines(src string) string {
+	var lines []string
+	for _, line := range strings.Split(src, "\n") {
+		if strings.TrimSpace(line) != "" {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func TestModifyASTErrors(t *testing.T) {
+	const src = `package p
+
+type User struct {
+	Name string
+}
+
+func Login(name string) error { return nil }
+
+func run() {
+	x := 1
+	_ = x
+}
+`
+
+	tests := []struct {
+		name      string
+		operation string
+		params    map[string]interface{}
+		want      error
+	}{
+		{"unknown operation", "rewrite_everything", nil, ErrUnknownOperation},
+		{"add function without code", OpAddFunction, map[string]interface{}{}, ErrInvalidParams},
+		{"add invalid function", OpAddFunction, map[string]interface{}{"code": "var x = 1"}, ErrInvalidParams},
+		{"add existing function", OpAddFunction, map[string]interface{}{"code": "func Login() {}"}, ErrInvalidParams},
+		{"remove missing function", OpRemoveFunction, map[string]interface{}{"name": "Logout"}, ErrTargetNotFound},
+		{"parameter of missing function", OpAddParameter, map[string]interface{}{"function": "Logout", "name": "a", "type": "int"}, ErrTargetNotFound},
+		{"existing parameter", OpAddParameter, map[string]interface{}{"function": "Login", "name": "name", "type": "int"}, ErrInvalidParams},
+		{"parameter without type", OpAddParameter, map[string]interface{}{"function": "Login", "name": "a"}, ErrInvalidParams},
+		{"invalid parameter type", OpAddParameter, map[string]interface{}{"function": "Login", "name": "a", "type": "]int"}, ErrInvalidParams},
+		{"invalid return type", OpChangeReturnType, map[string]interface{}{"function": "Login", "results": "(string"}, ErrInvalidParams},
+		{"insert at missing path", OpInsertStatement, map[string]interface{}{"path": "Decls[9]/Body", "code": "a()"}, ErrTargetNotFound},
+		{"insert past the end", OpInsertStatement, map[string]interface{}{"function": "run", "code": "a()", "index": 5}, ErrTargetNotFound},
+		{"insert invalid statement", OpInsertStatement, map[string]interface{}{"function": "run", "code": "a("}, ErrInvalidParams},
+		{"insert into non-block", OpInsertStatement, map[string]interface{}{"path": "Decls[0]", "code": "a()"}, ErrInvalidParams},
+		{"check non-call", OpWrapErrorCheck, map[string]interface{}{"function": "run", "path": "Body/List[1]"}, ErrInvalidParams},
+		{"check without error result", OpWrapErrorCheck, map[string]interface{}{"function": "run", "path": "Body/List[0]"}, ErrInvalidParams},
+		{"field of missing struct", OpAddStructField, map[string]interface{}{"struct": "Account", "name": "A", "type": "int"}, ErrTargetNotFound},
+		{"existing field", OpAddStructField, map[string]interface{}{"struct": "User", "name": "Name", "type": "int"}, ErrInvalidParams},
+		{"import without path", OpAddImport, map[string]interface{}{}, ErrInvalidParams},
+		{"invalid import name", OpAddImport, map[string]interface{}{"path": "strings", "name": "my-strings"}, ErrInvalidParams},
+		{"import name with a declaration", OpAddImport, map[string]interface{}{"path": "strings", "name": "s\nfunc x() {}\nimport t"}, ErrInvalidParams},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := modifySource(t, src, tt.operation, tt.params)
+			var opErr *OperationError
+			if !errors.As(err, &opErr) || !errors.Is(err, tt.want) {
+				t.Errorf("got error %v, want an *OperationError wrapping %v", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,179 @@
+package ast
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// walk calls fn for n and every node below it, parents first
+func walk(n *Node, fn func(*Node)) {
+	if n == nil {
+		return
+	}
+	fn(n)
+	for _, child := range n.Children {
+		walk(child, fn)
+	}
+}
+
+// roleOf returns the go/ast field a node was found in
+func roleOf(n *Node) string {
+	role, _ := n.Metadata[MetaRole].(string)
+	return role
+}
+
+// childByRole returns the first child of n with the given role
+func childByRole(n *Node, role string) *Node {
+	if n == nil {
+		return nil
+	}
+	for _, child := range n.Children {
+		if roleOf(child) == role {
+			return child
+		}
+	}
+	return nil
+}
+
+// childrenByRole returns the children of n with the given role
+func childrenByRole(n *Node, role string) []*Node {
+	if n == nil {
+		return nil
+	}
+	var children []*Node
+	for _, child := range n.Children {
+		if roleOf(child) == role {
+			children = append(children, child)
+		}
+	}
+	return children
+}
+
+// indexInRole returns the position of n among its siblings of the same role
+func indexInRole(n *Node) int {
+	if n.Parent == nil {
+		return 0
+	}
+	index := 0
+	for _, sibling := range n.Parent.Children {
+		if sibling == n {
+			return index
+		}
+		if roleOf(sibling) == roleOf(n) {
+			index++
+		}
+	}
+	return -1
+}
+
+// fieldOrder returns the position of a role among the fields of a node type,
+// so that children are kept in the order the converter produces
+func fieldOrder(nodeType, role string) int {
+	if t, ok := nodeTypes[nodeType]; ok {
+		if field, ok := t.FieldByName(role); ok {
+			return field.Index[0]
+		}
+	}
+	return -1
+}
+
+// insertChild inserts child into parent as the index-th child of its role;
+// a negative index appends it after the existing children of that role
+func insertChild(parent, child *Node, index int) {
+	role := roleOf(child)
+	order := fieldOrder(parent.Type, role)
+
+	at := len(parent.Children)
+	seen := 0
+	for i, sibling := range parent.Children {
+		siblingOrder := fieldOrder(parent.Type, roleOf(sibling))
+		if siblingOrder > order || (siblingOrder == order && index >= 0 && seen == index) {
+			at = i
+			break
+		}
+		if siblingOrder == order {
+			seen++
+		}
+	}
+
+	parent.Children = append(parent.Children, nil)
+	copy(parent.Children[at+1:], parent.Children[at:])
+	parent.Children[at] = child
+	child.Parent = parent
+}
+
+// removeChild detaches n from its parent
+func removeChild(n *Node) {
+	if n.Parent == nil {
+		return
+	}
+	children := n.Parent.Children
+	for i, sibling := range children {
+		if sibling == n {
+			n.Parent.Children = append(children[:i:i], children[i+1:]...)
+			break
+		}
+	}
+	n.Parent = nil
+}
+
+// FindPath resolves a slash-separated path below node. Each segment names a
+// role, optionally with an index among the children of that role, such as
+// "Decls[2]/Body/List[0]"; a bare number selects a child by position. An
+// empty path returns node itself, and a path on a Program starts at its file.
+func FindPath(node *Node, path string) (*Node, error) {
+	if node == nil {
+		return nil, fmt.Errorf("no node")
+	}
+	if node.Type == "Program" && len(node.Children) > 0 {
+		node = node.Children[0]
+	}
+
+	current := node
+	for _, segment := range strings.Split(strings.Trim(path, "/"), "/") {
+		if segment == "" {
+			continue
+		}
+
+		if i, err := strconv.Atoi(segment); err == nil {
+			if i < 0 || i >= len(current.Children) {
+				return nil, fmt.Errorf("%s has no child %d", current.Type, i)
+			}
+			current = current.Children[i]
+			continue
+		}
+
+		role, index := segment, 0
+		if open := strings.Index(segment, "["); open >= 0 && strings.HasSuffix(segment, "]") {
+			i, err := strconv.Atoi(segment[open+1 : len(segment)-1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid path segment %q", segment)
+			}
+			role, index = segment[:open], i
+		}
+		children := childrenByRole(current, role)
+		if index < 0 || index >= len(children) {
+			return nil, fmt.Errorf("%s has no %s[%d]", current.Type, role, index)
+		}
+		current = children[index]
+	}
+	return current, nil
+}
+
+// Path returns the path of n from the root of its tree in the form
+// accepted by FindPath
+func Path(n *Node) string {
+	var segments []string
+	for ; n != nil && n.Parent != nil; n = n.Parent {
+		if n.Parent.Type == "Program" {
+			break
+		}
+		role := roleOf(n)
+		if len(childrenByRole(n.Parent, role)) > 1 {
+			role = fmt.Sprintf("%s[%d]", role, indexInRole(n))
+		}
+		segments = append([]string{role}, segments...)
+	}
+	return strings.Join(segments, "/")
+}
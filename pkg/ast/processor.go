@@ -4,11 +4,13 @@ import (
 	"go/ast"
 	"go/parser"
 	"go/token"
+	"log"
 	
 	"github.com/knoxai/AI-Native-Development-System/pkg/semantics"
 )
 
-// Node represents a node in our abstract syntax tree
+// Node represents a node in our abstract syntax tree.
+// Nodes are encoded to JSON in the versioned format described in json.go.
 type Node struct {
 	Type     string
 	Value    string
@@ -33,56 +35,79 @@ func NewProcessor(model *semantics.Model) *Processor {
 
 // ParseGoCode parses Go code into our AST representation
 func (p *Processor) ParseGoCode(code string) (*Node, error) {
+	return p.ParseGoFile("", code)
+}
+
+// ParseGoFile parses the Go code of a named file. The name is kept in the
+// File node's metadata and identifies the file in the semantic model.
+func (p *Processor) ParseGoFile(filename, code string) (*Node, error) {
 	fset := token.NewFileSet()
-	node, err := parser.ParseFile(fset, "", code, parser.AllErrors)
+	node, err := parser.ParseFile(fset, filename, code, parser.AllErrors|parser.ParseComments)
 	if err != nil {
 		return nil, err
 	}
 	
 	// Convert Go's AST to our internal representation
-	return p.convertGoAST(node), nil
+	return p.convertGoAST(fset, node), nil
 }
 
-// convertGoAST converts Go's AST to our internal representation
-func (p *Processor) convertGoAST(node ast.Node) *Node {
-	// This is a simplified implementation
-	// In a real system, this would be a comprehensive traversal of the AST
-	
+// convertGoAST converts Go's AST to our internal representation.
+// Every go/ast node becomes a Node whose Type is the go/ast type name
+// (FuncDecl, Ident, ...) and whose children are the node's sub-trees in
+// field order, each tagged with the field it came from under the "role"
+// metadata key. Positions, comments and type expressions are kept in
+// Metadata.
+func (p *Processor) convertGoAST(fset *token.FileSet, node ast.Node) *Node {
 	root := &Node{
 		Type:     "Program",
 		Children: []*Node{},
 		Metadata: map[string]interface{}{},
 	}
 	
-	// Visitor pattern to traverse the AST
-	ast.Inspect(node, func(n ast.Node) bool {
-		if n == nil {
-			return true
+	c := &converter{fset: fset}
+	if child := c.convert(node, ""); child != nil {
+		child.Parent = root
+		root.Children = append(root.Children, child)
+		if file, ok := node.(*ast.File); ok {
+			root.Metadata["package"] = file.Name.Name
 		}
-		
-		// Here we would add different node types based on the AST node type
-		// This is simplified for demonstration
-		
-		return true
-	})
+	}
 	
 	return root
 }
 
-// GenerateCode converts our AST representation back to code
-func (p *Processor) GenerateCode(node *Node) string {
-	// This would generate code from our AST representation
-	// Simplified for demonstration
-	return "// Generated code would be here"
+// GenerateCode converts our AST representation back to gofmt-formatted code.
+// Program and File nodes produce a complete source file including its
+// comments; any other node produces the source of that node alone.
+func (p *Processor) GenerateCode(node *Node) (string, error) {
+	return generateCode(node)
 }
 
-// ModifyAST allows direct modification of the AST
+// ModifyAST applies a structured edit operation to the file node belongs to.
+// See the Op constants for the supported operations and modify.go for the
+// parameters each of them takes. Errors are reported as *OperationError,
+// wrapping ErrUnknownOperation, ErrInvalidParams or ErrTargetNotFound.
 func (p *Processor) ModifyAST(node *Node, operation string, params map[string]interface{}) (*Node, error) {
-	// Handle various operations like adding a function, changing a method, etc.
-	// This is a simplified implementation
+	file := node
+	if file != nil && file.Type == "Program" && len(file.Children) > 0 {
+		file = file.Children[0]
+	}
+	if file == nil || file.Type != "File" {
+		return nil, &OperationError{Operation: operation, Err: ErrInvalidParams, Detail: "node is not a Go file"}
+	}
 	
-	// After modification, update the semantic model
-	p.semanticModel.UpdateFromAST(node)
+	m := &modification{operation: operation, file: file, params: params}
+	if err := m.apply(); err != nil {
+		return nil, err
+	}
+	
+	// After modification, update the semantic model. The model keeps
+	// its facts per file, so code parsed without a name is left out.
+	if filename, _ := file.Metadata[MetaFilename].(string); p.semanticModel != nil && filename != "" {
+		if err := p.semanticModel.UpdateFromAST(node); err != nil {
+			log.Printf("Warning: Could not update semantic model: %v", err)
+		}
+	}
 	
 	return node, nil
-}
\ No newline at end of file
+}
@@ -0,0 +1,127 @@
+package ast
+
+import (
+	"encoding/json"
+	"go/format"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// repoRoot is the root of the repository, relative to this package
+const repoRoot = "../.."
+
+// roundTripFiles lists the files the round-trip tests run over: every Go
+// file of the repository, which includes the testdata files written to
+// cover the syntax the repository itself does not use
+func roundTripFiles(t *testing.T) []string {
+	t.Helper()
+
+	var files []string
+	err := filepath.WalkDir(repoRoot, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if path != repoRoot && (strings.HasPrefix(d.Name(), ".") || d.Name() == "vendor") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if strings.HasSuffix(path, ".go") {
+			files = append(files, path)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatal("no Go files found in the repository")
+	}
+	return files
+}
+
+// parseTestdata parses a file and returns its gofmt form as the expected
+// output of code generation
+func parseTestdata(t *testing.T, name string) (*Node, string) {
+	t.Helper()
+
+	src, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := format.Source(src)
+	if err != nil {
+		t.Fatalf("gofmt %s: %v", name, err)
+	}
+
+	root, err := NewProcessor(nil).ParseGoFile(name, string(src))
+	if err != nil {
+		t.Fatalf("ParseGoFile(%s): %v", name, err)
+	}
+	return root, string(want)
+}
+
+func TestGenerateCodeRoundTrip(t *testing.T) {
+	p := NewProcessor(nil)
+	for _, name := range roundTripFiles(t) {
+		t.Run(name, func(t *testing.T) {
+			root, want := parseTestdata(t, name)
+
+			got, err := p.GenerateCode(root)
+			if err != nil {
+				t.Fatalf("GenerateCode: %v", err)
+			}
+			if got != want {
+				t.Errorf("generated code differs from gofmt output\n--- got ---\n%s\n--- want ---\n%s", got, want)
+			}
+
+			reparsed, err := p.ParseGoFile(name, got)
+			if err != nil {
+				t.Fatalf("reparse generated code: %v", err)
+			}
+			if !Equal(root, reparsed) {
+				t.Errorf("tree of generated code differs from the original:\n%v", Diff(root, reparsed))
+			}
+		})
+	}
+}
+
+func TestJSONRoundTrip(t *testing.T) {
+	p := NewProcessor(nil)
+	for _, name := range roundTripFiles(t) {
+		t.Run(name, func(t *testing.T) {
+			root, want := parseTestdata(t, name)
+
+			data, err := json.Marshal(root)
+			if err != nil {
+				t.Fatalf("Marshal: %v", err)
+			}
+			decoded := &Node{}
+			if err := json.Unmarshal(data, decoded); err != nil {
+				t.Fatalf("Unmarshal: %v", err)
+			}
+			if !Equal(root, decoded) {
+				t.Errorf("decoded tree differs from the original:\n%v", Diff(root, decoded))
+			}
+
+			got, err := p.GenerateCode(decoded)
+			if err != nil {
+				t.Fatalf("GenerateCode: %v", err)
+			}
+			if got != want {
+				t.Errorf("code generated from JSON differs from gofmt output\n--- got ---\n%s\n--- want ---\n%s", got, want)
+			}
+		})
+	}
+}
+
+func TestJSONRejectsUnknownVersion(t *testing.T) {
+	err := json.Unmarshal([]byte(`{"version": 99, "root": {"type": "Program"}}`), &Node{})
+	if err == nil {
+		t.Fatal("expected an error for an unsupported schema version")
+	}
+}
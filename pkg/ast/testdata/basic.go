@@ -0,0 +1,59 @@
+// Package basic is a small program with the common declaration forms.
+package basic
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// MaxUsers limits how many users a store keeps.
+const MaxUsers = 100
+
+var (
+	// ErrNotFound is returned when a user is missing.
+	ErrNotFound = errors.New("user not found")
+	defaultName = "guest" // used when no name is given
+)
+
+// User is a registered user.
+type User struct {
+	ID    int    `json:"id"`
+	Name  string `json:"name"`
+	Email string `json:"email,omitempty"`
+}
+
+// Store keeps users in memory.
+type Store struct {
+	users map[int]*User
+	next  int
+}
+
+// NewStore creates an empty store.
+func NewStore() *Store {
+	return &Store{users: map[int]*User{}, next: 1}
+}
+
+// Add stores a user and returns its ID.
+func (s *Store) Add(name, email string) (int, error) {
+	if len(s.users) >= MaxUsers {
+		return 0, fmt.Errorf("store is full (%d users)", MaxUsers)
+	}
+	if strings.TrimSpace(name) == "" {
+		name = defaultName
+	}
+
+	id := s.next
+	s.next++
+	s.users[id] = &User{ID: id, Name: name, Email: email}
+	return id, nil
+}
+
+// Get looks a user up by ID.
+func (s *Store) Get(id int) (*User, error) {
+	u, ok := s.users[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return u, nil
+}
@@ -0,0 +1,27 @@
+// Package comments keeps comments in every position gofmt allows.
+package comments
+
+/*
+Block comment before a declaration.
+*/
+type config struct {
+	// Name of the thing.
+	Name string // trailing field comment
+
+	/* block comment on its own line */
+	Size int
+}
+
+// load reads a config.
+//
+// It returns defaults.
+func load() config {
+	// leading statement comment
+	c := config{
+		Name: "x", // trailing element comment
+		Size: 1,
+	}
+	return c // trailing return comment
+}
+
+// end of file comment
@@ -0,0 +1,67 @@
+package control
+
+import "fmt"
+
+type shape interface {
+	Area() float64
+}
+
+type square struct{ side float64 }
+
+func (s square) Area() float64 { return s.side * s.side }
+
+// classify exercises the statement forms.
+func classify(values []interface{}, done <-chan struct{}, out chan<- string) {
+	defer close(out)
+
+outer:
+	for i, v := range values {
+		select {
+		case <-done:
+			break outer
+		default:
+		}
+
+		switch x := v.(type) {
+		case int:
+			if x < 0 {
+				out <- "negative"
+				continue
+			} else if x == 0 {
+				out <- "zero"
+			} else {
+				out <- fmt.Sprint("positive ", i)
+			}
+		case shape:
+			out <- fmt.Sprintf("area %.2f", x.Area())
+		case nil:
+			goto end
+		default:
+			out <- "unknown"
+		}
+	}
+
+end:
+	for n := 0; n < 3; n++ {
+		switch {
+		case n%2 == 0:
+			fallthrough
+		case n > 10:
+			go func(k int) {
+				_ = k
+			}(n)
+		}
+	}
+}
+
+func slices(xs []int) (head, tail []int) {
+	head = xs[:1]
+	tail = xs[1:len(xs):cap(xs)]
+	m := map[string][]int{"a": {1, 2}, "b": nil}
+	_ = m
+	var arr [3]int
+	arr[0] += 2
+	p := &arr
+	(*p)[1]--
+	return
+}
@@ -0,0 +1,36 @@
+package generics
+
+// Number is satisfied by the built-in numeric types.
+type Number interface {
+	~int | ~int64 | ~float64
+}
+
+// Pair holds two values of possibly different types.
+type Pair[K comparable, V any] struct {
+	Key   K
+	Value V
+}
+
+// Sum adds up a slice of numbers.
+func Sum[T Number](xs ...T) T {
+	var total T
+	for _, x := range xs {
+		total += x
+	}
+	return total
+}
+
+// Map applies f to every element.
+func Map[T, U any](xs []T, f func(T) U) []U {
+	out := make([]U, 0, len(xs))
+	for _, x := range xs {
+		out = append(out, f(x))
+	}
+	return out
+}
+
+func use() {
+	_ = Sum[int](1, 2, 3)
+	_ = Map([]int{1, 2}, func(i int) string { return string(rune('a' + i)) })
+	_ = Pair[string, int]{Key: "a", Value: 1}
+}
@@ -0,0 +1,8 @@
+package unformatted
+
+import ("fmt";"os")
+
+func  main( ) {
+    x:=os.Args
+  if len(x)>1{fmt.Println( x[1] ) } // print the first argument
+}
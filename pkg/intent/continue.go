@@ -0,0 +1,117 @@
+package intent
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/knoxai/AI-Native-Development-System/pkg/llm"
+)
+
+// maxContinuations caps the requests made to finish an answer that was cut
+// off at the token limit
+const maxContinuations = 3
+
+// continuePrompt asks the LLM for the rest of an answer that was cut off
+const continuePrompt = `Your answer was cut off by the length limit. Continue it exactly where it stopped, starting with the next character.
+Do not repeat anything, do not add a code fence or any commentary: the two parts joined must make up the complete answer.`
+
+// minOverlap is the shortest repetition of the end of an answer removed
+// from the start of its continuation; shorter ones may well be intended
+const minOverlap = 16
+
+// continueAnswer asks for the rest of response while it is cut off at the
+// token limit, up to maxContinuations times, and joins the pieces into its
+// text: its content, or the arguments of its tool call when it answered
+// with one. It stops early once complete accepts the text. Continuations
+// are asked for as plain text, since a response format would make the LLM
+// start a new object; onToken, when set, is called with their tokens. It
+// returns how many continuations were requested. A failed continuation
+// leaves the answer cut off, unless ctx was cancelled.
+func (p *Processor) continueAnswer(ctx context.Context, c *call, messages []llm.ChatMessage, response *llm.ChatCompletionResponse, complete func(text string) bool, onToken func(token string)) (int, error) {
+	count := 0
+	for count < maxContinuations && response.Truncated() {
+		answer := answerText(response)
+		text := *answer
+		if complete != nil && complete(text) {
+			response.Choices[0].FinishReason = llm.FinishStop
+			break
+		}
+		log.Printf("LLM answer was cut off at %d characters, asking for continuation %d of %d", len(text), count+1, maxContinuations)
+
+		followUp := append(append([]llm.ChatMessage(nil), messages...),
+			llm.ChatMessage{Role: "assistant", Content: text},
+			llm.ChatMessage{Role: "user", Content: continuePrompt},
+		)
+		var more *llm.ChatCompletionResponse
+		var err error
+		if onToken != nil {
+			more, err = c.stream(ctx, followUp, onToken, nil)
+		} else {
+			more, err = c.complete(ctx, followUp, nil)
+		}
+		count++
+		if ctx.Err() != nil {
+			return count, ctx.Err()
+		}
+		if err != nil {
+			log.Printf("Error asking the LLM API to continue its answer: %v", err)
+			break
+		}
+		if len(more.Choices) == 0 {
+			log.Printf("Error asking the LLM API to continue its answer: no response")
+			break
+		}
+
+		*answer = joinContinuation(text, more.Choices[0].Message.Content)
+		response.Choices[0].FinishReason = more.Choices[0].FinishReason
+	}
+	return count, nil
+}
+
+// answerText returns where the text of response is kept: the arguments of
+// its last tool call when it answered with tool calls and no content, or
+// else its content
+func answerText(response *llm.ChatCompletionResponse) *string {
+	message := &response.Choices[0].Message
+	if strings.TrimSpace(message.Content) == "" && len(message.ToolCalls) > 0 {
+		return &message.ToolCalls[len(message.ToolCalls)-1].Function.Arguments
+	}
+	return &message.Content
+}
+
+// joinContinuation appends the continuation of an answer to it, leaving
+// out a code fence around the continuation and the end of the answer when
+// the LLM repeated it
+func joinContinuation(text, more string) string {
+	if trimmed := strings.TrimSpace(more); strings.HasPrefix(trimmed, "```") && !strings.Contains(text, "```") {
+		more = llm.StripCodeFence(trimmed)
+	}
+	for n := min(len(text), len(more)); n >= minOverlap; n-- {
+		if strings.HasSuffix(text, more[:n]) {
+			return text + more[n:]
+		}
+	}
+	return text + more
+}
+
+// reportTruncation records in sections how many continuations an answer
+// took, under "continuations", and under "truncated" that it is still
+// incomplete when it was cut off once more
+func reportTruncation(sections map[string]string, response *llm.ChatCompletionResponse, continuations int) {
+	if continuations > 0 {
+		sections["continuations"] = strconv.Itoa(continuations)
+	}
+	if response.Truncated() {
+		sections["truncated"] = fmt.Sprintf("the answer was cut off at the token limit after %d continuations and is incomplete", continuations)
+		log.Printf("LLM answer is incomplete: %s", sections["truncated"])
+	}
+}
+
+// isCompleteCodeBundle tells whether text holds a whole code bundle
+func isCompleteCodeBundle(text string) bool {
+	_, err := decodeCodeBundle(text)
+	return err == nil
+}
@@ -0,0 +1,220 @@
+package intent
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/knoxai/AI-Native-Development-System/pkg/llm"
+	"github.com/knoxai/AI-Native-Development-System/pkg/semantics"
+)
+
+// demoPackage is the package of the code written by the demo provider
+const demoPackage = "generated"
+
+// intentPattern finds the intent quoted in the prompts of the processor
+var intentPattern = regexp.MustCompile(`(?s)Intent: "(.*?)"\n\n`)
+
+// NewDemoProvider creates a fake LLM provider that answers the processor's
+// prompts without a language model: intents are parsed by keywords, code is
+// a stub named after the intent, and questions are translated into queries
+// over model by its heuristics. It lets the system be tried offline.
+func NewDemoProvider(model *semantics.Model) *llm.FakeProvider {
+	provider := llm.NewFakeProvider(
+		llm.FakeRule{Format: "intent", Respond: demoIntent},
+		llm.FakeRule{Format: "code_bundle", Respond: demoCodeBundle},
+		llm.FakeRule{Respond: func(req llm.ChatCompletionRequest) (string, error) {
+			if model == nil {
+				return "", errors.New("no semantic model to query")
+			}
+			question := req.Messages[len(req.Messages)-1].Content
+			q := model.HeuristicQuery(question)
+			if q == nil {
+				return "", fmt.Errorf("no query for %q", question)
+			}
+			return q.String(), nil
+		}},
+	)
+	provider.DefaultModel = "demo"
+	provider.TokenDelay = 5 * time.Millisecond
+	return provider
+}
+
+// demoRawIntent returns the intent quoted in a prompt of the processor
+func demoRawIntent(req llm.ChatCompletionRequest) (string, error) {
+	for i := len(req.Messages) - 1; i >= 0; i-- {
+		if match := intentPattern.FindStringSubmatch(req.Messages[i].Content); match != nil {
+			return match[1], nil
+		}
+	}
+	return "", errors.New("no intent in the prompt")
+}
+
+// demoIntent answers an intent parsing prompt
+func demoIntent(req llm.ChatCompletionRequest) (string, error) {
+	raw, err := demoRawIntent(req)
+	if err != nil {
+		return "", err
+	}
+
+	intent := parseIntentKeywords(strings.ToLower(raw))
+	if intent.Type == "" {
+		intent.Type = "Create"
+	}
+	target := demoKind(raw)
+	if target == "Struct" {
+		target = "Class"
+	}
+	answer, err := json.Marshal(map[string]interface{}{
+		"type":        intent.Type,
+		"target":      target,
+		"constraints": []string{},
+		"parameters":  map[string]string{"name": demoName(raw)},
+	})
+	return string(answer), err
+}
+
+// demoCodeBundle answers a code generation prompt with a stub for the
+// intent and its semantics
+func demoCodeBundle(req llm.ChatCompletionRequest) (string, error) {
+	raw, err := demoRawIntent(req)
+	if err != nil {
+		return "", err
+	}
+
+	name := demoName(raw)
+	header := fmt.Sprintf(`// Package %s was written by the offline demo provider, which answers
+// without a language model.
+package %s
+`, demoPackage, demoPackage)
+	section := semantics.Section{
+		Entities: []semantics.SectionEntity{
+			{ID: demoPackage, Type: "Package", Name: demoPackage},
+		},
+	}
+	declare := func(entityType, entityName, description string) {
+		section.Entities = append(section.Entities, semantics.SectionEntity{
+			ID: entityName, Type: entityType, Name: entityName, Description: description,
+		})
+		section.Relations = append(section.Relations, semantics.SectionRelation{
+			Type: "Contains", From: demoPackage, To: entityName,
+		})
+	}
+
+	var code string
+	switch kind := demoKind(raw); kind {
+	case "Interface":
+		code = fmt.Sprintf(`%s
+// %s is a stub for the intent %q.
+type %s interface {
+	// Run carries out the intent
+	Run() error
+}
+`, header, name, raw, name)
+		declare(kind, name, raw)
+	case "Struct":
+		code = fmt.Sprintf(`%s
+// %s is a stub for the intent %q.
+type %s struct {
+	ID   string
+	Name string
+}
+
+// New%s creates a %s.
+func New%s(id, name string) *%s {
+	return &%s{ID: id, Name: name}
+}
+`, header, name, raw, name, name, name, name, name, name)
+		declare(kind, name, raw)
+		declare("Function", "New"+name, "creates a "+name)
+		section.Relations = append(section.Relations, semantics.SectionRelation{
+			Type: "Returns", From: "New" + name, To: name,
+		})
+	default:
+		code = fmt.Sprintf(`%s
+import "errors"
+
+// %s is a stub for the intent %q.
+func %s(input string) (string, error) {
+	if input == "" {
+		return "", errors.New("input is required")
+	}
+	// TODO: implement
+	return input, nil
+}
+`, header, name, raw, name)
+		declare("Function", name, raw)
+	}
+
+	semanticsJSON, err := json.Marshal(section)
+	if err != nil {
+		return "", err
+	}
+	answer, err := json.Marshal(codeBundle{Code: code, Semantics: semanticsJSON})
+	return string(answer), err
+}
+
+// demoKind guesses the kind of declaration an intent asks for
+func demoKind(raw string) string {
+	lower := strings.ToLower(raw)
+	switch {
+	case strings.Contains(lower, "interface"):
+		return "Interface"
+	case strings.Contains(lower, "struct") || strings.Contains(lower, "class") || strings.Contains(lower, "type "):
+		return "Struct"
+	}
+	return "Function"
+}
+
+// demoStopWords are left out of the names made up from intents
+var demoStopWords = map[string]bool{
+	"a": true, "an": true, "the": true, "create": true, "make": true, "add": true,
+	"write": true, "generate": true, "implement": true, "new": true, "function": true,
+	"func": true, "method": true, "struct": true, "class": true, "type": true,
+	"interface": true, "that": true, "which": true, "to": true, "for": true, "of": true,
+	"in": true, "on": true, "with": true, "and": true, "or": true, "please": true,
+	"me": true, "go": true, "golang": true, "simple": true, "some": true, "it": true,
+	"is": true, "by": true, "from": true, "called": true, "named": true,
+}
+
+// demoName makes up an exported Go name for what an intent asks for: the
+// name following "called" or "named", or else its first significant words
+func demoName(raw string) string {
+	words := strings.FieldsFunc(raw, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_'
+	})
+
+	exported := func(word string) string {
+		runes := []rune(word)
+		runes[0] = unicode.ToUpper(runes[0])
+		return string(runes)
+	}
+	for i, word := range words[:max(len(words)-1, 0)] {
+		if lower := strings.ToLower(word); lower == "called" || lower == "named" {
+			if unicode.IsLetter([]rune(words[i+1])[0]) {
+				return exported(words[i+1])
+			}
+		}
+	}
+
+	var name strings.Builder
+	count := 0
+	for _, word := range words {
+		if count == 3 {
+			break
+		}
+		if demoStopWords[strings.ToLower(word)] || !unicode.IsLetter([]rune(word)[0]) {
+			continue
+		}
+		name.WriteString(exported(strings.ToLower(word)))
+		count++
+	}
+	if name.Len() == 0 {
+		return "Generated"
+	}
+	return name.String()
+}
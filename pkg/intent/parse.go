@@ -0,0 +1,269 @@
+package intent
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/knoxai/AI-Native-Development-System/pkg/llm"
+	"github.com/knoxai/AI-Native-Development-System/pkg/semantics"
+)
+
+// Intent types
+var intentTypes = []string{"Create", "Modify", "Delete", "Query"}
+
+// intentSchema is the JSON Schema of the object expected from the LLM when
+// it parses an intent
+const intentSchema = `{
+  "type": "object",
+  "properties": {
+    "type": {"type": "string", "enum": ["Create", "Modify", "Delete", "Query"]},
+    "target": {"type": "string", "description": "Function, Class, Module, Variable, Interface, etc."},
+    "constraints": {"type": "array", "items": {"type": "string"}},
+    "parameters": {"type": "object"}
+  },
+  "required": ["type"]
+}`
+
+// codeBundleSchema is the JSON Schema of the code bundle expected from the
+// LLM when it generates code for an intent
+const codeBundleSchema = `{
+  "type": "object",
+  "properties": {
+    "code": {"type": "string", "description": "the generated Go source file"},
+    "ast": {"description": "the go/ast tree of the code: nodes with \"type\", \"value\" and \"children\""},
+    "semantics": ` + semantics.SectionJSONSchema + `
+  },
+  "required": ["code", "semantics"]
+}`
+
+// codeBundle is the code generated for an intent with its description
+type codeBundle struct {
+	Code      string          `json:"code"`
+	AST       json.RawMessage `json:"ast"`
+	Semantics json.RawMessage `json:"semantics"`
+}
+
+// decodeCodeBundle reads the code bundle in text into sections, as expected
+// by the rest of the processor. The AST and semantics are kept as JSON.
+func decodeCodeBundle(text string) (map[string]string, error) {
+	object, err := extractJSONObject(text)
+	if err != nil {
+		return nil, err
+	}
+
+	var bundle codeBundle
+	if err := json.Unmarshal([]byte(object), &bundle); err != nil {
+		return nil, fmt.Errorf("invalid code bundle: %v", err)
+	}
+	if strings.TrimSpace(bundle.Code) == "" {
+		return nil, errors.New(`"code" is required and must not be empty`)
+	}
+
+	return map[string]string{
+		"code":      bundle.Code,
+		"ast":       rawJSONText(bundle.AST),
+		"semantics": rawJSONText(bundle.Semantics),
+	}, nil
+}
+
+// Section markers of the plain text answer format, which models that
+// cannot produce structured output are asked to fall back to
+const (
+	markerCode      = "===CODE==="
+	markerAST       = "===AST==="
+	markerSemantics = "===SEMANTICS==="
+)
+
+// decodeMarkedSections reads an answer that separates its sections with
+// the section markers instead of being a code bundle. A section runs up to
+// the next marker or the end of the answer.
+func decodeMarkedSections(text string) (map[string]string, error) {
+	markers := map[string]string{markerCode: "code", markerAST: "ast", markerSemantics: "semantics"}
+	starts := map[string]int{}
+	for marker := range markers {
+		if i := strings.Index(text, marker); i >= 0 {
+			starts[marker] = i
+		}
+	}
+	if _, ok := starts[markerCode]; !ok {
+		return nil, errors.New("no " + markerCode + " marker found")
+	}
+
+	sections := map[string]string{}
+	for marker, start := range starts {
+		end := len(text)
+		for _, other := range starts {
+			if other > start && other < end {
+				end = other
+			}
+		}
+		sections[markers[marker]] = strings.TrimSpace(text[start+len(marker) : end])
+	}
+	if sections["code"] == "" {
+		return nil, errors.New("the code section is empty")
+	}
+	return sections, nil
+}
+
+// rawJSONText returns a JSON value as text: strings are unquoted, as models
+// sometimes encode nested JSON as a string, and other values kept as JSON
+func rawJSONText(raw json.RawMessage) string {
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return s
+	}
+	if string(raw) == "null" {
+		return ""
+	}
+	return string(raw)
+}
+
+// decodeIntent reads the intent the LLM described in text. The JSON object
+// may be wrapped in a markdown code fence, surrounded by prose and contain
+// trailing commas. It is checked against intentSchema; fields outside the
+// schema are ignored.
+func decodeIntent(text, rawIntent string) (*Intent, error) {
+	object, err := extractJSONObject(text)
+	if err != nil {
+		return nil, err
+	}
+
+	var fields map[string]interface{}
+	if err := json.Unmarshal([]byte(object), &fields); err != nil {
+		return nil, fmt.Errorf("invalid JSON: %v", err)
+	}
+
+	intent := &Intent{
+		Raw:        rawIntent,
+		Parameters: make(map[string]interface{}),
+	}
+
+	typ, ok := fields["type"].(string)
+	if !ok {
+		return nil, errors.New(`"type" is required and must be a string`)
+	}
+	for _, valid := range intentTypes {
+		if strings.EqualFold(typ, valid) {
+			intent.Type = valid
+		}
+	}
+	if intent.Type == "" {
+		return nil, fmt.Errorf(`"type" must be one of %s, not %q`, strings.Join(intentTypes, ", "), typ)
+	}
+
+	if target, present := fields["target"]; present && target != nil {
+		if intent.Target, ok = target.(string); !ok {
+			return nil, errors.New(`"target" must be a string`)
+		}
+	}
+
+	if constraints, present := fields["constraints"]; present && constraints != nil {
+		list, ok := constraints.([]interface{})
+		if !ok {
+			return nil, errors.New(`"constraints" must be an array of strings`)
+		}
+		for i, item := range list {
+			constraint, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf(`"constraints"[%d] must be a string`, i)
+			}
+			intent.Constraints = append(intent.Constraints, constraint)
+		}
+	}
+
+	if parameters, present := fields["parameters"]; present && parameters != nil {
+		object, ok := parameters.(map[string]interface{})
+		if !ok {
+			return nil, errors.New(`"parameters" must be an object`)
+		}
+		intent.Parameters = object
+	}
+
+	return intent, nil
+}
+
+// extractJSONObject finds the first JSON object in text and removes
+// trailing commas from it
+func extractJSONObject(text string) (string, error) {
+	text = llm.StripCodeFence(text)
+	start := strings.Index(text, "{")
+	if start < 0 {
+		return "", errors.New("no JSON object found in the response")
+	}
+
+	// Find the closing brace, skipping braces inside strings
+	depth, inString, escaped := 0, false, false
+	for i := start; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case escaped:
+			escaped = false
+		case inString && c == '\\':
+			escaped = true
+		case c == '"':
+			inString = !inString
+		case inString:
+		case c == '{':
+			depth++
+		case c == '}':
+			depth--
+			if depth == 0 {
+				return removeTrailingCommas(text[start : i+1]), nil
+			}
+		}
+	}
+	return "", errors.New("the JSON object in the response is not closed")
+}
+
+// removeTrailingCommas drops commas that directly precede a closing brace
+// or bracket, outside of strings
+func removeTrailingCommas(object string) string {
+	var b strings.Builder
+	inString, escaped := false, false
+	for i := 0; i < len(object); i++ {
+		c := object[i]
+		switch {
+		case escaped:
+			escaped = false
+		case inString && c == '\\':
+			escaped = true
+		case c == '"':
+			inString = !inString
+		case !inString && c == ',':
+			rest := strings.TrimLeft(object[i+1:], " \t\r\n")
+			if strings.HasPrefix(rest, "}") || strings.HasPrefix(rest, "]") {
+				continue
+			}
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+// parseIntentKeywords recognises the intent type and target from keywords.
+// It is used when no LLM is available or the LLM cannot be understood.
+func parseIntentKeywords(rawIntent string) *Intent {
+	intent := &Intent{
+		Raw:        rawIntent,
+		Parameters: make(map[string]interface{}),
+	}
+
+	if strings.Contains(rawIntent, "create") || strings.Contains(rawIntent, "make") {
+		intent.Type = "Create"
+		if strings.Contains(rawIntent, "function") {
+			intent.Target = "Function"
+		} else if strings.Contains(rawIntent, "class") {
+			intent.Target = "Class"
+		}
+	} else if strings.Contains(rawIntent, "modify") || strings.Contains(rawIntent, "change") {
+		intent.Type = "Modify"
+	} else if strings.Contains(rawIntent, "delete") || strings.Contains(rawIntent, "remove") {
+		intent.Type = "Delete"
+	} else if strings.Contains(rawIntent, "query") || strings.Contains(rawIntent, "find") {
+		intent.Type = "Query"
+	}
+
+	return intent
+}
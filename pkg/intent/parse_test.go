@@ -0,0 +1,49 @@
+package intent
+
+import "testing"
+
+func TestDecodeMarkedSections(t *testing.T) {
+	text := `Here is the code.
+===CODE===
+package auth
+
+func Login() {}
+===AST===
+{"type": "File"}
+===SEMANTICS===
+{"entities": [], "relations": []}
+`
+	sections, err := decodeMarkedSections(text)
+	if err != nil {
+		t.Fatalf("decodeMarkedSections: %v", err)
+	}
+	want := map[string]string{
+		"code":      "package auth\n\nfunc Login() {}",
+		"ast":       `{"type": "File"}`,
+		"semantics": `{"entities": [], "relations": []}`,
+	}
+	for name, section := range want {
+		if sections[name] != section {
+			t.Errorf("section %s = %q, want %q", name, sections[name], section)
+		}
+	}
+
+	if _, err := decodeMarkedSections("package auth\n\nfunc Login() {}\n"); err == nil {
+		t.Error("answer without markers decoded")
+	}
+}
+
+func TestDecodeCodeBundle(t *testing.T) {
+	text := "```json\n{\"code\": \"package auth\\n\", \"semantics\": {\"entities\": [],},}\n```"
+	sections, err := decodeCodeBundle(text)
+	if err != nil {
+		t.Fatalf("decodeCodeBundle: %v", err)
+	}
+	if sections["code"] != "package auth\n" || sections["semantics"] != `{"entities": []}` {
+		t.Errorf("unexpected sections %q", sections)
+	}
+
+	if _, err := decodeCodeBundle(`{"code": ""}`); err == nil {
+		t.Error("bundle without code decoded")
+	}
+}
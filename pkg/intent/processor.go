@@ -1,8 +1,12 @@
 package intent
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"go/parser"
+	"go/token"
 	"log"
 	"strings"
 	
@@ -24,7 +28,7 @@ type Intent struct {
 type Processor struct {
 	astProcessor  *ast.Processor
 	semanticModel *semantics.Model
-	llmClient     *llm.Client
+	llmClient     llm.Provider
 }
 
 // NewProcessor creates a new intent processor
@@ -35,50 +39,93 @@ func NewProcessor(astProcessor *ast.Processor, semanticModel *semantics.Model) *
 	}
 }
 
-// SetLLMClient sets the LLM client for the processor
-func (p *Processor) SetLLMClient(client *llm.Client) {
+// Options choose the LLM provider and model of a single call. They let
+// concurrent calls, such as the requests of a server, use different
+// settings without changing the processor.
+type Options struct {
+	// Provider answers the LLM requests of the call instead of the
+	// processor's provider
+	Provider llm.Provider
+	// Model is requested from the provider instead of its default model
+	Model string
+}
+
+// call carries the provider and model of one call through the processor
+type call struct {
+	provider llm.Provider
+	model    string
+}
+
+// newCall resolves the options of a call against the processor's provider
+func (p *Processor) newCall(opts Options) *call {
+	c := &call{provider: opts.Provider, model: opts.Model}
+	if c.provider == nil {
+		c.provider = p.llmClient
+	}
+	return c
+}
+
+// options returns the request options of the call: the given ones and the
+// model, when the call names one
+func (c *call) options(options map[string]interface{}) map[string]interface{} {
+	if options == nil {
+		options = map[string]interface{}{}
+	}
+	if c.model != "" {
+		options["model"] = c.model
+	}
+	return options
+}
+
+// complete asks the call's provider to answer a conversation
+func (c *call) complete(ctx context.Context, messages []llm.ChatMessage, options map[string]interface{}) (*llm.ChatCompletionResponse, error) {
+	return c.provider.GetChatCompletionContext(ctx, messages, c.options(options))
+}
+
+// stream asks the call's provider to answer a conversation token by token
+func (c *call) stream(ctx context.Context, messages []llm.ChatMessage, onToken func(token string), options map[string]interface{}) (*llm.ChatCompletionResponse, error) {
+	return c.provider.StreamChatCompletion(ctx, messages, onToken, c.options(options))
+}
+
+// SetLLMClient sets the LLM provider for the processor. Semantic queries
+// are translated by the LLM from then on.
+func (p *Processor) SetLLMClient(client llm.Provider) {
 	p.llmClient = client
+	if p.semanticModel != nil {
+		p.semanticModel.SetQueryTranslator(p.translateQuery)
+	}
 }
 
 // GetLLMClient returns the current LLM client
-func (p *Processor) GetLLMClient() *llm.Client {
+func (p *Processor) GetLLMClient() llm.Provider {
 	return p.llmClient
 }
 
 // ParseIntent parses a natural language intent into structured form
 func (p *Processor) ParseIntent(rawIntent string) (*Intent, error) {
-	intent := &Intent{
-		Raw:        rawIntent,
-		Parameters: make(map[string]interface{}),
-	}
-	
+	return p.ParseIntentContext(context.Background(), rawIntent, Options{})
+}
+
+// ParseIntentContext is ParseIntent with a context and the options of the
+// call. Cancelling ctx stops the LLM request and returns ctx.Err().
+func (p *Processor) ParseIntentContext(ctx context.Context, rawIntent string, opts Options) (*Intent, error) {
 	// If LLM client is available, use it to parse the intent
-	if p.llmClient != nil {
-		return p.parseIntentWithLLM(rawIntent)
+	if c := p.newCall(opts); c.provider != nil {
+		return p.parseIntentWithLLM(ctx, c, rawIntent)
 	}
 	
 	// Fallback to basic parsing if LLM is not available
-	// Very basic parsing for demonstration
-	if strings.Contains(rawIntent, "create") || strings.Contains(rawIntent, "make") {
-		intent.Type = "Create"
-		if strings.Contains(rawIntent, "function") {
-			intent.Target = "Function"
-		} else if strings.Contains(rawIntent, "class") {
-			intent.Target = "Class"
-		}
-	} else if strings.Contains(rawIntent, "modify") || strings.Contains(rawIntent, "change") {
-		intent.Type = "Modify"
-	} else if strings.Contains(rawIntent, "delete") || strings.Contains(rawIntent, "remove") {
-		intent.Type = "Delete"
-	} else if strings.Contains(rawIntent, "query") || strings.Contains(rawIntent, "find") {
-		intent.Type = "Query"
-	}
-	
-	return intent, nil
+	return parseIntentKeywords(rawIntent), nil
 }
 
-// parseIntentWithLLM uses the LLM API to parse intent
-func (p *Processor) parseIntentWithLLM(rawIntent string) (*Intent, error) {
+// maxIntentAttempts is how many times the LLM is asked to parse an intent
+// before its output is given up on
+const maxIntentAttempts = 3
+
+// parseIntentWithLLM uses the LLM API to parse intent. Output that does not
+// match intentSchema is sent back to the LLM with the validation error so
+// that it can correct itself.
+func (p *Processor) parseIntentWithLLM(ctx context.Context, c *call, rawIntent string) (*Intent, error) {
 	// Prepare messages for the LLM using chat completion
 	messages := []llm.ChatMessage{
 		{
@@ -93,7 +140,10 @@ Always respond with a valid JSON object and nothing else.`,
 			Content: fmt.Sprintf(`Parse this development intent and return a JSON object with type, target, constraints, and parameters:
 Intent: "%s"
 
-Your response should be a valid JSON object like:
+The object must match this schema:
+%s
+
+For example:
 {
   "type": "Create",
   "target": "Function",
@@ -102,100 +152,105 @@ Your response should be a valid JSON object like:
     "name": "login",
     "returnType": "bool"
   }
-}`, rawIntent),
+}`, rawIntent, intentSchema),
 		},
 	}
 	
-	// Get chat completion from OpenRouter
-	response, err := p.llmClient.GetChatCompletion(messages)
-	if err != nil {
-		log.Printf("Error calling LLM API for intent parsing: %v", err)
-		// Fall back to basic parsing
-		intent := &Intent{
-			Raw:        rawIntent,
-			Parameters: make(map[string]interface{}),
+	var lastErr error
+	for attempt := 1; attempt <= maxIntentAttempts; attempt++ {
+		// Get chat completion from OpenRouter, constrained to the schema
+		// where the model supports it
+		response, err := c.complete(ctx, messages, map[string]interface{}{
+			"response_format": llm.JSONSchemaFormat("intent", intentSchema),
+		})
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
 		}
-		
-		// Very basic parsing for demonstration
-		if strings.Contains(rawIntent, "create") || strings.Contains(rawIntent, "make") {
-			intent.Type = "Create"
-			if strings.Contains(rawIntent, "function") {
-				intent.Target = "Function"
-			} else if strings.Contains(rawIntent, "class") {
-				intent.Target = "Class"
+		if err != nil {
+			log.Printf("Error calling LLM API for intent parsing: %v", err)
+			// The user has to fix these; parsing by keywords would only hide them
+			if errors.Is(err, llm.ErrAuth) || errors.Is(err, llm.ErrRateLimited) || errors.Is(err, llm.ErrModelUnavailable) ||
+				errors.Is(err, llm.ErrContextTooLong) || errors.Is(err, llm.ErrBudgetExceeded) {
+				return nil, err
 			}
-		} else if strings.Contains(rawIntent, "modify") || strings.Contains(rawIntent, "change") {
-			intent.Type = "Modify"
-		} else if strings.Contains(rawIntent, "delete") || strings.Contains(rawIntent, "remove") {
-			intent.Type = "Delete"
-		} else if strings.Contains(rawIntent, "query") || strings.Contains(rawIntent, "find") {
-			intent.Type = "Query"
+			// Fall back to basic parsing
+			return parseIntentKeywords(rawIntent), nil
 		}
 		
-		return intent, nil
-	}
-	
-	// Check if we got a response
-	if len(response.Choices) == 0 {
-		return nil, errors.New("no response from LLM API")
-	}
-	
-	// Parse the JSON response
-	text := response.Choices[0].Message.Content
-	log.Printf("LLM intent parsing response: %s", text)
-	
-	// Create the intent object
-	intent := &Intent{
-		Raw:        rawIntent,
-		Parameters: make(map[string]interface{}),
-	}
-	
-	// Extract type and target from the response
-	// In a real implementation, we would parse the JSON properly
-	if strings.Contains(text, `"type": "Create"`) || strings.Contains(text, `"type":"Create"`) {
-		intent.Type = "Create"
-	} else if strings.Contains(text, `"type": "Modify"`) || strings.Contains(text, `"type":"Modify"`) {
-		intent.Type = "Modify"
-	} else if strings.Contains(text, `"type": "Delete"`) || strings.Contains(text, `"type":"Delete"`) {
-		intent.Type = "Delete"
-	} else if strings.Contains(text, `"type": "Query"`) || strings.Contains(text, `"type":"Query"`) {
-		intent.Type = "Query"
-	}
-	
-	if strings.Contains(text, `"target": "Function"`) || strings.Contains(text, `"target":"Function"`) {
-		intent.Target = "Function"
-	} else if strings.Contains(text, `"target": "Class"`) || strings.Contains(text, `"target":"Class"`) {
-		intent.Target = "Class"
-	} else if strings.Contains(text, `"target": "Module"`) || strings.Contains(text, `"target":"Module"`) {
-		intent.Target = "Module"
+		// Check if we got a response
+		if len(response.Choices) == 0 {
+			return nil, errors.New("no response from LLM API")
+		}
+		
+		text := response.FormatContent("intent")
+		log.Printf("LLM intent parsing response: %s", text)
+		
+		intent, err := decodeIntent(text, rawIntent)
+		if err == nil {
+			return intent, nil
+		}
+		lastErr = err
+		log.Printf("Invalid intent from LLM (attempt %d of %d): %v", attempt, maxIntentAttempts, err)
+		
+		// Show the LLM its answer and what is wrong with it
+		messages = append(messages,
+			llm.ChatMessage{Role: "assistant", Content: text},
+			llm.ChatMessage{
+				Role: "user",
+				Content: fmt.Sprintf(`Your response is invalid: %v
+Respond again with only a JSON object matching this schema:
+%s`, err, intentSchema),
+			},
+		)
 	}
 	
-	// In a full implementation, we would parse the JSON to extract constraints and parameters
-	
-	return intent, nil
+	return nil, fmt.Errorf("LLM did not return a valid intent after %d attempts: %w", maxIntentAttempts, lastErr)
 }
 
 // ExecuteIntent executes an intent and returns the result
 func (p *Processor) ExecuteIntent(intent *Intent) (interface{}, error) {
+	return p.ExecuteIntentContext(context.Background(), intent, Options{})
+}
+
+// ExecuteIntentContext is ExecuteIntent with a context and the options of
+// the call. Cancelling ctx stops the LLM requests made for the intent and
+// returns ctx.Err().
+func (p *Processor) ExecuteIntentContext(ctx context.Context, intent *Intent, opts Options) (interface{}, error) {
+	return p.executeIntent(ctx, p.newCall(opts), intent)
+}
+
+// ProcessIntent parses a natural language intent and executes it with the
+// options of the call. It returns the parsed intent along with the result.
+func (p *Processor) ProcessIntent(ctx context.Context, rawIntent string, opts Options) (*Intent, interface{}, error) {
+	intent, err := p.ParseIntentContext(ctx, rawIntent, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	result, err := p.ExecuteIntentContext(ctx, intent, opts)
+	return intent, result, err
+}
+
+// executeIntent dispatches an intent to its handler
+func (p *Processor) executeIntent(ctx context.Context, c *call, intent *Intent) (interface{}, error) {
 	switch intent.Type {
 	case "Create":
-		return p.handleCreateIntent(intent)
+		return p.handleCreateIntent(ctx, c, intent)
 	case "Modify":
-		return p.handleModifyIntent(intent)
+		return p.handleModifyIntent(ctx, c, intent)
 	case "Delete":
-		return p.handleDeleteIntent(intent)
+		return p.handleDeleteIntent(ctx, c, intent)
 	case "Query":
-		return p.handleQueryIntent(intent)
+		return p.handleQueryIntent(ctx, c, intent)
 	default:
 		return nil, errors.New("unknown intent type")
 	}
 }
 
 // handleCreateIntent handles creation intents
-func (p *Processor) handleCreateIntent(intent *Intent) (interface{}, error) {
+func (p *Processor) handleCreateIntent(ctx context.Context, c *call, intent *Intent) (interface{}, error) {
 	// If LLM client is available, use it to generate code
-	if p.llmClient != nil {
-		return p.generateCodeWithLLM(intent)
+	if c.provider != nil {
+		return p.generateCodeWithLLM(ctx, c, intent)
 	}
 	
 	// Generate entities from the intent
@@ -208,14 +263,42 @@ func (p *Processor) handleCreateIntent(intent *Intent) (interface{}, error) {
 	return entities, nil
 }
 
-// generateCodeWithLLM uses the LLM API to generate code based on intent
-func (p *Processor) generateCodeWithLLM(intent *Intent) (interface{}, error) {
-	// Prepare messages for the LLM using chat completion
-	messages := []llm.ChatMessage{
+// generateCodeWithLLM uses the LLM API to generate code based on intent.
+// An answer cut off at the token limit is continued, and reported under
+// "truncated" when it stays incomplete.
+func (p *Processor) generateCodeWithLLM(ctx context.Context, c *call, intent *Intent) (interface{}, error) {
+	// Get chat completion from OpenRouter, constrained to the code bundle
+	// schema where the model supports it
+	messages := codeMessages(intent)
+	response, err := c.complete(ctx, messages, map[string]interface{}{
+		"response_format": llm.JSONSchemaFormat("code_bundle", codeBundleSchema),
+	})
+	if err != nil {
+		log.Printf("Error calling LLM API for code generation: %v", err)
+		return nil, err
+	}
+	
+	// Finish a bundle that was cut off at the token limit
+	continuations, err := p.continueAnswer(ctx, c, messages, response, isCompleteCodeBundle, nil)
+	if err != nil {
+		return nil, err
+	}
+	
+	sections, err := p.codeSections(response)
+	if err != nil {
+		return nil, err
+	}
+	reportTruncation(sections, response, continuations)
+	return sections, nil
+}
+
+// codeMessages prepares the messages asking the LLM to generate code
+func codeMessages(intent *Intent) []llm.ChatMessage {
+	return []llm.ChatMessage{
 		{
 			Role: "system",
 			Content: `You are an expert code generation system that produces clean, well-structured Go code based on natural language intents.
-Your response must follow the exact format specified in the user's request, including the special section markers.`,
+Always respond with a single JSON object following the schema in the user's request, and nothing else.`,
 		},
 		{
 			Role: "user",
@@ -224,106 +307,258 @@ Intent: "%s"
 
 The code should be well-structured, follow best practices, and include comments.
 
-Your response MUST use exactly this format with these exact section markers:
+Respond with a JSON object matching this schema:
+%s
+
+"code" holds the complete Go source file, "ast" the JSON representation of its go/ast tree
+and "semantics" the entities and relationships of the code. In "semantics", relations refer
+to entities by their "id", or by the ID of an entity already in the code base.
+
+If you cannot answer with JSON, use exactly this format with these exact section markers instead:
 ===CODE===
 (generated code here)
 ===AST===
-(JSON representation of AST)
+(JSON representation of the go/ast tree of the code)
 ===SEMANTICS===
-(JSON representation of semantic entities and relationships)`, intent.Raw),
+(JSON object with the entities and relationships of the code, as in "semantics" above)`, intent.Raw, codeBundleSchema),
 		},
 	}
-	
-	// Get chat completion from OpenRouter
-	response, err := p.llmClient.GetChatCompletion(messages)
-	if err != nil {
-		log.Printf("Error calling LLM API for code generation: %v", err)
-		return nil, err
-	}
-	
+}
+
+// codeSections splits the LLM's answer into sections, checks the AST and
+// ingests the semantics
+func (p *Processor) codeSections(response *llm.ChatCompletionResponse) (map[string]string, error) {
 	// Check if we got a response
 	if len(response.Choices) == 0 {
 		return nil, errors.New("no response from LLM API")
 	}
 	
-	// Parse the response sections
-	text := response.Choices[0].Message.Content
+	text := response.FormatContent("code_bundle")
 	log.Printf("LLM code generation response received (length: %d characters)", len(text))
 	
-	// Split the text into sections
-	sections := make(map[string]string)
-	
-	// Extract code section
-	if codeIdx := strings.Index(text, "===CODE==="); codeIdx != -1 {
-		endIdx := strings.Index(text[codeIdx+len("===CODE==="):], "===AST===")
-		if endIdx != -1 {
-			sections["code"] = strings.TrimSpace(text[codeIdx+len("===CODE==="):codeIdx+len("===CODE===")+endIdx])
-		} else {
-			// If AST marker is missing, try to extract until the end
-			sections["code"] = strings.TrimSpace(text[codeIdx+len("===CODE==="):])
-		}
+	sections, err := decodeCodeBundle(text)
+	if err != nil {
+		// Models without structured output may use the section markers
+		sections, err = decodeMarkedSections(text)
 	}
-	
-	// Extract AST section
-	if astIdx := strings.Index(text, "===AST==="); astIdx != -1 {
-		endIdx := strings.Index(text[astIdx+len("===AST==="):], "===SEMANTICS===")
-		if endIdx != -1 {
-			sections["ast"] = strings.TrimSpace(text[astIdx+len("===AST==="):astIdx+len("===AST===")+endIdx])
-		} else {
-			// If SEMANTICS marker is missing, try to extract until the end
-			sections["ast"] = strings.TrimSpace(text[astIdx+len("===AST==="):])
+	if err != nil {
+		// If the response is in neither format, use the entire response as code
+		log.Printf("LLM response is neither a code bundle nor marked sections (%v), using entire response as code", err)
+		sections = map[string]string{
+			"code":      strings.TrimSpace(text),
+			"ast":       "// AST representation not available",
+			"semantics": "// Semantic model not available",
 		}
 	}
 	
-	// Extract semantics section
-	if semIdx := strings.Index(text, "===SEMANTICS==="); semIdx != -1 {
-		sections["semantics"] = strings.TrimSpace(text[semIdx+len("===SEMANTICS==="):])
-	}
-	
 	// Log what sections we found
 	log.Printf("Extracted sections: code=%d bytes, ast=%d bytes, semantics=%d bytes", 
 		len(sections["code"]), len(sections["ast"]), len(sections["semantics"]))
 	
-	// If we didn't find any sections in the expected format, return the entire response as code
-	if len(sections["code"]) == 0 && len(sections["ast"]) == 0 && len(sections["semantics"]) == 0 {
-		log.Printf("LLM response did not contain expected section markers, using entire response as code")
-		sections["code"] = strings.TrimSpace(text)
-		sections["ast"] = "// AST representation not available"
-		sections["semantics"] = "// Semantic model not available"
-	}
+	// Replace the model's AST with one parsed from the generated code
+	p.validateAST(sections)
+	
+	// Merge the declared entities and relations into the semantic model
+	p.ingestSemantics(sections)
 	
 	// Return the parsed sections
 	return sections, nil
 }
 
+// validateAST parses the code section and replaces the AST section with the
+// canonical tree for it. The AST claimed by the model is kept under
+// "ast_claimed" and the differences between the two are listed under
+// "ast_diff", one per line. When the code does not parse the reason is
+// reported under "ast_error" and no AST is shown.
+func (p *Processor) validateAST(sections map[string]string) {
+	if p.astProcessor == nil {
+		return
+	}
+	
+	claimed := sections["ast"]
+	if claimed != "" {
+		sections["ast_claimed"] = claimed
+	}
+	
+	tree, err := p.astProcessor.ParseGoCode(llm.StripCodeFence(sections["code"]))
+	if err != nil {
+		log.Printf("Generated code does not parse: %v", err)
+		sections["ast_error"] = err.Error()
+		sections["ast"] = "// AST not available: the generated code does not parse\n// " + err.Error()
+		return
+	}
+	
+	canonical, err := json.MarshalIndent(tree, "", "  ")
+	if err != nil {
+		log.Printf("Error encoding AST: %v", err)
+		return
+	}
+	sections["ast"] = string(canonical)
+	
+	// Compare against the model's AST when it is in our format
+	var claimedTree ast.Node
+	if err := json.Unmarshal([]byte(llm.StripCodeFence(claimed)), &claimedTree); err != nil {
+		sections["ast_diff"] = "claimed AST is not in the ast.Node format and was replaced"
+		return
+	}
+	var lines []string
+	for _, diff := range ast.Diff(tree, &claimedTree) {
+		lines = append(lines, diff.String())
+	}
+	sections["ast_diff"] = strings.Join(lines, "\n")
+	log.Printf("Claimed AST differs from the generated code in %d places", len(lines))
+}
+
+// ingestSemantics merges the semantics section into the semantic model.
+// What was added and merged is reported under "semantics_ingest" as JSON,
+// and a section that could not be read under "semantics_error".
+func (p *Processor) ingestSemantics(sections map[string]string) {
+	if p.semanticModel == nil || sections["semantics"] == "" {
+		return
+	}
+	
+	section, err := semantics.ParseSection(sections["semantics"])
+	if err != nil {
+		log.Printf("Could not ingest semantics section: %v", err)
+		sections["semantics_error"] = err.Error()
+		return
+	}
+	
+	// Entities belong to the package of the generated code
+	pkg := ""
+	if file, err := parser.ParseFile(token.NewFileSet(), "", llm.StripCodeFence(sections["code"]), parser.PackageClauseOnly); err == nil {
+		pkg = file.Name.Name
+	}
+	
+	result := p.semanticModel.Ingest(section, pkg)
+	log.Printf("Ingested semantics section: %d entities added, %d merged, %d relations, %d skipped",
+		len(result.Added), len(result.Merged), result.Relations, len(result.Skipped))
+	if report, err := json.Marshal(result); err == nil {
+		sections["semantics_ingest"] = string(report)
+	}
+}
+
+// translateQuery asks the LLM to turn a question about the code into a
+// semantic model query
+func (p *Processor) translateQuery(ctx context.Context, question string) (string, error) {
+	return p.newCall(Options{}).translateQuery(ctx, question)
+}
+
+// translator returns the query translator of the call, or nil when the
+// call has no provider
+func (c *call) translator() semantics.QueryTranslator {
+	if c.provider == nil {
+		return nil
+	}
+	return c.translateQuery
+}
+
+// translateQuery asks the call's provider to turn a question about the
+// code into a semantic model query
+func (c *call) translateQuery(ctx context.Context, question string) (string, error) {
+	if c.provider == nil {
+		return "", errors.New("no LLM client configured")
+	}
+	
+	messages := []llm.ChatMessage{
+		{
+			Role: "system",
+			Content: `You translate questions about a Go code base into queries over its semantic model.
+` + semantics.QueryGrammar + `
+Respond with the query on a single line and nothing else.`,
+		},
+		{
+			Role:    "user",
+			Content: question,
+		},
+	}
+	
+	response, err := c.complete(ctx, messages, map[string]interface{}{"temperature": 0.1})
+	if err != nil {
+		return "", err
+	}
+	if len(response.Choices) == 0 {
+		return "", errors.New("no response from LLM API")
+	}
+	
+	query := llm.StripCodeFence(response.Choices[0].Message.Content)
+	if newline := strings.Index(query, "\n"); newline >= 0 {
+		query = query[:newline]
+	}
+	return strings.TrimSpace(query), nil
+}
+
 // handleModifyIntent handles modification intents
-func (p *Processor) handleModifyIntent(intent *Intent) (interface{}, error) {
+func (p *Processor) handleModifyIntent(ctx context.Context, c *call, intent *Intent) (interface{}, error) {
 	// Find the entities to modify
-	entities, _ := p.semanticModel.QueryByIntent(intent.Raw)
+	entities, _ := p.semanticModel.QueryByIntentWith(ctx, intent.Raw, c.translator())
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
 	if len(entities) == 0 {
 		return nil, errors.New("no entities found to modify")
 	}
 	
-	// Simplified for demonstration
-	return entities, nil
+	return p.blastRadius("Modifying", entities), nil
 }
 
 // handleDeleteIntent handles deletion intents
-func (p *Processor) handleDeleteIntent(intent *Intent) (interface{}, error) {
+func (p *Processor) handleDeleteIntent(ctx context.Context, c *call, intent *Intent) (interface{}, error) {
 	// Find the entities to delete
-	entities, _ := p.semanticModel.QueryByIntent(intent.Raw)
+	entities, _ := p.semanticModel.QueryByIntentWith(ctx, intent.Raw, c.translator())
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
 	if len(entities) == 0 {
 		return nil, errors.New("no entities found to delete")
 	}
 	
-	// Simplified for demonstration
-	return entities, nil
+	return p.blastRadius("Deleting", entities), nil
+}
+
+// ImpactResult is the result of modification and deletion intents: the
+// entities found for the intent, as returned before impact analysis, with
+// what depends on them
+type ImpactResult struct {
+	Entities []*semantics.Entity
+	Impact   []*semantics.Impact
+	// Report summarises the impact for display
+	Report string
+}
+
+// blastRadius reports what depends on the entities an intent is about to
+// change, so that the user sees the impact before any code is touched
+func (p *Processor) blastRadius(action string, entities []*semantics.Entity) *ImpactResult {
+	var impacts []*semantics.Impact
+	var report strings.Builder
+	files := map[string]bool{}
+	for _, entity := range entities {
+		impact, err := p.semanticModel.ImpactOf(entity.ID)
+		if err != nil {
+			continue
+		}
+		impacts = append(impacts, impact)
+		report.WriteString(impact.String())
+		for _, file := range impact.Files {
+			files[file] = true
+		}
+	}
+	
+	summary := fmt.Sprintf("// %s %d entities affects %d files\n\n", action, len(entities), len(files))
+	return &ImpactResult{
+		Entities: entities,
+		Impact:   impacts,
+		Report:   summary + report.String(),
+	}
 }
 
 // handleQueryIntent handles query intents
-func (p *Processor) handleQueryIntent(intent *Intent) (interface{}, error) {
+func (p *Processor) handleQueryIntent(ctx context.Context, c *call, intent *Intent) (interface{}, error) {
 	// Query the semantic model
-	entities, relations := p.semanticModel.QueryByIntent(intent.Raw)
+	entities, relations := p.semanticModel.QueryByIntentWith(ctx, intent.Raw, c.translator())
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
 	
 	// Format the results
 	// In a real system, this would be much more sophisticated
@@ -0,0 +1,213 @@
+package intent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"go/parser"
+	"go/token"
+	"regexp"
+	"strconv"
+	"testing"
+
+	"github.com/knoxai/AI-Native-Development-System/pkg/ast"
+	"github.com/knoxai/AI-Native-Development-System/pkg/llm"
+	"github.com/knoxai/AI-Native-Development-System/pkg/semantics"
+)
+
+const (
+	createIntent = `{"type": "Create", "target": "Function", "constraints": ["Must hash the password"], "parameters": {"name": "Login"}}`
+	loginCode    = "package auth\n\nfunc Login(user, password string) bool {\n\treturn hashPassword(password) != \"\"\n}\n"
+)
+
+// loginBundle is a code bundle for loginCode
+func loginBundle(t *testing.T) string {
+	t.Helper()
+
+	bundle, err := json.Marshal(map[string]interface{}{
+		"code": loginCode,
+		"semantics": map[string]interface{}{
+			"entities": []map[string]string{
+				{"id": "Login", "type": "Function", "name": "Login"},
+				{"id": "hashPassword", "type": "Function", "name": "hashPassword"},
+			},
+			"relations": []map[string]string{
+				{"type": "Calls", "from": "Login", "to": "hashPassword"},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(bundle)
+}
+
+// newTestProcessor creates a processor without a provider of its own
+func newTestProcessor() (*Processor, *semantics.Model) {
+	model := semantics.NewModel()
+	return NewProcessor(ast.NewProcessor(model), model), model
+}
+
+func TestProcessIntentCodeBundle(t *testing.T) {
+	bundle := loginBundle(t)
+	toolCall := llm.ToolCall{ID: "call-1", Type: "function"}
+	toolCall.Function.Name = "code_bundle"
+	toolCall.Function.Arguments = bundle
+
+	tests := []struct {
+		name string
+		rule llm.FakeRule
+	}{
+		{"content", llm.FakeRule{Format: "code_bundle", Response: bundle}},
+		{"fenced content", llm.FakeRule{Format: "code_bundle", Response: "```json\n" + bundle + "\n```"}},
+		{"tool call", llm.FakeRule{Format: "code_bundle", ToolCalls: []llm.ToolCall{toolCall}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := llm.NewFakeProvider(llm.FakeRule{Format: "intent", Response: createIntent}, tt.rule)
+			p, model := newTestProcessor()
+
+			intent, result, err := p.ProcessIntent(context.Background(), "create a login function", Options{Provider: fake, Model: "small"})
+			if err != nil {
+				t.Fatalf("ProcessIntent: %v", err)
+			}
+			if intent.Type != "Create" || intent.Target != "Function" || intent.Parameters["name"] != "Login" {
+				t.Errorf("unexpected intent %+v", intent)
+			}
+			sections, ok := result.(map[string]string)
+			if !ok {
+				t.Fatalf("result is %T, want sections", result)
+			}
+			if sections["code"] != loginCode {
+				t.Errorf("code = %q, want %q", sections["code"], loginCode)
+			}
+			if sections["ast_error"] != "" || sections["semantics_error"] != "" {
+				t.Errorf("bundle not processed: %q, %q", sections["ast_error"], sections["semantics_error"])
+			}
+			if _, ok := model.GetEntity("func:auth.Login"); !ok {
+				t.Error("semantics of the bundle not ingested")
+			}
+
+			// Both requests went to the model of the call
+			transcript := fake.Transcript()
+			if len(transcript) != 2 {
+				t.Fatalf("%d requests, want 2", len(transcript))
+			}
+			for _, exchange := range transcript {
+				if exchange.Model != "small" {
+					t.Errorf("request for model %q, want small", exchange.Model)
+				}
+			}
+			if p.GetLLMClient() != nil {
+				t.Error("the provider of the call was kept by the processor")
+			}
+		})
+	}
+}
+
+func TestProcessIntentErrors(t *testing.T) {
+	authErr := &llm.APIError{StatusCode: 401, Status: "401 Unauthorized", Kind: llm.ErrAuth}
+	rateErr := &llm.APIError{StatusCode: 429, Status: "429 Too Many Requests", Kind: llm.ErrRateLimited}
+	failure := errors.New("connection reset")
+
+	tests := []struct {
+		name  string
+		rules []llm.FakeRule
+		want  error
+	}{
+		{"parse rejected", []llm.FakeRule{{Format: "intent", Err: authErr}}, llm.ErrAuth},
+		{"parse rate limited", []llm.FakeRule{{Format: "intent", Err: rateErr}}, llm.ErrRateLimited},
+		{"generation failed", []llm.FakeRule{
+			{Format: "intent", Response: createIntent},
+			{Format: "code_bundle", Err: failure},
+		}, failure},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, _ := newTestProcessor()
+			_, _, err := p.ProcessIntent(context.Background(), "create a login function", Options{Provider: llm.NewFakeProvider(tt.rules...)})
+			if !errors.Is(err, tt.want) {
+				t.Errorf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+
+	// Other failures to parse the intent fall back to its keywords
+	p, _ := newTestProcessor()
+	fake := llm.NewFakeProvider(llm.FakeRule{Format: "code_bundle", Response: loginBundle(t)})
+	fake.FailNext(failure)
+	intent, _, err := p.ProcessIntent(context.Background(), "create a login function", Options{Provider: fake})
+	if err != nil || intent.Type != "Create" {
+		t.Errorf("got %+v, %v, want a Create intent parsed from the keywords", intent, err)
+	}
+}
+
+func TestProcessIntentContinuesTruncatedAnswer(t *testing.T) {
+	bundle := loginBundle(t)
+	head, tail := bundle[:len(bundle)/2], bundle[len(bundle)/2:]
+	toolCall := llm.ToolCall{ID: "call-1", Type: "function"}
+	toolCall.Function.Name = "code_bundle"
+	toolCall.Function.Arguments = head
+	continuation := regexp.MustCompile("cut off")
+
+	tests := []struct {
+		name      string
+		rule      llm.FakeRule
+		more      string
+		truncated bool
+	}{
+		{"content", llm.FakeRule{Format: "code_bundle", Response: head, FinishReason: llm.FinishLength}, tail, false},
+		{"tool call", llm.FakeRule{Format: "code_bundle", ToolCalls: []llm.ToolCall{toolCall}, FinishReason: llm.FinishLength}, tail, false},
+		{"still cut off", llm.FakeRule{Format: "code_bundle", Response: head, FinishReason: llm.FinishLength}, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			more := llm.FakeRule{Pattern: continuation, Response: tt.more}
+			if tt.truncated {
+				more.FinishReason = llm.FinishLength
+			}
+			fake := llm.NewFakeProvider(llm.FakeRule{Format: "intent", Response: createIntent}, tt.rule, more)
+			p, _ := newTestProcessor()
+
+			_, result, err := p.ProcessIntent(context.Background(), "create a login function", Options{Provider: fake})
+			if err != nil {
+				t.Fatalf("ProcessIntent: %v", err)
+			}
+			sections := result.(map[string]string)
+			if tt.truncated {
+				if sections["truncated"] == "" || sections["continuations"] != strconv.Itoa(maxContinuations) {
+					t.Errorf("truncation not reported: %q after %q continuations", sections["truncated"], sections["continuations"])
+				}
+				return
+			}
+			if sections["code"] != loginCode || sections["truncated"] != "" || sections["continuations"] != "1" {
+				t.Errorf("code %q, truncated %q, continuations %q", sections["code"], sections["truncated"], sections["continuations"])
+			}
+		})
+	}
+}
+
+func TestExecuteDeleteIntentReportsImpact(t *testing.T) {
+	model := semantics.NewModel()
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "auth.go", "package auth\nfunc Login() { hashPassword() }\nfunc hashPassword() {}\n", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := model.UpdateFromFile(fset, file); err != nil {
+		t.Fatal(err)
+	}
+	p := NewProcessor(ast.NewProcessor(model), model)
+
+	result, err := p.ExecuteIntent(&Intent{Type: "Delete", Target: "Function", Raw: "delete the hashPassword function"})
+	if err != nil {
+		t.Fatalf("ExecuteIntent: %v", err)
+	}
+	impact, ok := result.(*ImpactResult)
+	if !ok {
+		t.Fatalf("result is %T, want *ImpactResult", result)
+	}
+	if len(impact.Entities) == 0 || len(impact.Impact) != len(impact.Entities) || impact.Report == "" {
+		t.Errorf("unexpected result %+v", impact)
+	}
+}
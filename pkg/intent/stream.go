@@ -0,0 +1,199 @@
+package intent
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/knoxai/AI-Native-Development-System/pkg/llm"
+)
+
+// ExecuteIntentStream executes an intent like ExecuteIntentContext,
+// streaming the code of Create intents as the LLM writes it: onCode is
+// called with each new piece of code. The result is the same once the
+// answer is complete. Other intents, and Create intents without an LLM
+// provider, are executed without streaming. Code cut off at the token
+// limit is continued as in ExecuteIntentContext. Cancelling ctx stops
+// generation.
+func (p *Processor) ExecuteIntentStream(ctx context.Context, intent *Intent, opts Options, onCode func(code string)) (interface{}, error) {
+	c := p.newCall(opts)
+	if intent.Type != "Create" || c.provider == nil {
+		return p.executeIntent(ctx, c, intent)
+	}
+
+	stream := &codeStream{}
+	onToken := func(token string) {
+		if code := stream.feed(token); code != "" && onCode != nil {
+			onCode(code)
+		}
+	}
+	messages := codeMessages(intent)
+	response, err := c.stream(ctx, messages, onToken, map[string]interface{}{
+		"response_format": llm.JSONSchemaFormat("code_bundle", codeBundleSchema),
+	})
+	if err != nil {
+		log.Printf("Error streaming code generation from LLM API: %v", err)
+		return nil, err
+	}
+
+	// The continuations of a bundle cut off at the token limit are streamed
+	// as well; the result drops what they repeat, the streamed code does not
+	continuations, err := p.continueAnswer(ctx, c, messages, response, isCompleteCodeBundle, onToken)
+	if err != nil {
+		return nil, err
+	}
+
+	sections, err := p.codeSections(response)
+	if err != nil {
+		return nil, err
+	}
+	reportTruncation(sections, response, continuations)
+	return sections, nil
+}
+
+// codeKey is the key of the code string in a code bundle
+const codeKey = `"code"`
+
+// codeStream follows a code bundle as it is streamed and decodes the
+// "code" string of the bundle before the bundle is complete. An answer
+// that is not JSON is taken to be code, as codeSections does. Each token
+// only costs the work for the text it adds.
+type codeStream struct {
+	text strings.Builder
+	// raw is set when the answer is not a JSON object
+	raw, decided bool
+	// scanned is the offset in text up to which the key of the code
+	// string was searched for, and keyEnd the offset after the key once
+	// it is found, or -1 before
+	scanned, keyEnd int
+	// pos is the offset in text of the next character of the code string
+	// to decode, or -1 before the string is found
+	pos  int
+	done bool
+}
+
+// feed adds a token of the answer and returns the code it completes
+func (s *codeStream) feed(token string) string {
+	s.text.WriteString(token)
+	if !s.decided {
+		start := strings.TrimLeft(s.text.String(), " \t\r\n")
+		if strings.HasPrefix("```", start) {
+			return ""
+		}
+		if strings.HasPrefix(start, "```") {
+			newline := strings.Index(start, "\n")
+			if newline < 0 {
+				return ""
+			}
+			start = strings.TrimLeft(start[newline+1:], " \t\r\n")
+		}
+		if start == "" {
+			return ""
+		}
+		s.decided, s.raw, s.keyEnd, s.pos = true, start[0] != '{', -1, -1
+		if s.raw {
+			return s.text.String()
+		}
+	}
+	if s.raw {
+		return token
+	}
+	if s.done {
+		return ""
+	}
+
+	text := s.text.String()
+	if s.pos < 0 && !s.findCode(text) {
+		return ""
+	}
+
+	// Decode up to the end of the string or the last complete escape
+	var code strings.Builder
+	for s.pos < len(text) {
+		c := text[s.pos]
+		if c == '"' {
+			s.done = true
+			break
+		}
+		if c != '\\' {
+			// Multi-byte characters are only passed on once complete
+			if !utf8.FullRuneInString(text[s.pos:]) {
+				break
+			}
+			code.WriteByte(c)
+			s.pos++
+			continue
+		}
+		if s.pos+1 >= len(text) {
+			break
+		}
+		length := 2
+		if text[s.pos+1] == 'u' {
+			length = 6
+			if s.pos+length > len(text) {
+				break
+			}
+			// A surrogate pair is decoded together
+			if v, err := strconv.ParseUint(text[s.pos+2:s.pos+6], 16, 16); err == nil && v >= 0xD800 && v < 0xDC00 {
+				length = 12
+				if s.pos+length > len(text) {
+					break
+				}
+			}
+		}
+		var decoded string
+		if err := json.Unmarshal([]byte(`"`+text[s.pos:s.pos+length]+`"`), &decoded); err != nil {
+			decoded = text[s.pos : s.pos+length]
+		}
+		code.WriteString(decoded)
+		s.pos += length
+	}
+	return code.String()
+}
+
+// findCode looks for the start of the code string in the text added since
+// the last call and reports whether it was found. The key may be split
+// across tokens, so the search overlaps the end of the text seen before.
+func (s *codeStream) findCode(text string) bool {
+	if s.keyEnd < 0 {
+		from := max(0, s.scanned-len(codeKey)+1)
+		s.scanned = len(text)
+		key := strings.Index(text[from:], codeKey)
+		if key < 0 {
+			return false
+		}
+		s.keyEnd = from + key + len(codeKey)
+	}
+
+	// Skip the colon and the space around it
+	i := s.keyEnd
+	for i < len(text) && strings.IndexByte(" \t\r\n", text[i]) >= 0 {
+		i++
+	}
+	if i == len(text) {
+		return false
+	}
+	if text[i] != ':' {
+		// Not a key after all; search on behind it
+		s.keyEnd = -1
+		s.scanned = i
+		return s.findCode(text)
+	}
+	i++
+	for i < len(text) && strings.IndexByte(" \t\r\n", text[i]) >= 0 {
+		i++
+	}
+	if i == len(text) {
+		return false
+	}
+	if text[i] != '"' {
+		s.keyEnd = -1
+		s.scanned = i
+		return s.findCode(text)
+	}
+	s.pos = i + 1
+	return true
+}
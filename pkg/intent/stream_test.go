@@ -0,0 +1,47 @@
+package intent
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// streamCode feeds text to a codeStream in pieces of size bytes and
+// returns the code it passed on
+func streamCode(text string, size int) string {
+	stream := &codeStream{}
+	var code strings.Builder
+	for i := 0; i < len(text); i += size {
+		code.WriteString(stream.feed(text[i:min(i+size, len(text))]))
+	}
+	return code.String()
+}
+
+func TestCodeStream(t *testing.T) {
+	code := "package auth\n\n// Login signs in \"users\" — café 🎉\nfunc Login() {\n\treturn\n}\n"
+	encoded, err := json.Marshal(code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Non-ASCII characters are escaped as well as given literally
+	escaped := strings.NewReplacer("🎉", `\ud83c\udf89`, "é", `\u00e9`).Replace(string(encoded))
+
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{"bundle", `{"code": ` + string(encoded) + `, "semantics": {}}`, code},
+		{"escaped", `{"code":` + escaped + `}`, code},
+		{"fenced", "```json\n{\n  \"code\" :\n  " + string(encoded) + "\n}\n```", code},
+		{"key in a value", `{"note": "the \"code\" comes next", "code": ` + string(encoded) + `}`, code},
+		{"raw", "package auth\n\nfunc Login() {}\n", "package auth\n\nfunc Login() {}\n"},
+	}
+	for _, tt := range tests {
+		for _, size := range []int{1, 2, 3, 7, 64, len(tt.text)} {
+			if got := streamCode(tt.text, size); got != tt.want {
+				t.Errorf("%s in pieces of %d: got %q, want %q", tt.name, size, got, tt.want)
+			}
+		}
+	}
+}
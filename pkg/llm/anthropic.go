@@ -0,0 +1,508 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// AnthropicBaseURL is the base URL of Anthropic's API
+	AnthropicBaseURL = "https://api.anthropic.com/v1"
+
+	// AnthropicVersion is the version of the messages API the client speaks
+	AnthropicVersion = "2023-06-01"
+)
+
+// defaultAnthropicModel is the model used when none is configured
+const defaultAnthropicModel = "claude-sonnet-4-5"
+
+// Limits of Anthropic's models, which its API does not list
+const (
+	anthropicContextLength = 200000
+	anthropicMaxOutput     = 8192
+)
+
+// anthropicModel describes a model of Anthropic's API
+func anthropicModel(id, name string) Model {
+	model := Model{ID: id, Name: name, ContextLength: anthropicContextLength}
+	model.Architecture.InputModalities = []string{"text", "image"}
+	model.Architecture.OutputModalities = []string{"text"}
+	model.Architecture.Tokenizer = "Claude"
+	model.TopProvider.MaxCompletionTokens = anthropicMaxOutput
+	setKnownPrice(&model)
+	return model
+}
+
+// AnthropicClient is a client for Anthropic's messages API. It takes the
+// same messages and options as Client: system messages become the system
+// prompt, tools and tool calls are translated, and a JSON Schema response
+// format is obtained by forcing the model to call a tool taking the schema
+// as its input.
+type AnthropicClient struct {
+	APIKey       string
+	DefaultModel string
+	HTTPClient   *http.Client
+	// Retry controls retrying failed requests; DefaultRetryPolicy is used
+	// when it is nil
+	Retry *RetryPolicy
+	// BaseURL is the URL the API paths are relative to; AnthropicBaseURL is
+	// used when it is empty
+	BaseURL string
+}
+
+// NewAnthropicClient creates a client for Anthropic's API. An empty model
+// selects a default one.
+func NewAnthropicClient(apiKey, model string) *AnthropicClient {
+	if model == "" {
+		model = defaultAnthropicModel
+	}
+	return &AnthropicClient{
+		APIKey:       apiKey,
+		DefaultModel: model,
+		HTTPClient:   &http.Client{},
+	}
+}
+
+// SetModel sets the default model for the client
+func (c *AnthropicClient) SetModel(modelID string) {
+	c.DefaultModel = modelID
+}
+
+// url returns the URL of an API path
+func (c *AnthropicClient) url(path string) string {
+	if c.BaseURL == "" {
+		return AnthropicBaseURL + path
+	}
+	return strings.TrimSuffix(c.BaseURL, "/") + path
+}
+
+// send sends a request to the API, retrying as the retry policy allows
+func (c *AnthropicClient) send(ctx context.Context, method, url string, body []byte) (*http.Response, error) {
+	policy := DefaultRetryPolicy
+	if c.Retry != nil {
+		policy = *c.Retry
+	}
+	return policy.send(ctx, c.HTTPClient, method, url, body, func(header http.Header) {
+		header.Set("x-api-key", c.APIKey)
+		header.Set("anthropic-version", AnthropicVersion)
+	})
+}
+
+// anthropicRequest is a request to the messages API
+type anthropicRequest struct {
+	Model       string               `json:"model"`
+	System      string               `json:"system,omitempty"`
+	Messages    []anthropicMessage   `json:"messages"`
+	MaxTokens   int                  `json:"max_tokens"`
+	Temperature float64              `json:"temperature,omitempty"`
+	Tools       []anthropicTool      `json:"tools,omitempty"`
+	ToolChoice  *anthropicToolChoice `json:"tool_choice,omitempty"`
+	Stream      bool                 `json:"stream,omitempty"`
+
+	// formatTool is the tool standing for a JSON Schema response format
+	formatTool string
+}
+
+// anthropicMessage is a message made of content blocks
+type anthropicMessage struct {
+	Role    string           `json:"role"`
+	Content []anthropicBlock `json:"content"`
+}
+
+// anthropicBlock is a content block: text, a tool call or a tool result
+type anthropicBlock struct {
+	Type      string          `json:"type"`
+	Text      string          `json:"text,omitempty"`
+	ID        string          `json:"id,omitempty"`
+	Name      string          `json:"name,omitempty"`
+	Input     json.RawMessage `json:"input,omitempty"`
+	ToolUseID string          `json:"tool_use_id,omitempty"`
+	Content   string          `json:"content,omitempty"`
+}
+
+// anthropicTool is a tool definition of the messages API
+type anthropicTool struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	InputSchema json.RawMessage `json:"input_schema"`
+}
+
+// anthropicToolChoice tells the model whether and which tool to call
+type anthropicToolChoice struct {
+	Type string `json:"type"`
+	Name string `json:"name,omitempty"`
+}
+
+// anthropicResponse is a response of the messages API
+type anthropicResponse struct {
+	ID         string           `json:"id"`
+	Model      string           `json:"model"`
+	Role       string           `json:"role"`
+	Content    []anthropicBlock `json:"content"`
+	StopReason string           `json:"stop_reason"`
+	Usage      anthropicUsage   `json:"usage"`
+}
+
+// anthropicUsage is the token usage of a request to the messages API
+type anthropicUsage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+}
+
+// newAnthropicRequest translates the messages and options given to
+// GetChatCompletion into a request of the messages API, fitted to the
+// context window of the model
+func newAnthropicRequest(model string, messages []ChatMessage, options []any) (anthropicRequest, error) {
+	chat := newChatRequest(model, messages, options)
+	info := anthropicModel(chat.Model, "")
+	if err := fitContextWindow(&chat, &info); err != nil {
+		return anthropicRequest{}, err
+	}
+	req := anthropicRequest{
+		Model:       chat.Model,
+		MaxTokens:   chat.MaxTokens,
+		Temperature: chat.Temperature,
+	}
+
+	var system []string
+	for _, message := range chat.Messages {
+		var role string
+		var blocks []anthropicBlock
+		switch message.Role {
+		case "system":
+			system = append(system, message.Content)
+			continue
+		case "tool":
+			role = "user"
+			blocks = append(blocks, anthropicBlock{
+				Type:      "tool_result",
+				ToolUseID: message.ToolCallID,
+				Content:   message.Content,
+			})
+		default:
+			role = message.Role
+			if message.Content != "" {
+				blocks = append(blocks, anthropicBlock{Type: "text", Text: message.Content})
+			}
+			for _, call := range message.ToolCalls {
+				input := json.RawMessage(call.Function.Arguments)
+				if strings.TrimSpace(call.Function.Arguments) == "" {
+					input = json.RawMessage("{}")
+				}
+				blocks = append(blocks, anthropicBlock{
+					Type:  "tool_use",
+					ID:    call.ID,
+					Name:  call.Function.Name,
+					Input: input,
+				})
+			}
+		}
+		if len(blocks) == 0 {
+			continue
+		}
+
+		// Consecutive messages of a role, such as several tool results,
+		// make up one turn
+		if last := len(req.Messages) - 1; last >= 0 && req.Messages[last].Role == role {
+			req.Messages[last].Content = append(req.Messages[last].Content, blocks...)
+		} else {
+			req.Messages = append(req.Messages, anthropicMessage{Role: role, Content: blocks})
+		}
+	}
+
+	for _, tool := range chat.Tools {
+		req.Tools = append(req.Tools, anthropicTool{
+			Name:        tool.Function.Name,
+			Description: tool.Function.Description,
+			InputSchema: tool.Function.Parameters,
+		})
+	}
+	switch choice := chat.ToolChoice.(type) {
+	case string:
+		switch choice {
+		case "auto", "none":
+			req.ToolChoice = &anthropicToolChoice{Type: choice}
+		case "required":
+			req.ToolChoice = &anthropicToolChoice{Type: "any"}
+		}
+	case map[string]interface{}:
+		if function, ok := choice["function"].(map[string]string); ok {
+			req.ToolChoice = &anthropicToolChoice{Type: "tool", Name: function["name"]}
+		} else if function, ok := choice["function"].(map[string]interface{}); ok {
+			name, _ := function["name"].(string)
+			req.ToolChoice = &anthropicToolChoice{Type: "tool", Name: name}
+		}
+	}
+
+	if format := chat.ResponseFormat; format != nil {
+		switch {
+		case format.Type == ResponseFormatJSONSchema && format.JSONSchema != nil:
+			req.formatTool = format.JSONSchema.Name
+			req.Tools = append(req.Tools, anthropicTool{
+				Name:        format.JSONSchema.Name,
+				Description: "Give the answer as the input of this tool.",
+				InputSchema: format.JSONSchema.Schema,
+			})
+			req.ToolChoice = &anthropicToolChoice{Type: "tool", Name: format.JSONSchema.Name}
+		case format.Type == ResponseFormatJSONObject:
+			system = append(system, "Answer with a single JSON object and nothing else.")
+		}
+	}
+	req.System = strings.Join(system, "\n\n")
+
+	return req, nil
+}
+
+// chatResponse translates a response of the messages API. The input of the
+// response format tool becomes the content of the answer.
+func (r *anthropicResponse) chatResponse(formatTool string) *ChatCompletionResponse {
+	message := ChatMessage{Role: "assistant"}
+	var content strings.Builder
+	for _, block := range r.Content {
+		switch block.Type {
+		case "text":
+			content.WriteString(block.Text)
+		case "tool_use":
+			if block.Name == formatTool {
+				content.Write(block.Input)
+				continue
+			}
+			var call ToolCall
+			call.ID = block.ID
+			call.Type = "function"
+			call.Function.Name = block.Name
+			call.Function.Arguments = string(block.Input)
+			message.ToolCalls = append(message.ToolCalls, call)
+		}
+	}
+	message.Content = content.String()
+
+	// Stop reasons are translated to the finish reasons of chat completions;
+	// the response format tool is not a call to report
+	finishReason := FinishStop
+	switch r.StopReason {
+	case "max_tokens":
+		finishReason = FinishLength
+	case "tool_use":
+		if len(message.ToolCalls) > 0 {
+			finishReason = FinishToolCalls
+		}
+	case "":
+		finishReason = ""
+	}
+
+	return &ChatCompletionResponse{
+		ID:      r.ID,
+		Model:   r.Model,
+		Choices: []ChatChoice{{Message: message, FinishReason: finishReason}},
+		Usage: &Usage{
+			PromptTokens:     r.Usage.InputTokens,
+			CompletionTokens: r.Usage.OutputTokens,
+			TotalTokens:      r.Usage.InputTokens + r.Usage.OutputTokens,
+		},
+	}
+}
+
+// GetChatCompletionContext sends the messages to the messages API. It takes
+// the options of Client.GetChatCompletion.
+func (c *AnthropicClient) GetChatCompletionContext(ctx context.Context, messages []ChatMessage, options ...any) (*ChatCompletionResponse, error) {
+	req, err := newAnthropicRequest(c.DefaultModel, messages, options)
+	if err != nil {
+		return nil, err
+	}
+	reqBody, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling request: %w", err)
+	}
+
+	resp, err := c.send(ctx, "POST", c.url("/messages"), reqBody)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("error reading response body: %w", err)
+	}
+
+	var messagesResp anthropicResponse
+	if err := json.Unmarshal(body, &messagesResp); err != nil {
+		return nil, fmt.Errorf("error unmarshaling response: %w", err)
+	}
+	return messagesResp.chatResponse(req.formatTool), nil
+}
+
+// anthropicEvent is one server-sent event of a streamed message
+type anthropicEvent struct {
+	Type    string `json:"type"`
+	Index   int    `json:"index"`
+	Message *struct {
+		ID    string         `json:"id"`
+		Model string         `json:"model"`
+		Usage anthropicUsage `json:"usage"`
+	} `json:"message"`
+	ContentBlock *anthropicBlock `json:"content_block"`
+	Delta        *struct {
+		Type        string `json:"type"`
+		Text        string `json:"text"`
+		PartialJSON string `json:"partial_json"`
+		StopReason  string `json:"stop_reason"`
+	} `json:"delta"`
+	// Usage comes with message_delta, counting the output so far
+	Usage *anthropicUsage `json:"usage"`
+	Error *struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// StreamChatCompletion sends the messages to the messages API and calls
+// onToken with each piece of the answer as it is produced. The input of the
+// response format tool is streamed as the answer.
+func (c *AnthropicClient) StreamChatCompletion(ctx context.Context, messages []ChatMessage, onToken func(token string), options ...any) (*ChatCompletionResponse, error) {
+	req, err := newAnthropicRequest(c.DefaultModel, messages, options)
+	if err != nil {
+		return nil, err
+	}
+	req.Stream = true
+
+	reqBody, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling request: %w", err)
+	}
+
+	resp, err := c.send(ctx, "POST", c.url("/messages"), reqBody)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	// The blocks are rebuilt from their deltas, by index
+	var message anthropicResponse
+	inputs := map[int]*strings.Builder{}
+	err = readEvents(resp.Body, func(data string) error {
+		var event anthropicEvent
+		if err := json.Unmarshal([]byte(data), &event); err != nil {
+			return fmt.Errorf("error unmarshaling stream event: %w", err)
+		}
+
+		switch event.Type {
+		case "error":
+			if event.Error != nil {
+				return fmt.Errorf("API error in stream: %s - %s", event.Error.Type, event.Error.Message)
+			}
+			return fmt.Errorf("API error in stream: %s", data)
+		case "message_start":
+			if event.Message != nil {
+				message.ID = event.Message.ID
+				message.Model = event.Message.Model
+				message.Usage = event.Message.Usage
+			}
+		case "content_block_start":
+			if event.ContentBlock != nil {
+				for len(message.Content) <= event.Index {
+					message.Content = append(message.Content, anthropicBlock{})
+				}
+				block := *event.ContentBlock
+				block.Input = nil
+				message.Content[event.Index] = block
+				inputs[event.Index] = &strings.Builder{}
+			}
+		case "content_block_delta":
+			if event.Delta == nil || event.Index >= len(message.Content) {
+				return nil
+			}
+			block := &message.Content[event.Index]
+			switch event.Delta.Type {
+			case "text_delta":
+				block.Text += event.Delta.Text
+				if onToken != nil && event.Delta.Text != "" {
+					onToken(event.Delta.Text)
+				}
+			case "input_json_delta":
+				inputs[event.Index].WriteString(event.Delta.PartialJSON)
+				if onToken != nil && block.Name == req.formatTool && event.Delta.PartialJSON != "" {
+					onToken(event.Delta.PartialJSON)
+				}
+			}
+		case "message_delta":
+			if event.Delta != nil && event.Delta.StopReason != "" {
+				message.StopReason = event.Delta.StopReason
+			}
+			if event.Usage != nil {
+				message.Usage.OutputTokens = event.Usage.OutputTokens
+			}
+		}
+		return nil
+	})
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range message.Content {
+		if message.Content[i].Type != "tool_use" {
+			continue
+		}
+		input := inputs[i].String()
+		if strings.TrimSpace(input) == "" {
+			input = "{}"
+		}
+		message.Content[i].Input = json.RawMessage(input)
+	}
+	return message.chatResponse(req.formatTool), nil
+}
+
+// GetAvailableModels lists the models of Anthropic's API
+func (c *AnthropicClient) GetAvailableModels() ([]Model, error) {
+	return c.GetAvailableModelsContext(context.Background())
+}
+
+// GetAvailableModelsContext is GetAvailableModels with a context
+func (c *AnthropicClient) GetAvailableModelsContext(ctx context.Context) ([]Model, error) {
+	resp, err := c.send(ctx, "GET", c.url("/models?limit=1000"), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("error reading response body: %w", err)
+	}
+
+	var modelsResp struct {
+		Data []struct {
+			ID          string `json:"id"`
+			DisplayName string `json:"display_name"`
+			CreatedAt   string `json:"created_at"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(body, &modelsResp); err != nil {
+		return nil, fmt.Errorf("error unmarshaling response: %w", err)
+	}
+
+	models := make([]Model, 0, len(modelsResp.Data))
+	for _, data := range modelsResp.Data {
+		model := anthropicModel(data.ID, data.DisplayName)
+		if created, err := time.Parse(time.RFC3339, data.CreatedAt); err == nil {
+			model.Created = created.Unix()
+		}
+		models = append(models, model)
+	}
+	return models, nil
+}
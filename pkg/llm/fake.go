@@ -0,0 +1,426 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrNoFakeResponse is returned by a FakeProvider when neither its cassette
+// nor its rules answer a request
+var ErrNoFakeResponse = errors.New("no fake response for the request")
+
+// FakeRule answers the requests of a FakeProvider that it matches
+type FakeRule struct {
+	// Pattern is matched against the last user message; nil matches any
+	Pattern *regexp.Regexp
+	// Format, when set, only matches requests with a JSON Schema response
+	// format of this name
+	Format string
+	// Times is how many requests the rule answers; zero is unlimited
+	Times int
+
+	// Response is the content of the answer, unless Respond is set
+	Response string
+	// ToolCalls are the tool calls of the answer
+	ToolCalls []ToolCall
+	// FinishReason is the finish reason of the answer, FinishStop or
+	// FinishToolCalls when empty. FinishLength fakes an answer cut off at
+	// max_tokens.
+	FinishReason string
+	// Respond computes the content of the answer from the request
+	Respond func(req ChatCompletionRequest) (string, error)
+	// Err fails the request instead of answering it
+	Err error
+
+	used int
+}
+
+// matches tells whether the rule answers req
+func (r *FakeRule) matches(req ChatCompletionRequest) bool {
+	if r.Times > 0 && r.used >= r.Times {
+		return false
+	}
+	if r.Format != "" {
+		format := req.ResponseFormat
+		if format == nil || format.JSONSchema == nil || format.JSONSchema.Name != r.Format {
+			return false
+		}
+	}
+	return r.Pattern == nil || r.Pattern.MatchString(lastUserMessage(req.Messages))
+}
+
+// lastUserMessage returns the content of the last message of the user
+func lastUserMessage(messages []ChatMessage) string {
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Role == "user" {
+			return messages[i].Content
+		}
+	}
+	return ""
+}
+
+// Exchange is a request and its answer, as recorded in a transcript. A list
+// of exchanges saved with SaveCassette can be replayed by a FakeProvider.
+type Exchange struct {
+	Model    string                  `json:"model,omitempty"`
+	Messages []ChatMessage           `json:"messages"`
+	Response *ChatCompletionResponse `json:"response,omitempty"`
+	// Error is the message of the error the request failed with, and
+	// StatusCode its status when it was an APIError
+	Error      string `json:"error,omitempty"`
+	StatusCode int    `json:"status_code,omitempty"`
+}
+
+// newExchange records a request and its outcome
+func newExchange(model string, messages []ChatMessage, resp *ChatCompletionResponse, err error) Exchange {
+	exchange := Exchange{
+		Model:    model,
+		Messages: append([]ChatMessage(nil), messages...),
+		Response: resp,
+	}
+	var apiErr *APIError
+	switch {
+	case errors.As(err, &apiErr):
+		exchange.Error = apiErr.Body
+		exchange.StatusCode = apiErr.StatusCode
+	case err != nil:
+		exchange.Error = err.Error()
+	}
+	return exchange
+}
+
+// replay returns the recorded outcome of the exchange
+func (e *Exchange) replay() (*ChatCompletionResponse, error) {
+	switch {
+	case e.StatusCode != 0:
+		resp := &http.Response{
+			StatusCode: e.StatusCode,
+			Status:     fmt.Sprintf("%d %s", e.StatusCode, http.StatusText(e.StatusCode)),
+			Header:     http.Header{},
+		}
+		return nil, newAPIError(resp, []byte(e.Error))
+	case e.Error != "":
+		return nil, errors.New(e.Error)
+	case e.Response == nil:
+		return nil, errors.New("no response from LLM API")
+	}
+	return e.Response, nil
+}
+
+// SaveCassette writes exchanges to a JSON file
+func SaveCassette(path string, exchanges []Exchange) error {
+	data, err := json.MarshalIndent(exchanges, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding cassette: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("error writing cassette: %w", err)
+	}
+	return nil
+}
+
+// LoadCassette reads exchanges written by SaveCassette
+func LoadCassette(path string) ([]Exchange, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading cassette: %w", err)
+	}
+	var exchanges []Exchange
+	if err := json.Unmarshal(data, &exchanges); err != nil {
+		return nil, fmt.Errorf("error decoding cassette %s: %w", path, err)
+	}
+	return exchanges, nil
+}
+
+// FakeProvider is a Provider answering from a script instead of a language
+// model, for tests and demos without network access. A request is answered
+// by the first error queued with FailNext, else by the first unused exchange
+// of the cassette with the same messages, else by the first matching rule.
+// Every request is recorded in the transcript.
+type FakeProvider struct {
+	DefaultModel string
+	// Models are listed by GetAvailableModels; a model named after
+	// DefaultModel is listed when there are none
+	Models []Model
+	// Latency delays every answer
+	Latency time.Duration
+	// TokenDelay delays every piece of a streamed answer
+	TokenDelay time.Duration
+
+	mu         sync.Mutex
+	rules      []*FakeRule
+	cassette   []Exchange
+	replayed   []bool
+	failures   []error
+	transcript []Exchange
+}
+
+// NewFakeProvider creates a fake provider answering with the rules
+func NewFakeProvider(rules ...FakeRule) *FakeProvider {
+	f := &FakeProvider{DefaultModel: "fake"}
+	f.AddRules(rules...)
+	return f
+}
+
+// NewReplayProvider creates a fake provider replaying a cassette
+func NewReplayProvider(path string) (*FakeProvider, error) {
+	exchanges, err := LoadCassette(path)
+	if err != nil {
+		return nil, err
+	}
+	f := NewFakeProvider()
+	f.Replay(exchanges)
+	return f, nil
+}
+
+// AddRules adds rules, tried after the existing ones
+func (f *FakeProvider) AddRules(rules ...FakeRule) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for i := range rules {
+		rule := rules[i]
+		f.rules = append(f.rules, &rule)
+	}
+}
+
+// Replay adds exchanges to the cassette. Each answers one request with the
+// same messages, whatever the model.
+func (f *FakeProvider) Replay(exchanges []Exchange) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.cassette = append(f.cassette, exchanges...)
+	f.replayed = append(f.replayed, make([]bool, len(exchanges))...)
+}
+
+// FailNext makes the next requests fail with errs, one each
+func (f *FakeProvider) FailNext(errs ...error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.failures = append(f.failures, errs...)
+}
+
+// Transcript returns the requests answered so far
+func (f *FakeProvider) Transcript() []Exchange {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([]Exchange(nil), f.transcript...)
+}
+
+// SetModel sets the model used when the options do not name one
+func (f *FakeProvider) SetModel(modelID string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.DefaultModel = modelID
+}
+
+// GetAvailableModels lists the models of the provider
+func (f *FakeProvider) GetAvailableModels() ([]Model, error) {
+	return f.GetAvailableModelsContext(context.Background())
+}
+
+// GetAvailableModelsContext is GetAvailableModels with a context
+func (f *FakeProvider) GetAvailableModelsContext(ctx context.Context) ([]Model, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if len(f.Models) > 0 {
+		return append([]Model(nil), f.Models...), nil
+	}
+	return []Model{{ID: f.DefaultModel, Name: "Fake " + f.DefaultModel}}, nil
+}
+
+// GetChatCompletionContext answers the messages from the script
+func (f *FakeProvider) GetChatCompletionContext(ctx context.Context, messages []ChatMessage, options ...any) (*ChatCompletionResponse, error) {
+	if err := sleepContext(ctx, f.Latency); err != nil {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	req := newChatRequest(f.DefaultModel, messages, options)
+	resp, err := f.answerLocked(req)
+	f.transcript = append(f.transcript, newExchange(req.Model, messages, resp, err))
+	f.mu.Unlock()
+
+	return resp, err
+}
+
+// StreamChatCompletion answers the messages from the script, passing the
+// answer to onToken a word at a time
+func (f *FakeProvider) StreamChatCompletion(ctx context.Context, messages []ChatMessage, onToken func(token string), options ...any) (*ChatCompletionResponse, error) {
+	resp, err := f.GetChatCompletionContext(ctx, messages, options...)
+	if err != nil {
+		return nil, err
+	}
+	if onToken == nil || len(resp.Choices) == 0 {
+		return resp, nil
+	}
+
+	for _, token := range strings.SplitAfter(resp.Choices[0].Message.Content, " ") {
+		if token == "" {
+			continue
+		}
+		if err := sleepContext(ctx, f.TokenDelay); err != nil {
+			return nil, err
+		}
+		onToken(token)
+	}
+	return resp, nil
+}
+
+// answerLocked answers a request from the failures, the cassette and the
+// rules, in that order
+func (f *FakeProvider) answerLocked(req ChatCompletionRequest) (*ChatCompletionResponse, error) {
+	if len(f.failures) > 0 {
+		err := f.failures[0]
+		f.failures = f.failures[1:]
+		return nil, err
+	}
+
+	if len(f.cassette) > 0 {
+		key, _ := json.Marshal(req.Messages)
+		for i := range f.cassette {
+			if f.replayed[i] {
+				continue
+			}
+			if recorded, _ := json.Marshal(f.cassette[i].Messages); string(recorded) == string(key) {
+				f.replayed[i] = true
+				return f.cassette[i].replay()
+			}
+		}
+	}
+
+	for _, rule := range f.rules {
+		if !rule.matches(req) {
+			continue
+		}
+		rule.used++
+		if rule.Err != nil {
+			return nil, rule.Err
+		}
+		content := rule.Response
+		if rule.Respond != nil {
+			var err error
+			if content, err = rule.Respond(req); err != nil {
+				return nil, err
+			}
+		}
+		usage := &Usage{
+			PromptTokens:     estimateRequestTokens(&req, ""),
+			CompletionTokens: EstimateTokens(content, ""),
+		}
+		usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+		finishReason := rule.FinishReason
+		if finishReason == "" {
+			finishReason = FinishStop
+			if len(rule.ToolCalls) > 0 {
+				finishReason = FinishToolCalls
+			}
+		}
+		return &ChatCompletionResponse{
+			ID:    fmt.Sprintf("fake-%d", len(f.transcript)+1),
+			Model: req.Model,
+			Choices: []ChatChoice{{Message: ChatMessage{
+				Role:      "assistant",
+				Content:   content,
+				ToolCalls: rule.ToolCalls,
+			}, FinishReason: finishReason}},
+			Usage: usage,
+		}, nil
+	}
+
+	return nil, fmt.Errorf("%w: %.80q", ErrNoFakeResponse, lastUserMessage(req.Messages))
+}
+
+// sleepContext waits for d, or until ctx is done
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// Recorder is a Provider recording the requests made to another one, so
+// that real sessions can be saved as cassettes and replayed offline
+type Recorder struct {
+	Provider Provider
+
+	mu         sync.Mutex
+	transcript []Exchange
+}
+
+// NewRecorder creates a recorder of the requests made to provider
+func NewRecorder(provider Provider) *Recorder {
+	return &Recorder{Provider: provider}
+}
+
+// Transcript returns the requests made so far
+func (r *Recorder) Transcript() []Exchange {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]Exchange(nil), r.transcript...)
+}
+
+// SaveCassette writes the requests made so far to a JSON file
+func (r *Recorder) SaveCassette(path string) error {
+	return SaveCassette(path, r.Transcript())
+}
+
+// record adds a request to the transcript, unless it was cancelled
+func (r *Recorder) record(ctx context.Context, messages []ChatMessage, options []any, resp *ChatCompletionResponse, err error) {
+	if ctx.Err() != nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	model := newChatRequest("", messages, options).Model
+	r.transcript = append(r.transcript, newExchange(model, messages, resp, err))
+}
+
+// GetChatCompletionContext forwards the request and records it
+func (r *Recorder) GetChatCompletionContext(ctx context.Context, messages []ChatMessage, options ...any) (*ChatCompletionResponse, error) {
+	resp, err := r.Provider.GetChatCompletionContext(ctx, messages, options...)
+	r.record(ctx, messages, options, resp, err)
+	return resp, err
+}
+
+// StreamChatCompletion forwards the request and records it
+func (r *Recorder) StreamChatCompletion(ctx context.Context, messages []ChatMessage, onToken func(token string), options ...any) (*ChatCompletionResponse, error) {
+	resp, err := r.Provider.StreamChatCompletion(ctx, messages, onToken, options...)
+	r.record(ctx, messages, options, resp, err)
+	return resp, err
+}
+
+// GetAvailableModelsContext lists the models of the recorded provider
+func (r *Recorder) GetAvailableModelsContext(ctx context.Context) ([]Model, error) {
+	return r.Provider.GetAvailableModelsContext(ctx)
+}
+
+// SetModel sets the model of the recorded provider
+func (r *Recorder) SetModel(modelID string) {
+	r.Provider.SetModel(modelID)
+}
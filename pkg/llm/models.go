@@ -0,0 +1,77 @@
+package llm
+
+import (
+	"context"
+	"log"
+	"sync"
+)
+
+// modelCache keeps the models listed by an API, by ID. The list is
+// fetched once it succeeds: a failed listing is not kept, so the next
+// lookup tries again.
+type modelCache struct {
+	mu     sync.Mutex
+	models map[string]Model
+	// listing is the listing in progress, or nil
+	listing *modelListing
+}
+
+// modelListing is a listing of models that lookups wait for
+type modelListing struct {
+	done chan struct{}
+	err  error
+}
+
+// lookup returns the description of a model, listing the models with list
+// until a listing succeeds, or nil when the list does not describe it. The
+// models are listed without holding the lock; concurrent lookups wait for
+// the same listing, or until ctx is done.
+func (c *modelCache) lookup(ctx context.Context, modelID string, list func(ctx context.Context) ([]Model, error)) *Model {
+	for {
+		c.mu.Lock()
+		if c.models != nil {
+			model, ok := c.models[modelID]
+			c.mu.Unlock()
+			if !ok {
+				return nil
+			}
+			return &model
+		}
+
+		if listing := c.listing; listing != nil {
+			c.mu.Unlock()
+			select {
+			case <-listing.done:
+			case <-ctx.Done():
+				return nil
+			}
+			// A listing abandoned by its own caller is tried again
+			if listing.err != nil && listing.err != context.Canceled && listing.err != context.DeadlineExceeded {
+				return nil
+			}
+			continue
+		}
+
+		listing := &modelListing{done: make(chan struct{})}
+		c.listing = listing
+		c.mu.Unlock()
+
+		models, err := list(ctx)
+		c.mu.Lock()
+		c.listing = nil
+		if err == nil {
+			c.models = make(map[string]Model, len(models))
+			for _, model := range models {
+				c.models[model.ID] = model
+			}
+		}
+		listing.err = err
+		c.mu.Unlock()
+		close(listing.done)
+
+		if err != nil {
+			log.Printf("Could not list models: %v", err)
+			return nil
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestModelCacheRetriesFailures(t *testing.T) {
+	var cache modelCache
+	var calls int
+	list := func(context.Context) ([]Model, error) {
+		calls++
+		if calls == 1 {
+			return nil, errors.New("connection refused")
+		}
+		return []Model{{ID: "a"}}, nil
+	}
+
+	if model := cache.lookup(context.Background(), "a", list); model != nil {
+		t.Fatal("model found although the listing failed")
+	}
+	if model := cache.lookup(context.Background(), "a", list); model == nil || model.ID != "a" {
+		t.Fatalf("got %v, want the model once the listing succeeds", model)
+	}
+	if model := cache.lookup(context.Background(), "b", list); model != nil {
+		t.Errorf("got %v for a model that is not listed", model)
+	}
+	if calls != 2 {
+		t.Errorf("models listed %d times, want 2", calls)
+	}
+}
+
+func TestModelCacheSharesListing(t *testing.T) {
+	var cache modelCache
+	var calls atomic.Int32
+	started, release := make(chan struct{}, 5), make(chan struct{})
+	list := func(context.Context) ([]Model, error) {
+		calls.Add(1)
+		started <- struct{}{}
+		<-release
+		return []Model{{ID: "a"}}, nil
+	}
+
+	var wg sync.WaitGroup
+	found := make(chan bool, 5)
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			found <- cache.lookup(context.Background(), "a", list) != nil
+		}()
+	}
+
+	// A lookup that gives up does not wait for the listing, nor hold up
+	// the others
+	<-started
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if model := cache.lookup(ctx, "a", list); model != nil {
+		t.Errorf("got %v from a cancelled lookup", model)
+	}
+
+	close(release)
+	wg.Wait()
+	close(found)
+	for ok := range found {
+		if !ok {
+			t.Error("lookup did not find the listed model")
+		}
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("models listed %d times, want 1", n)
+	}
+}
@@ -1,31 +1,49 @@
 package llm
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
+	"strings"
 )
 
 const (
+	// OpenRouterBaseURL is the base URL of OpenRouter's OpenAI-compatible API
+	OpenRouterBaseURL = "https://openrouter.ai/api/v1"
+	
 	// OpenRouterCompletionURL is the endpoint for OpenRouter's completion API
-	OpenRouterCompletionURL = "https://openrouter.co/v1/completions"
+	OpenRouterCompletionURL = OpenRouterBaseURL + "/completions"
 	
 	// OpenRouterChatCompletionURL is the endpoint for OpenRouter's chat completion API
-	OpenRouterChatCompletionURL = "https://openrouter.co/v1/chat/completions"
+	OpenRouterChatCompletionURL = OpenRouterBaseURL + "/chat/completions"
 	
 	// OpenRouterModelsURL is the endpoint for retrieving available models
-	OpenRouterModelsURL = "https://openrouter.co/v1/models"
+	OpenRouterModelsURL = OpenRouterBaseURL + "/models"
 )
 
-// Client is a client for the OpenRouter API
+// defaultOpenRouterModel is the model used when none is configured
+const defaultOpenRouterModel = "openai/gpt-3.5-turbo"
+
+// Client is a client for the OpenRouter API, or for any other API
+// compatible with OpenAI's, such as those of vLLM, the llama.cpp server,
+// LM Studio and Ollama, when BaseURL is set
 type Client struct {
 	APIKey       string
 	DefaultModel string
 	HTTPClient   *http.Client
+	// Retry controls retrying failed requests; DefaultRetryPolicy is used
+	// when it is nil
+	Retry *RetryPolicy
+	// BaseURL is the URL the API paths are relative to, such as
+	// "http://localhost:8000/v1"; OpenRouterBaseURL is used when it is empty
+	BaseURL string
+	
+	// models caches the models of the API for budgeting requests
+	models modelCache
 }
 
 // NewClient creates a new OpenRouter client
@@ -40,7 +58,7 @@ func NewClient() (*Client, error) {
 	defaultModel := os.Getenv("OPENROUTER_DEFAULT_MODEL")
 	if defaultModel == "" {
 		// Use a default model, but this will be overridden by client selection
-		defaultModel = "openai/gpt-3.5-turbo"
+		defaultModel = defaultOpenRouterModel
 	}
 	
 	return &Client{
@@ -50,6 +68,25 @@ func NewClient() (*Client, error) {
 	}, nil
 }
 
+// NewOpenAICompatibleClient creates a client for an OpenAI-compatible API
+// at baseURL. Local servers usually need no API key.
+func NewOpenAICompatibleClient(baseURL, apiKey, model string) *Client {
+	return &Client{
+		APIKey:       apiKey,
+		DefaultModel: model,
+		HTTPClient:   &http.Client{},
+		BaseURL:      strings.TrimSuffix(baseURL, "/"),
+	}
+}
+
+// url returns the URL of an API path
+func (c *Client) url(path string) string {
+	if c.BaseURL == "" {
+		return OpenRouterBaseURL + path
+	}
+	return strings.TrimSuffix(c.BaseURL, "/") + path
+}
+
 // Model represents an AI model available in OpenRouter
 type Model struct {
 	ID          string `json:"id"`
@@ -64,7 +101,9 @@ type Model struct {
 	} `json:"architecture"`
 	
 	TopProvider struct {
-		IsModerated bool `json:"is_moderated"`
+		IsModerated         bool `json:"is_moderated"`
+		ContextLength       int  `json:"context_length"`
+		MaxCompletionTokens int  `json:"max_completion_tokens"`
 	} `json:"top_provider"`
 	
 	Pricing struct {
@@ -88,34 +127,27 @@ type ModelsResponse struct {
 
 // GetAvailableModels retrieves the list of available models from OpenRouter
 func (c *Client) GetAvailableModels() ([]Model, error) {
-	// Create HTTP request
-	req, err := http.NewRequest("GET", OpenRouterModelsURL, nil)
-	if err != nil {
-		return nil, fmt.Errorf("error creating request: %w", err)
-	}
-	
-	// Add headers
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+c.APIKey)
-	
+	return c.GetAvailableModelsContext(context.Background())
+}
+
+// GetAvailableModelsContext is GetAvailableModels with a context
+func (c *Client) GetAvailableModelsContext(ctx context.Context) ([]Model, error) {
 	// Send request
-	resp, err := c.HTTPClient.Do(req)
+	resp, err := c.send(ctx, "GET", c.url("/models"), nil)
 	if err != nil {
-		return nil, fmt.Errorf("error sending request: %w", err)
+		return nil, err
 	}
 	defer resp.Body.Close()
 	
 	// Read response body
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
 		return nil, fmt.Errorf("error reading response body: %w", err)
 	}
 	
-	// Check for error status code
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("API error: %s - %s", resp.Status, string(body))
-	}
-	
 	// Parse response
 	var modelsResp ModelsResponse
 	if err := json.Unmarshal(body, &modelsResp); err != nil {
@@ -125,6 +157,12 @@ func (c *Client) GetAvailableModels() ([]Model, error) {
 	return modelsResp.Data, nil
 }
 
+// modelInfo returns the description of a model, listing the models of the
+// API until a listing succeeds, or nil when the API does not describe it
+func (c *Client) modelInfo(ctx context.Context, modelID string) *Model {
+	return c.models.lookup(ctx, modelID, c.GetAvailableModelsContext)
+}
+
 // SetModel sets the default model for the client
 func (c *Client) SetModel(modelID string) {
 	c.DefaultModel = modelID
@@ -179,20 +217,10 @@ func (c *Client) GetCompletion(prompt string, options ...any) (*CompletionRespon
 		return nil, fmt.Errorf("error marshaling request: %w", err)
 	}
 	
-	// Create HTTP request
-	httpReq, err := http.NewRequest("POST", OpenRouterCompletionURL, bytes.NewBuffer(reqBody))
-	if err != nil {
-		return nil, fmt.Errorf("error creating request: %w", err)
-	}
-	
-	// Add headers
-	httpReq.Header.Set("Content-Type", "application/json")
-	httpReq.Header.Set("Authorization", "Bearer "+c.APIKey)
-	
 	// Send request
-	resp, err := c.HTTPClient.Do(httpReq)
+	resp, err := c.send(context.Background(), "POST", c.url("/completions"), reqBody)
 	if err != nil {
-		return nil, fmt.Errorf("error sending request: %w", err)
+		return nil, err
 	}
 	defer resp.Body.Close()
 	
@@ -202,11 +230,6 @@ func (c *Client) GetCompletion(prompt string, options ...any) (*CompletionRespon
 		return nil, fmt.Errorf("error reading response body: %w", err)
 	}
 	
-	// Check for error status code
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("API error: %s - %s", resp.Status, string(body))
-	}
-	
 	// Parse response
 	var completionResp CompletionResponse
 	if err := json.Unmarshal(body, &completionResp); err != nil {
@@ -216,54 +239,83 @@ func (c *Client) GetCompletion(prompt string, options ...any) (*CompletionRespon
 	return &completionResp, nil
 }
 
-// ChatMessage represents a message in a chat completion request
+// ChatMessage represents a message in a chat completion request. Messages
+// of the assistant may call tools, whose results are sent back in messages
+// with the "tool" role and the ID of the call.
 type ChatMessage struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
+	Role       string     `json:"role"`
+	Content    string     `json:"content"`
+	ToolCalls  []ToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string     `json:"tool_call_id,omitempty"`
 }
 
 // ChatCompletionRequest represents a request to the chat completion API
 type ChatCompletionRequest struct {
-	Model       string        `json:"model"`
-	Messages    []ChatMessage `json:"messages"`
-	MaxTokens   int           `json:"max_tokens,omitempty"`
-	Temperature float64       `json:"temperature,omitempty"`
+	Model          string          `json:"model"`
+	Messages       []ChatMessage   `json:"messages"`
+	MaxTokens      int             `json:"max_tokens,omitempty"`
+	Temperature    float64         `json:"temperature,omitempty"`
+	ResponseFormat *ResponseFormat `json:"response_format,omitempty"`
+	Tools          []Tool          `json:"tools,omitempty"`
+	ToolChoice     interface{}     `json:"tool_choice,omitempty"`
+	Stream         bool            `json:"stream,omitempty"`
+	StreamOptions  *StreamOptions  `json:"stream_options,omitempty"`
+}
+
+// StreamOptions controls a streamed chat completion
+type StreamOptions struct {
+	// IncludeUsage asks for the token usage in the last event
+	IncludeUsage bool `json:"include_usage"`
 }
 
 // ChatCompletionResponse represents a response from the chat completion API
 type ChatCompletionResponse struct {
-	ID      string `json:"id"`
-	Choices []struct {
-		Message struct {
-			Role    string `json:"role"`
-			Content string `json:"content"`
-		} `json:"message"`
-	} `json:"choices"`
+	ID      string       `json:"id"`
+	Model   string       `json:"model,omitempty"`
+	Choices []ChatChoice `json:"choices"`
+	Usage   *Usage       `json:"usage,omitempty"`
+}
+
+// ChatChoice is one of the answers in a chat completion response
+type ChatChoice struct {
+	Message ChatMessage `json:"message"`
+	// FinishReason tells why the model stopped, such as FinishLength when
+	// the answer was cut off at max_tokens
+	FinishReason string `json:"finish_reason,omitempty"`
+}
+
+// Finish reasons of a chat choice
+const (
+	FinishStop      = "stop"
+	FinishLength    = "length"
+	FinishToolCalls = "tool_calls"
+)
+
+// Truncated tells whether the answer was cut off at max_tokens rather than
+// finished by the model
+func (r *ChatCompletionResponse) Truncated() bool {
+	return len(r.Choices) > 0 && r.Choices[0].FinishReason == FinishLength
 }
 
-// GetChatCompletion sends a chat completion request to OpenRouter
+// GetChatCompletion sends a chat completion request to OpenRouter. The
+// options map may set "model", "max_tokens", "temperature",
+// "response_format" (a *ResponseFormat), "tools" (a []Tool) and
+// "tool_choice". Without "max_tokens" the answer may take what the prompt
+// leaves of the context window of the model. Conversations too long for
+// the window lose their oldest messages, and an error wrapping
+// ErrContextTooLong is returned when the prompt still does not fit.
 func (c *Client) GetChatCompletion(messages []ChatMessage, options ...any) (*ChatCompletionResponse, error) {
-	req := ChatCompletionRequest{
-		Model:       c.DefaultModel,
-		Messages:    messages,
-		MaxTokens:   1000,
-		Temperature: 0.7,
-	}
-	
-	// Process optional parameters
-	for _, option := range options {
-		switch opt := option.(type) {
-		case map[string]interface{}:
-			if model, ok := opt["model"].(string); ok {
-				req.Model = model
-			}
-			if maxTokens, ok := opt["max_tokens"].(int); ok {
-				req.MaxTokens = maxTokens
-			}
-			if temp, ok := opt["temperature"].(float64); ok {
-				req.Temperature = temp
-			}
-		}
+	return c.GetChatCompletionContext(context.Background(), messages, options...)
+}
+
+// GetChatCompletionContext is GetChatCompletion with a context. Cancelling
+// ctx abandons the request and returns ctx.Err().
+func (c *Client) GetChatCompletionContext(ctx context.Context, messages []ChatMessage, options ...any) (*ChatCompletionResponse, error) {
+	req := newChatRequest(c.DefaultModel, messages, options)
+	
+	// Fit the prompt and the answer in the context window of the model
+	if err := fitContextWindow(&req, c.modelInfo(ctx, req.Model)); err != nil {
+		return nil, err
 	}
 	
 	// Convert request to JSON
@@ -272,34 +324,22 @@ func (c *Client) GetChatCompletion(messages []ChatMessage, options ...any) (*Cha
 		return nil, fmt.Errorf("error marshaling request: %w", err)
 	}
 	
-	// Create HTTP request
-	httpReq, err := http.NewRequest("POST", OpenRouterChatCompletionURL, bytes.NewBuffer(reqBody))
-	if err != nil {
-		return nil, fmt.Errorf("error creating request: %w", err)
-	}
-	
-	// Add headers
-	httpReq.Header.Set("Content-Type", "application/json")
-	httpReq.Header.Set("Authorization", "Bearer "+c.APIKey)
-	
-	// Send request
-	resp, err := c.HTTPClient.Do(httpReq)
+	// Send request, retrying failures the retry policy allows
+	resp, err := c.send(ctx, "POST", c.url("/chat/completions"), reqBody)
 	if err != nil {
-		return nil, fmt.Errorf("error sending request: %w", err)
+		return nil, err
 	}
 	defer resp.Body.Close()
 	
 	// Read response body
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
 		return nil, fmt.Errorf("error reading response body: %w", err)
 	}
 	
-	// Check for error status code
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("API error: %s - %s", resp.Status, string(body))
-	}
-	
 	// Parse response
 	var chatResp ChatCompletionResponse
 	if err := json.Unmarshal(body, &chatResp); err != nil {
@@ -307,4 +347,42 @@ func (c *Client) GetChatCompletion(messages []ChatMessage, options ...any) (*Cha
 	}
 	
 	return &chatResp, nil
-} 
\ No newline at end of file
+} 
+
+// newChatRequest builds a chat completion request for model from the
+// options given to GetChatCompletion or StreamChatCompletion. MaxTokens is
+// left to fitContextWindow unless the options set it.
+func newChatRequest(model string, messages []ChatMessage, options []any) ChatCompletionRequest {
+	req := ChatCompletionRequest{
+		Model:       model,
+		Messages:    messages,
+		Temperature: 0.7,
+	}
+	
+	// Process optional parameters
+	for _, option := range options {
+		switch opt := option.(type) {
+		case map[string]interface{}:
+			if model, ok := opt["model"].(string); ok {
+				req.Model = model
+			}
+			if maxTokens, ok := opt["max_tokens"].(int); ok {
+				req.MaxTokens = maxTokens
+			}
+			if temp, ok := opt["temperature"].(float64); ok {
+				req.Temperature = temp
+			}
+			if format, ok := opt["response_format"].(*ResponseFormat); ok {
+				req.ResponseFormat = format
+			}
+			if tools, ok := opt["tools"].([]Tool); ok {
+				req.Tools = tools
+			}
+			if choice, ok := opt["tool_choice"]; ok {
+				req.ToolChoice = choice
+			}
+		}
+	}
+	
+	return req
+}
@@ -0,0 +1,119 @@
+package llm
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Provider is a language model API. Client implements it for OpenRouter
+// and OpenAI-compatible APIs, AnthropicClient for Anthropic's, and
+// FakeProvider answers from a script.
+type Provider interface {
+	// GetChatCompletionContext answers a conversation. The options are
+	// those of Client.GetChatCompletion.
+	GetChatCompletionContext(ctx context.Context, messages []ChatMessage, options ...any) (*ChatCompletionResponse, error)
+	// StreamChatCompletion answers a conversation, calling onToken with
+	// each piece of the answer as it is produced
+	StreamChatCompletion(ctx context.Context, messages []ChatMessage, onToken func(token string), options ...any) (*ChatCompletionResponse, error)
+	// GetAvailableModelsContext lists the models of the API. Cancelling
+	// ctx abandons the listing.
+	GetAvailableModelsContext(ctx context.Context) ([]Model, error)
+	// SetModel sets the model used when the options do not name one
+	SetModel(modelID string)
+}
+
+var (
+	_ Provider = (*Client)(nil)
+	_ Provider = (*AnthropicClient)(nil)
+	_ Provider = (*FakeProvider)(nil)
+	_ Provider = (*Recorder)(nil)
+	_ Provider = (*MeteredProvider)(nil)
+)
+
+// Provider names accepted by NewProviderFromEnv
+const (
+	ProviderOpenRouter = "openrouter"
+	ProviderOpenAI     = "openai"
+	ProviderAnthropic  = "anthropic"
+	ProviderOllama     = "ollama"
+)
+
+// Base URLs of well-known APIs
+const (
+	OpenAIBaseURL = "https://api.openai.com/v1"
+	OllamaBaseURL = "http://localhost:11434/v1"
+)
+
+// NewProviderFromEnv creates the provider configured by the environment:
+//
+//	LLM_PROVIDER  openrouter (the default), openai, anthropic or ollama
+//	LLM_BASE_URL  the base URL of an OpenAI-compatible API, for the openai
+//	              and ollama providers
+//	LLM_API_KEY   the API key, or OPENROUTER_API_KEY, OPENAI_API_KEY or
+//	              ANTHROPIC_API_KEY for the matching provider
+//	LLM_MODEL     the default model, or OPENROUTER_DEFAULT_MODEL
+//
+// The openai provider with a base URL talks to any OpenAI-compatible
+// server, such as vLLM, the llama.cpp server or LM Studio.
+func NewProviderFromEnv() (Provider, error) {
+	name := strings.ToLower(os.Getenv("LLM_PROVIDER"))
+	apiKey := os.Getenv("LLM_API_KEY")
+	model := os.Getenv("LLM_MODEL")
+	baseURL := os.Getenv("LLM_BASE_URL")
+
+	switch name {
+	case "", ProviderOpenRouter:
+		if apiKey == "" {
+			apiKey = os.Getenv("OPENROUTER_API_KEY")
+		}
+		if apiKey == "" {
+			return nil, fmt.Errorf("OPENROUTER_API_KEY or LLM_API_KEY environment variable is not set")
+		}
+		if model == "" {
+			model = os.Getenv("OPENROUTER_DEFAULT_MODEL")
+		}
+		if model == "" {
+			model = defaultOpenRouterModel
+		}
+		return NewOpenAICompatibleClient(OpenRouterBaseURL, apiKey, model), nil
+	case ProviderOpenAI:
+		if apiKey == "" {
+			apiKey = os.Getenv("OPENAI_API_KEY")
+		}
+		if baseURL == "" {
+			baseURL = OpenAIBaseURL
+			if apiKey == "" {
+				return nil, fmt.Errorf("OPENAI_API_KEY or LLM_API_KEY environment variable is not set")
+			}
+		}
+		if model == "" {
+			model = "gpt-4o-mini"
+		}
+		return NewOpenAICompatibleClient(baseURL, apiKey, model), nil
+	case ProviderOllama:
+		if baseURL == "" {
+			baseURL = OllamaBaseURL
+		}
+		if model == "" {
+			model = "llama3.1"
+		}
+		return NewOpenAICompatibleClient(baseURL, apiKey, model), nil
+	case ProviderAnthropic:
+		if apiKey == "" {
+			apiKey = os.Getenv("ANTHROPIC_API_KEY")
+		}
+		if apiKey == "" {
+			return nil, fmt.Errorf("ANTHROPIC_API_KEY or LLM_API_KEY environment variable is not set")
+		}
+		client := NewAnthropicClient(apiKey, model)
+		if baseURL != "" {
+			client.BaseURL = strings.TrimSuffix(baseURL, "/")
+		}
+		return client, nil
+	default:
+		return nil, fmt.Errorf("unknown LLM_PROVIDER %q, expected %s, %s, %s or %s",
+			name, ProviderOpenRouter, ProviderOpenAI, ProviderAnthropic, ProviderOllama)
+	}
+}
@@ -0,0 +1,235 @@
+package llm
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Errors reported by the API. They are wrapped by APIError, so callers
+// test for them with errors.Is.
+var (
+	// ErrRateLimited means too many requests were made, and retrying did
+	// not help
+	ErrRateLimited = errors.New("rate limited by the API")
+	// ErrAuth means the API key is missing, invalid or not allowed to use
+	// the model
+	ErrAuth = errors.New("API key rejected")
+	// ErrContextTooLong means the prompt does not fit in the context
+	// window of the model
+	ErrContextTooLong = errors.New("prompt exceeds the context length of the model")
+	// ErrModelUnavailable means the model does not exist or no provider
+	// is currently serving it
+	ErrModelUnavailable = errors.New("model unavailable")
+)
+
+// APIError is an error status returned by the API
+type APIError struct {
+	StatusCode int
+	Status     string
+	Body       string
+	// RetryAfter is the delay the API asked for before the next request,
+	// or zero
+	RetryAfter time.Duration
+	// Kind is the error the status stands for, such as ErrRateLimited,
+	// or nil when it is none of them
+	Kind error
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("API error: %s - %s", e.Status, e.Body)
+}
+
+// Unwrap returns the kind of error
+func (e *APIError) Unwrap() error {
+	return e.Kind
+}
+
+// RetryPolicy controls how failed requests are retried. Requests failing
+// with a rate limit, a server error or a network error are retried after
+// an exponential backoff with jitter, or after the delay the API asks for
+// in Retry-After. A request is not retried when the API asks for a longer
+// delay than MaxDelay.
+type RetryPolicy struct {
+	// MaxRetries is how many times a request is retried; zero disables
+	// retrying
+	MaxRetries int
+	// BaseDelay is the delay before the first retry, doubled for each
+	// further one
+	BaseDelay time.Duration
+	// MaxDelay caps the backoff delay and the delay the API may ask for;
+	// zero leaves both uncapped
+	MaxDelay time.Duration
+}
+
+// DefaultRetryPolicy is used by clients without a RetryPolicy
+var DefaultRetryPolicy = RetryPolicy{
+	MaxRetries: 3,
+	BaseDelay:  500 * time.Millisecond,
+	MaxDelay:   30 * time.Second,
+}
+
+// retryPolicy returns the policy of the client
+func (c *Client) retryPolicy() RetryPolicy {
+	if c.Retry != nil {
+		return *c.Retry
+	}
+	return DefaultRetryPolicy
+}
+
+// send sends a request to the API, retrying as the retry policy allows.
+// It returns the response when its status is 200 OK, and the caller must
+// close its body; any other status is returned as an *APIError. When ctx
+// is done, ctx.Err() is returned.
+func (c *Client) send(ctx context.Context, method, url string, body []byte) (*http.Response, error) {
+	return c.retryPolicy().send(ctx, c.HTTPClient, method, url, body, func(header http.Header) {
+		// Local servers may not need a key
+		if c.APIKey != "" {
+			header.Set("Authorization", "Bearer "+c.APIKey)
+		}
+	})
+}
+
+// send sends a JSON request with the headers set by setHeaders, retrying
+// as the policy allows
+func (policy RetryPolicy) send(ctx context.Context, httpClient *http.Client, method, url string, body []byte, setHeaders func(http.Header)) (*http.Response, error) {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	for attempt := 0; ; attempt++ {
+		var reader io.Reader
+		if body != nil {
+			reader = bytes.NewReader(body)
+		}
+		req, err := http.NewRequestWithContext(ctx, method, url, reader)
+		if err != nil {
+			return nil, fmt.Errorf("error creating request: %w", err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		setHeaders(req.Header)
+
+		resp, err := httpClient.Do(req)
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		var retryAfter time.Duration
+		if err != nil {
+			err = fmt.Errorf("error sending request: %w", err)
+		} else if resp.StatusCode == http.StatusOK {
+			return resp, nil
+		} else {
+			data, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			apiErr := newAPIError(resp, data)
+			if !retryable(resp.StatusCode) {
+				return nil, apiErr
+			}
+			err, retryAfter = apiErr, apiErr.RetryAfter
+		}
+
+		if attempt >= policy.MaxRetries {
+			return nil, err
+		}
+		if policy.MaxDelay > 0 && retryAfter > policy.MaxDelay {
+			// Waiting that long would hold up the caller, who can read
+			// the delay from the error
+			return nil, err
+		}
+		delay := retryAfter
+		if delay <= 0 {
+			delay = policy.backoff(attempt)
+		}
+		log.Printf("LLM request failed (%v), retrying in %v (%d of %d)", err, delay.Round(time.Millisecond), attempt+1, policy.MaxRetries)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// backoff returns the delay before a retry: the base delay doubled for
+// each previous retry, capped at the maximum, of which a random half is
+// taken off so that clients do not retry in step
+func (policy RetryPolicy) backoff(attempt int) time.Duration {
+	delay := policy.BaseDelay
+	for i := 0; i < attempt && (policy.MaxDelay <= 0 || delay < policy.MaxDelay); i++ {
+		delay *= 2
+	}
+	if policy.MaxDelay > 0 && delay > policy.MaxDelay {
+		delay = policy.MaxDelay
+	}
+	if delay <= 0 {
+		return 0
+	}
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(delay-half)+1))
+}
+
+// statusOverloaded is the status Anthropic's API answers with when it is
+// overloaded
+const statusOverloaded = 529
+
+// retryable tells whether a request failing with status may succeed later
+func retryable(status int) bool {
+	switch status {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests,
+		http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout, statusOverloaded:
+		return true
+	}
+	return false
+}
+
+// newAPIError describes an error response
+func newAPIError(resp *http.Response, body []byte) *APIError {
+	e := &APIError{
+		StatusCode: resp.StatusCode,
+		Status:     resp.Status,
+		Body:       string(body),
+		RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+	}
+
+	text := strings.ToLower(e.Body)
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		e.Kind = ErrAuth
+	case resp.StatusCode == http.StatusTooManyRequests:
+		e.Kind = ErrRateLimited
+	case resp.StatusCode == http.StatusRequestEntityTooLarge,
+		resp.StatusCode == http.StatusBadRequest && (strings.Contains(text, "context length") ||
+			strings.Contains(text, "context_length") || strings.Contains(text, "context window") ||
+			strings.Contains(text, "too many tokens") || strings.Contains(text, "maximum context") ||
+			strings.Contains(text, "prompt is too long")):
+		e.Kind = ErrContextTooLong
+	case resp.StatusCode == http.StatusNotFound, resp.StatusCode == http.StatusServiceUnavailable,
+		resp.StatusCode == statusOverloaded:
+		e.Kind = ErrModelUnavailable
+	}
+	return e
+}
+
+// parseRetryAfter reads a Retry-After header, given in seconds or as a date
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil && seconds > 0 {
+		return time.Duration(seconds * float64(time.Second))
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return time.Until(date)
+	}
+	return 0
+}
@@ -0,0 +1,135 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testPolicy retries quickly so that tests do not wait on the backoff
+var testPolicy = RetryPolicy{MaxRetries: 2, BaseDelay: time.Millisecond, MaxDelay: 50 * time.Millisecond}
+
+// serve starts a server answering each request with the next status of
+// statuses, repeating the last one, and returns a client for it and the
+// number of requests it received
+func serve(t *testing.T, header http.Header, statuses ...int) (*Client, *atomic.Int32) {
+	t.Helper()
+
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := int(requests.Add(1))
+		status := statuses[min(n, len(statuses))-1]
+		for key, values := range header {
+			w.Header()[key] = values
+		}
+		w.WriteHeader(status)
+		w.Write([]byte(http.StatusText(status)))
+	}))
+	t.Cleanup(srv.Close)
+
+	policy := testPolicy
+	return &Client{APIKey: "key", BaseURL: srv.URL, Retry: &policy}, &requests
+}
+
+func TestSendRetries(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []int
+		requests int32
+		kind     error
+	}{
+		{"ok", []int{200}, 1, nil},
+		{"rate limited once", []int{429, 200}, 2, nil},
+		{"server error twice", []int{500, 502, 200}, 3, nil},
+		{"rate limited", []int{429}, 3, ErrRateLimited},
+		{"overloaded", []int{529}, 3, ErrModelUnavailable},
+		{"bad key", []int{401, 200}, 1, ErrAuth},
+		{"not found", []int{404, 200}, 1, ErrModelUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, requests := serve(t, nil, tt.statuses...)
+			resp, err := c.send(context.Background(), "POST", c.url("/chat/completions"), []byte("{}"))
+			if err == nil {
+				resp.Body.Close()
+			}
+			if got := requests.Load(); got != tt.requests {
+				t.Errorf("%d requests, want %d", got, tt.requests)
+			}
+			if tt.kind == nil {
+				if err != nil {
+					t.Errorf("send: %v", err)
+				}
+				return
+			}
+			var apiErr *APIError
+			if !errors.As(err, &apiErr) || !errors.Is(err, tt.kind) {
+				t.Errorf("got %v, want an APIError for %v", err, tt.kind)
+			}
+		})
+	}
+}
+
+func TestSendRetryAfter(t *testing.T) {
+	// A delay within MaxDelay is waited for
+	c, requests := serve(t, http.Header{"Retry-After": {"0.02"}}, 429, 200)
+	start := time.Now()
+	resp, err := c.send(context.Background(), "GET", c.url("/models"), nil)
+	if err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	resp.Body.Close()
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+		t.Errorf("retried after %v, before the 20ms the API asked for", elapsed)
+	}
+	if requests.Load() != 2 {
+		t.Errorf("%d requests, want 2", requests.Load())
+	}
+
+	// A longer delay is not waited for, and is left in the error
+	c, requests = serve(t, http.Header{"Retry-After": {"3600"}}, 429, 200)
+	start = time.Now()
+	_, err = c.send(context.Background(), "GET", c.url("/models"), nil)
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.RetryAfter != time.Hour {
+		t.Fatalf("got %v, want an APIError asking for an hour", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("gave up after %v", elapsed)
+	}
+	if requests.Load() != 1 {
+		t.Errorf("%d requests, want 1", requests.Load())
+	}
+}
+
+func TestSendCancel(t *testing.T) {
+	c, requests := serve(t, nil, 503)
+	c.Retry = &RetryPolicy{MaxRetries: 5, BaseDelay: time.Hour, MaxDelay: time.Hour}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		_, err := c.send(ctx, "GET", c.url("/models"), nil)
+		done <- err
+	}()
+	for requests.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("got %v, want context.Canceled", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("send kept waiting after its context was cancelled")
+	}
+	if requests.Load() != 1 {
+		t.Errorf("%d requests, want 1", requests.Load())
+	}
+}
@@ -0,0 +1,194 @@
+package llm
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// streamChunk is one server-sent event of a streamed chat completion
+type streamChunk struct {
+	ID      string `json:"id"`
+	Model   string `json:"model"`
+	Choices []struct {
+		Delta struct {
+			Role      string          `json:"role"`
+			Content   string          `json:"content"`
+			ToolCalls []toolCallDelta `json:"tool_calls"`
+		} `json:"delta"`
+		FinishReason string `json:"finish_reason"`
+	} `json:"choices"`
+	// Usage comes with the last chunk
+	Usage *Usage `json:"usage"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// toolCallDelta is a piece of a tool call in a stream. The pieces of a call
+// share its index; its ID, type and name come with the first, and its
+// arguments are split across all of them.
+type toolCallDelta struct {
+	Index    int    `json:"index"`
+	ID       string `json:"id"`
+	Type     string `json:"type"`
+	Function struct {
+		Name      string `json:"name"`
+		Arguments string `json:"arguments"`
+	} `json:"function"`
+}
+
+// StreamChatCompletion sends a chat completion request and calls onToken
+// with each piece of the answer as the server produces it. It takes the
+// same options as GetChatCompletion and returns the whole answer once the
+// stream ends. Cancelling ctx closes the connection and returns ctx.Err().
+func (c *Client) StreamChatCompletion(ctx context.Context, messages []ChatMessage, onToken func(token string), options ...any) (*ChatCompletionResponse, error) {
+	req := newChatRequest(c.DefaultModel, messages, options)
+	req.Stream = true
+	req.StreamOptions = &StreamOptions{IncludeUsage: true}
+	if err := fitContextWindow(&req, c.modelInfo(ctx, req.Model)); err != nil {
+		return nil, err
+	}
+
+	reqBody, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling request: %w", err)
+	}
+
+	resp, err := c.send(ctx, "POST", c.url("/chat/completions"), reqBody)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var id, model, role, finishReason string
+	var usage *Usage
+	var content strings.Builder
+	var toolCalls []ToolCall
+	err = readEvents(resp.Body, func(data string) error {
+		var chunk streamChunk
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return fmt.Errorf("error unmarshaling stream event: %w", err)
+		}
+		if chunk.Error != nil {
+			return fmt.Errorf("API error in stream: %s", chunk.Error.Message)
+		}
+		if chunk.ID != "" {
+			id = chunk.ID
+		}
+		if chunk.Model != "" {
+			model = chunk.Model
+		}
+		if chunk.Usage != nil {
+			usage = chunk.Usage
+		}
+		for _, choice := range chunk.Choices {
+			if choice.Delta.Role != "" {
+				role = choice.Delta.Role
+			}
+			if choice.FinishReason != "" {
+				finishReason = choice.FinishReason
+			}
+			if choice.Delta.Content != "" {
+				content.WriteString(choice.Delta.Content)
+				if onToken != nil {
+					onToken(choice.Delta.Content)
+				}
+			}
+			for _, delta := range choice.Delta.ToolCalls {
+				if delta.Index < 0 {
+					return fmt.Errorf("tool call with index %d in stream", delta.Index)
+				}
+				for len(toolCalls) <= delta.Index {
+					toolCalls = append(toolCalls, ToolCall{})
+				}
+				call := &toolCalls[delta.Index]
+				if delta.ID != "" {
+					call.ID = delta.ID
+				}
+				if delta.Type != "" {
+					call.Type = delta.Type
+				}
+				if delta.Function.Name != "" {
+					call.Function.Name = delta.Function.Name
+				}
+				call.Function.Arguments += delta.Function.Arguments
+			}
+		}
+		return nil
+	})
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if role == "" {
+		role = "assistant"
+	}
+	return &ChatCompletionResponse{
+		ID:    id,
+		Model: model,
+		Choices: []ChatChoice{{
+			Message:      ChatMessage{Role: role, Content: content.String(), ToolCalls: toolCalls},
+			FinishReason: finishReason,
+		}},
+		Usage: usage,
+	}, nil
+}
+
+// errStreamDone stops reading events at the end of a stream
+var errStreamDone = errors.New("stream done")
+
+// readEvents reads server-sent events from r and calls handle with the data
+// of each, until the "[DONE]" event or the end of r. Comments, which some
+// providers send to keep the connection open, are skipped.
+func readEvents(r io.Reader, handle func(data string) error) error {
+	reader := bufio.NewReader(r)
+	var data []string
+	dispatch := func() error {
+		if len(data) == 0 {
+			return nil
+		}
+		event := strings.Join(data, "\n")
+		data = data[:0]
+		if event == "[DONE]" {
+			return errStreamDone
+		}
+		return handle(event)
+	}
+
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return fmt.Errorf("error reading stream: %w", err)
+		}
+		line = strings.TrimRight(line, "\r\n")
+
+		switch {
+		case line == "":
+			if dispatchErr := dispatch(); dispatchErr != nil {
+				if dispatchErr == errStreamDone {
+					return nil
+				}
+				return dispatchErr
+			}
+		case strings.HasPrefix(line, ":"):
+			// Comment
+		case strings.HasPrefix(line, "data:"):
+			data = append(data, strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+		}
+
+		if err == io.EOF {
+			if dispatchErr := dispatch(); dispatchErr != nil && dispatchErr != errStreamDone {
+				return dispatchErr
+			}
+			return nil
+		}
+	}
+}
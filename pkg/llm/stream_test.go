@@ -0,0 +1,81 @@
+package llm
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// serveStream starts a server answering chat completions with the events
+// and returns a client for it
+func serveStream(t *testing.T, events ...string) *Client {
+	t.Helper()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/models") {
+			w.Write([]byte(`{"data": []}`))
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, event := range events {
+			w.Write([]byte("data: " + event + "\n\n"))
+		}
+		w.Write([]byte("data: [DONE]\n\n"))
+	}))
+	t.Cleanup(srv.Close)
+	return &Client{APIKey: "key", BaseURL: srv.URL, DefaultModel: "model"}
+}
+
+func TestStreamChatCompletion(t *testing.T) {
+	c := serveStream(t,
+		`{"id": "1", "choices": [{"delta": {"role": "assistant", "content": "Hel"}}]}`,
+		`{"id": "1", "choices": [{"delta": {"content": "lo"}, "finish_reason": "stop"}]}`,
+		`{"id": "1", "choices": [], "usage": {"prompt_tokens": 3, "completion_tokens": 2, "total_tokens": 5}}`,
+	)
+
+	var tokens []string
+	resp, err := c.StreamChatCompletion(context.Background(), []ChatMessage{{Role: "user", Content: "hi"}}, func(token string) {
+		tokens = append(tokens, token)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(tokens, "|"); got != "Hel|lo" {
+		t.Errorf("tokens %q, want Hel|lo", got)
+	}
+	if msg := resp.Choices[0].Message; msg.Content != "Hello" || msg.Role != "assistant" {
+		t.Errorf("message %+v", msg)
+	}
+	if resp.Choices[0].FinishReason != "stop" || resp.Usage == nil || resp.Usage.TotalTokens != 5 {
+		t.Errorf("finish reason %q, usage %+v", resp.Choices[0].FinishReason, resp.Usage)
+	}
+}
+
+func TestStreamChatCompletionToolCalls(t *testing.T) {
+	c := serveStream(t,
+		`{"choices": [{"delta": {"role": "assistant", "tool_calls": [{"index": 0, "id": "call-1", "type": "function", "function": {"name": "code_bundle", "arguments": ""}}]}}]}`,
+		`{"choices": [{"delta": {"tool_calls": [{"index": 0, "function": {"arguments": "{\"code\": "}}]}}]}`,
+		`{"choices": [{"delta": {"tool_calls": [{"index": 1, "id": "call-2", "type": "function", "function": {"name": "intent", "arguments": "{}"}}]}}]}`,
+		`{"choices": [{"delta": {"tool_calls": [{"index": 0, "function": {"arguments": "\"x\"}"}}]}, "finish_reason": "tool_calls"}]}`,
+	)
+
+	resp, err := c.StreamChatCompletion(context.Background(), []ChatMessage{{Role: "user", Content: "hi"}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Choices[0].FinishReason != "tool_calls" {
+		t.Errorf("finish reason %q", resp.Choices[0].FinishReason)
+	}
+	call, err := resp.ToolCall("code_bundle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if call.ID != "call-1" || call.Type != "function" || call.Function.Arguments != `{"code": "x"}` {
+		t.Errorf("code_bundle call %+v", call)
+	}
+	if calls := resp.Choices[0].Message.ToolCalls; len(calls) != 2 || calls[1].ID != "call-2" || calls[1].Function.Arguments != "{}" {
+		t.Errorf("tool calls %+v", calls)
+	}
+}
@@ -0,0 +1,163 @@
+package llm
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Response format types
+const (
+	ResponseFormatText       = "text"
+	ResponseFormatJSONObject = "json_object"
+	ResponseFormatJSONSchema = "json_schema"
+)
+
+// ErrNoToolCall is returned when a response does not call the requested tool
+var ErrNoToolCall = errors.New("response contains no call to the tool")
+
+// ResponseFormat constrains the content of the model's answer. It is passed
+// as the "response_format" option of GetChatCompletion.
+type ResponseFormat struct {
+	Type       string      `json:"type"`
+	JSONSchema *JSONSchema `json:"json_schema,omitempty"`
+}
+
+// JSONSchema is a named JSON Schema the answer must conform to. With Strict
+// set, providers that support it guarantee conformance; others treat the
+// schema as a hint.
+type JSONSchema struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	Schema      json.RawMessage `json:"schema"`
+	Strict      bool            `json:"strict,omitempty"`
+}
+
+// JSONObjectFormat asks for an answer that is a JSON object
+func JSONObjectFormat() *ResponseFormat {
+	return &ResponseFormat{Type: ResponseFormatJSONObject}
+}
+
+// JSONSchemaFormat asks for an answer conforming to a JSON Schema
+func JSONSchemaFormat(name, schema string) *ResponseFormat {
+	return &ResponseFormat{
+		Type: ResponseFormatJSONSchema,
+		JSONSchema: &JSONSchema{
+			Name:   name,
+			Schema: json.RawMessage(schema),
+		},
+	}
+}
+
+// Tool is a function the model may call. Tools are passed as the "tools"
+// option of GetChatCompletion.
+type Tool struct {
+	Type     string             `json:"type"`
+	Function FunctionDefinition `json:"function"`
+}
+
+// FunctionDefinition describes a function and, as a JSON Schema, its
+// arguments
+type FunctionDefinition struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	Parameters  json.RawMessage `json:"parameters"`
+}
+
+// FunctionTool defines a tool calling a function
+func FunctionTool(name, description, parameters string) Tool {
+	return Tool{
+		Type: "function",
+		Function: FunctionDefinition{
+			Name:        name,
+			Description: description,
+			Parameters:  json.RawMessage(parameters),
+		},
+	}
+}
+
+// ToolChoiceFunction is the "tool_choice" option forcing the model to call
+// the named function. The strings "auto", "none" and "required" are also
+// accepted as tool choices.
+func ToolChoiceFunction(name string) map[string]interface{} {
+	return map[string]interface{}{
+		"type":     "function",
+		"function": map[string]string{"name": name},
+	}
+}
+
+// ToolCall is a call to a tool made by the model
+type ToolCall struct {
+	ID       string `json:"id"`
+	Type     string `json:"type"`
+	Function struct {
+		Name      string `json:"name"`
+		Arguments string `json:"arguments"`
+	} `json:"function"`
+}
+
+// DecodeArguments decodes the arguments of the call into v
+func (t *ToolCall) DecodeArguments(v interface{}) error {
+	if err := json.Unmarshal([]byte(t.Function.Arguments), v); err != nil {
+		return fmt.Errorf("error decoding arguments of %s: %w", t.Function.Name, err)
+	}
+	return nil
+}
+
+// DecodeJSON decodes the content of the first choice into v. Use it with
+// a JSON response format; a markdown code fence around the JSON is ignored.
+func (r *ChatCompletionResponse) DecodeJSON(v interface{}) error {
+	if len(r.Choices) == 0 {
+		return errors.New("no choices in response")
+	}
+	content := StripCodeFence(r.Choices[0].Message.Content)
+	if err := json.Unmarshal([]byte(content), v); err != nil {
+		return fmt.Errorf("error decoding response content: %w", err)
+	}
+	return nil
+}
+
+// FormatContent returns the content of the first choice. Some models
+// answer a JSON Schema response format by calling a tool named after the
+// schema instead; the arguments of that call are returned then.
+func (r *ChatCompletionResponse) FormatContent(format string) string {
+	if len(r.Choices) == 0 {
+		return ""
+	}
+	content := r.Choices[0].Message.Content
+	if strings.TrimSpace(content) == "" {
+		if call, err := r.ToolCall(format); err == nil {
+			return call.Function.Arguments
+		}
+	}
+	return content
+}
+
+// StripCodeFence removes a surrounding markdown code fence and the space
+// around it. Models often add one around code and JSON despite being asked
+// not to.
+func StripCodeFence(text string) string {
+	text = strings.TrimSpace(text)
+	if !strings.HasPrefix(text, "```") {
+		return text
+	}
+	text = strings.TrimPrefix(text, "```")
+	if newline := strings.Index(text, "\n"); newline >= 0 {
+		text = text[newline+1:]
+	}
+	return strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(text), "```"))
+}
+
+// ToolCall returns the first call of the first choice to the named tool,
+// or an error wrapping ErrNoToolCall
+func (r *ChatCompletionResponse) ToolCall(name string) (*ToolCall, error) {
+	if len(r.Choices) > 0 {
+		for i, call := range r.Choices[0].Message.ToolCalls {
+			if call.Function.Name == name {
+				return &r.Choices[0].Message.ToolCalls[i], nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("%w %s", ErrNoToolCall, name)
+}
@@ -0,0 +1,258 @@
+package llm
+
+import (
+	"fmt"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	// DefaultMaxTokens is the answer length asked for when the context
+	// window of the model is unknown
+	DefaultMaxTokens = 4096
+
+	// MinAnswerTokens is the room kept for the answer when a prompt is
+	// trimmed to fit the context window
+	MinAnswerTokens = 512
+
+	// maxAutoTokens caps the answer length chosen from the context window
+	// of a model that does not state its maximum, as many APIs reject
+	// requests for more than the model can produce
+	maxAutoTokens = 16384
+
+	// messageOverheadTokens is what the chat format costs per message
+	messageOverheadTokens = 4
+)
+
+// charsPerToken is the average length of the word pieces of tokenizers,
+// keyed on Model.Architecture.Tokenizer. Tokenizers with small vocabularies
+// split words into shorter pieces.
+var charsPerToken = map[string]float64{
+	"GPT":      4.0,
+	"Claude":   3.5,
+	"Gemini":   4.0,
+	"Llama3":   4.0,
+	"Llama4":   4.0,
+	"Llama2":   3.2,
+	"Mistral":  3.2,
+	"Qwen":     3.8,
+	"Qwen3":    3.8,
+	"DeepSeek": 3.8,
+	"Cohere":   3.8,
+	"Grok":     3.8,
+}
+
+// defaultCharsPerToken is used for unknown tokenizers. It errs towards
+// more tokens, which only makes the budget tighter.
+const defaultCharsPerToken = 3.3
+
+// EstimateTokens estimates how many tokens text takes for the tokenizer
+// named by Model.Architecture.Tokenizer, or a generic one when it is
+// unknown. Words are split into pieces of the tokenizer's average length,
+// while punctuation, which is frequent in code, takes a token per symbol.
+func EstimateTokens(text, tokenizer string) int {
+	ratio, ok := charsPerToken[tokenizer]
+	if !ok {
+		ratio = defaultCharsPerToken
+	}
+	// SentencePiece tokenizers of the Llama 2 era split numbers into digits
+	digitsPerToken := 3
+	if tokenizer == "Llama2" || tokenizer == "Mistral" {
+		digitsPerToken = 1
+	}
+
+	tokens := 0
+	for i := 0; i < len(text); {
+		r, size := utf8.DecodeRuneInString(text[i:])
+		start := i
+		switch {
+		case r < utf8.RuneSelf && (unicode.IsLetter(r) || r == '_'):
+			for i < len(text) && text[i] < utf8.RuneSelf && (isASCIILetter(text[i]) || text[i] == '_') {
+				i++
+			}
+			tokens += int(float64(i-start)/ratio + 0.999)
+		case unicode.IsDigit(r):
+			count := 0
+			for i < len(text) {
+				r, size := utf8.DecodeRuneInString(text[i:])
+				if !unicode.IsDigit(r) {
+					break
+				}
+				i += size
+				count++
+			}
+			tokens += (count + digitsPerToken - 1) / digitsPerToken
+		case unicode.IsSpace(r):
+			for i < len(text) {
+				r, size := utf8.DecodeRuneInString(text[i:])
+				if !unicode.IsSpace(r) {
+					break
+				}
+				i += size
+			}
+			// A single space is part of the next word; line breaks and
+			// indentation take tokens of their own
+			if i-start > 1 || text[start] != ' ' {
+				tokens++
+			}
+		default:
+			// Punctuation, and letters outside ASCII, which tokenizers
+			// rarely merge
+			i += size
+			tokens++
+		}
+	}
+	return tokens
+}
+
+// isASCIILetter tells whether c is an ASCII letter
+func isASCIILetter(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// EstimateMessageTokens estimates how many tokens the messages of a chat
+// take, including the overhead of the chat format
+func EstimateMessageTokens(messages []ChatMessage, tokenizer string) int {
+	tokens := 3
+	for _, message := range messages {
+		tokens += messageTokens(message, tokenizer)
+	}
+	return tokens
+}
+
+// messageTokens estimates how many tokens a message takes
+func messageTokens(message ChatMessage, tokenizer string) int {
+	tokens := messageOverheadTokens + EstimateTokens(message.Content, tokenizer)
+	for _, call := range message.ToolCalls {
+		tokens += messageOverheadTokens + EstimateTokens(call.Function.Name, tokenizer) +
+			EstimateTokens(call.Function.Arguments, tokenizer)
+	}
+	return tokens
+}
+
+// estimateRequestTokens estimates how many tokens the prompt of a request
+// takes: its messages, and the schemas of its tools and response format
+func estimateRequestTokens(req *ChatCompletionRequest, tokenizer string) int {
+	tokens := EstimateMessageTokens(req.Messages, tokenizer)
+	for _, tool := range req.Tools {
+		tokens += EstimateTokens(tool.Function.Name+" "+tool.Function.Description+" "+string(tool.Function.Parameters), tokenizer)
+	}
+	if req.ResponseFormat != nil && req.ResponseFormat.JSONSchema != nil {
+		tokens += EstimateTokens(string(req.ResponseFormat.JSONSchema.Schema), tokenizer)
+	}
+	return tokens
+}
+
+// fitContextWindow budgets a request for model, which may be nil when it
+// is unknown. When the prompt leaves too little room for the answer, the
+// oldest messages of the conversation are left out, keeping the system
+// messages, the first request and the last message. max_tokens is then set
+// to what remains of the window, or capped to it when the caller gave it.
+// An error wrapping ErrContextTooLong is returned when the prompt cannot
+// fit.
+func fitContextWindow(req *ChatCompletionRequest, model *Model) error {
+	var window, maxOutput int
+	var tokenizer string
+	if model != nil {
+		window = model.ContextLength
+		if model.TopProvider.ContextLength > 0 {
+			window = model.TopProvider.ContextLength
+		}
+		maxOutput = model.TopProvider.MaxCompletionTokens
+		tokenizer = model.Architecture.Tokenizer
+	}
+
+	if window <= 0 {
+		if req.MaxTokens == 0 {
+			req.MaxTokens = DefaultMaxTokens
+			if maxOutput > 0 {
+				req.MaxTokens = min(req.MaxTokens, maxOutput)
+			}
+		}
+		return nil
+	}
+
+	// The estimate is rough, so a tenth more is kept aside
+	budget := func() int {
+		prompt := estimateRequestTokens(req, tokenizer)
+		return prompt + prompt/10
+	}
+	reserve := MinAnswerTokens
+	if req.MaxTokens > 0 {
+		reserve = min(reserve, req.MaxTokens)
+	}
+
+	prompt := budget()
+	if prompt+reserve > window {
+		schemas := estimateRequestTokens(req, tokenizer) - EstimateMessageTokens(req.Messages, tokenizer)
+		req.Messages = trimMessages(req.Messages, window-reserve-schemas, tokenizer)
+		prompt = budget()
+	}
+	if prompt+reserve > window {
+		return fmt.Errorf("%w: the prompt needs about %d tokens, but %s has a context window of %d tokens, of which at least %d are kept for the answer",
+			ErrContextTooLong, prompt, req.Model, window, reserve)
+	}
+
+	available := window - prompt
+	if maxOutput > 0 {
+		available = min(available, maxOutput)
+	} else {
+		available = min(available, maxAutoTokens)
+	}
+	if req.MaxTokens == 0 || req.MaxTokens > available {
+		req.MaxTokens = available
+	}
+	return nil
+}
+
+// trimMessages leaves out the oldest messages of a conversation until it
+// fits in limit tokens. System messages, the first request and the last
+// message are kept; tool results go with the call they answer. A note
+// takes the place of what was left out.
+func trimMessages(messages []ChatMessage, limit int, tokenizer string) []ChatMessage {
+	firstRequest := -1
+	for i, message := range messages {
+		if message.Role != "system" {
+			firstRequest = i
+			break
+		}
+	}
+	if firstRequest < 0 {
+		return messages
+	}
+
+	total := EstimateMessageTokens(messages, tokenizer)
+	dropped := make([]bool, len(messages))
+	count := 0
+	for i := firstRequest + 1; i < len(messages)-1 && total+total/10 > limit; i++ {
+		if dropped[i] || messages[i].Role == "system" {
+			continue
+		}
+		dropped[i] = true
+		total -= messageTokens(messages[i], tokenizer)
+		count++
+		for j := i + 1; j < len(messages)-1 && messages[j].Role == "tool"; j++ {
+			dropped[j] = true
+			total -= messageTokens(messages[j], tokenizer)
+			count++
+		}
+	}
+	if count == 0 {
+		return messages
+	}
+
+	trimmed := make([]ChatMessage, 0, len(messages)-count+1)
+	for i, message := range messages {
+		if dropped[i] {
+			continue
+		}
+		trimmed = append(trimmed, message)
+		if i == firstRequest {
+			trimmed = append(trimmed, ChatMessage{
+				Role:    "system",
+				Content: fmt.Sprintf("%d earlier messages of this conversation were left out to fit the context window.", count),
+			})
+		}
+	}
+	return trimmed
+}
@@ -0,0 +1,181 @@
+package llm
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestEstimateTokens(t *testing.T) {
+	tests := []struct {
+		text      string
+		tokenizer string
+		want      int
+	}{
+		{"", "GPT", 0},
+		{"hello", "GPT", 2},
+		{"hello world", "GPT", 4},
+		{"hello  world", "GPT", 5},
+		{"func main() {}", "GPT", 6},
+		{"12345", "GPT", 2},
+		{"12345", "Llama2", 5},
+		{"café", "GPT", 2},
+		{"\n\t", "GPT", 1},
+		{"snake_case", "unknown", 4},
+	}
+	for _, tt := range tests {
+		if got := EstimateTokens(tt.text, tt.tokenizer); got != tt.want {
+			t.Errorf("EstimateTokens(%q, %q) = %d, want %d", tt.text, tt.tokenizer, got, tt.want)
+		}
+	}
+}
+
+// words returns a message of n one-token words for the GPT tokenizer
+func words(role string, n int) ChatMessage {
+	return ChatMessage{Role: role, Content: strings.TrimSpace(strings.Repeat("word ", n))}
+}
+
+// testModel describes a model with a context window of window tokens and
+// answers of at most maxOutput tokens
+func testModel(window, maxOutput int) *Model {
+	model := &Model{ID: "test", ContextLength: window}
+	model.TopProvider.MaxCompletionTokens = maxOutput
+	model.Architecture.Tokenizer = "GPT"
+	return model
+}
+
+func TestFitContextWindow(t *testing.T) {
+	short := []ChatMessage{words("system", 10), words("user", 10)}
+	shortPrompt := EstimateMessageTokens(short, "GPT")
+	shortPrompt += shortPrompt / 10
+
+	tests := []struct {
+		name      string
+		model     *Model
+		maxTokens int
+		want      int
+	}{
+		{"unknown model", nil, 0, DefaultMaxTokens},
+		{"unknown model, max tokens given", nil, 100, 100},
+		{"unknown window, output limit", testModel(0, 1000), 0, 1000},
+		{"rest of the window", testModel(2000, 0), 0, 2000 - shortPrompt},
+		{"large window", testModel(1000000, 0), 0, maxAutoTokens},
+		{"output limit", testModel(2000, 300), 0, 300},
+		{"max tokens given", testModel(2000, 0), 100, 100},
+		{"max tokens capped", testModel(2000, 0), 5000, 2000 - shortPrompt},
+	}
+	for _, tt := range tests {
+		req := ChatCompletionRequest{Model: "test", Messages: short, MaxTokens: tt.maxTokens}
+		if err := fitContextWindow(&req, tt.model); err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if req.MaxTokens != tt.want {
+			t.Errorf("%s: max_tokens = %d, want %d", tt.name, req.MaxTokens, tt.want)
+		}
+		if len(req.Messages) != len(short) {
+			t.Errorf("%s: short prompt trimmed", tt.name)
+		}
+	}
+
+	// A conversation is trimmed to leave room for the answer
+	req := ChatCompletionRequest{Model: "test", Messages: []ChatMessage{
+		words("system", 10), words("user", 100), words("assistant", 500), words("user", 500), words("assistant", 10),
+	}}
+	if err := fitContextWindow(&req, testModel(1000, 0)); err != nil {
+		t.Fatalf("long conversation: %v", err)
+	}
+	if req.MaxTokens < MinAnswerTokens {
+		t.Errorf("%d tokens left for the answer, want at least %d", req.MaxTokens, MinAnswerTokens)
+	}
+	if prompt := estimateRequestTokens(&req, "GPT"); prompt+req.MaxTokens > 1000 {
+		t.Errorf("prompt of %d tokens and answer of %d exceed the window", prompt, req.MaxTokens)
+	}
+
+	// A prompt that cannot be trimmed enough is rejected
+	req = ChatCompletionRequest{Model: "test", Messages: []ChatMessage{words("system", 10), words("user", 900)}}
+	if err := fitContextWindow(&req, testModel(1000, 0)); !errors.Is(err, ErrContextTooLong) {
+		t.Errorf("got %v, want ErrContextTooLong", err)
+	}
+}
+
+// roles lists the roles of messages, with "note" for the note standing for
+// left out messages
+func roles(messages []ChatMessage) []string {
+	var list []string
+	for _, message := range messages {
+		if message.Role == "system" && strings.Contains(message.Content, "were left out") {
+			list = append(list, "note")
+		} else {
+			list = append(list, message.Role)
+		}
+	}
+	return list
+}
+
+func TestTrimMessages(t *testing.T) {
+	call := ToolCall{ID: "call-1", Type: "function"}
+	call.Function.Name = "search"
+	call.Function.Arguments = `{"q": "x"}`
+
+	tests := []struct {
+		name     string
+		messages []ChatMessage
+		limit    int
+		want     string
+	}{
+		{
+			"fits",
+			[]ChatMessage{words("system", 10), words("user", 100), words("assistant", 100)},
+			1000,
+			"system user assistant",
+		},
+		{
+			"only system messages",
+			[]ChatMessage{words("system", 500), words("system", 500)},
+			100,
+			"system system",
+		},
+		{
+			"oldest first",
+			[]ChatMessage{words("system", 1), words("user", 100), words("assistant", 100), words("user", 100), words("assistant", 100), words("user", 100)},
+			500,
+			"system user note user assistant user",
+		},
+		{
+			"first request and last message kept",
+			[]ChatMessage{words("system", 1), words("user", 100), words("assistant", 100), words("user", 100), words("assistant", 100), words("user", 100)},
+			10,
+			"system user note user",
+		},
+		{
+			"first request after system messages",
+			[]ChatMessage{words("system", 1), words("system", 1), words("user", 100), words("assistant", 100), words("system", 1), words("user", 100)},
+			10,
+			"system system user note system user",
+		},
+		{
+			"tool results go with their call",
+			[]ChatMessage{
+				words("system", 1), words("user", 100),
+				{Role: "assistant", ToolCalls: []ToolCall{call}},
+				{Role: "tool", ToolCallID: "call-1", Content: strings.Repeat("result ", 100)},
+				{Role: "tool", ToolCallID: "call-1", Content: strings.Repeat("result ", 100)},
+				words("assistant", 10), words("user", 10),
+			},
+			200,
+			"system user note assistant user",
+		},
+	}
+	for _, tt := range tests {
+		trimmed := trimMessages(tt.messages, tt.limit, "GPT")
+		if got := strings.Join(roles(trimmed), " "); got != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.name, got, tt.want)
+		}
+		for i, message := range trimmed {
+			if message.Role == "tool" && (i == 0 || trimmed[i-1].Role != "tool" && len(trimmed[i-1].ToolCalls) == 0) {
+				t.Errorf("%s: tool result %d kept without its call", tt.name, i)
+			}
+		}
+	}
+}
@@ -0,0 +1,421 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// UsageFile is where a workspace keeps its usage totals, relative to the
+// workspace directory
+const UsageFile = ".ainative/usage.json"
+
+// ErrBudgetExceeded is returned instead of sending a request once a budget
+// of the UsageTracker is spent
+var ErrBudgetExceeded = errors.New("usage budget exceeded")
+
+// Usage is the token usage of a request, as reported by the API. Cost is
+// in US dollars; some APIs, such as OpenRouter's, report it themselves.
+type Usage struct {
+	PromptTokens     int     `json:"prompt_tokens"`
+	CompletionTokens int     `json:"completion_tokens"`
+	TotalTokens      int     `json:"total_tokens"`
+	Cost             float64 `json:"cost,omitempty"`
+}
+
+// Cost computes the cost of usage in US dollars from the pricing of the
+// model. Prices that are not listed count as free.
+func (m *Model) Cost(usage Usage) float64 {
+	price := func(value string) float64 {
+		p, err := strconv.ParseFloat(value, 64)
+		if err != nil || p < 0 {
+			return 0
+		}
+		return p
+	}
+	return price(m.Pricing.Prompt)*float64(usage.PromptTokens) +
+		price(m.Pricing.Completion)*float64(usage.CompletionTokens) +
+		price(m.Pricing.Request)
+}
+
+// Priced tells whether the model lists its prices
+func (m *Model) Priced() bool {
+	for _, value := range []string{m.Pricing.Prompt, m.Pricing.Completion} {
+		if _, err := strconv.ParseFloat(value, 64); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// knownPrices are the prices of models whose API does not list them, in US
+// dollars per million prompt and completion tokens, by model ID prefix. The
+// first matching prefix applies.
+var knownPrices = []struct {
+	prefix             string
+	prompt, completion float64
+}{
+	{"claude-opus-4-5", 5, 25},
+	{"claude-opus-4", 15, 75},
+	{"claude-3-opus", 15, 75},
+	{"claude-sonnet-4", 3, 15},
+	{"claude-3-7-sonnet", 3, 15},
+	{"claude-3-5-sonnet", 3, 15},
+	{"claude-haiku-4-5", 1, 5},
+	{"claude-3-5-haiku", 0.8, 4},
+	{"claude-3-haiku", 0.25, 1.25},
+}
+
+// setKnownPrice sets the pricing of a model from knownPrices, and tells
+// whether it is known
+func setKnownPrice(model *Model) bool {
+	for _, known := range knownPrices {
+		if strings.HasPrefix(model.ID, known.prefix) {
+			model.Pricing.Prompt = strconv.FormatFloat(known.prompt/1e6, 'g', -1, 64)
+			model.Pricing.Completion = strconv.FormatFloat(known.completion/1e6, 'g', -1, 64)
+			return true
+		}
+	}
+	return false
+}
+
+// UsageTotals adds up the usage of requests
+type UsageTotals struct {
+	Requests         int     `json:"requests"`
+	PromptTokens     int     `json:"promptTokens"`
+	CompletionTokens int     `json:"completionTokens"`
+	Cost             float64 `json:"cost"`
+}
+
+// add counts a request
+func (t *UsageTotals) add(usage Usage, cost float64) {
+	t.Requests++
+	t.PromptTokens += usage.PromptTokens
+	t.CompletionTokens += usage.CompletionTokens
+	t.Cost += cost
+}
+
+// Budget limits spending, in US dollars. Zero limits are unlimited.
+type Budget struct {
+	Session float64 `json:"session"`
+	Project float64 `json:"project"`
+	Daily   float64 `json:"daily"`
+}
+
+// BudgetFromEnv reads the budget from LLM_BUDGET_SESSION,
+// LLM_BUDGET_PROJECT and LLM_BUDGET_DAILY, in US dollars
+func BudgetFromEnv() Budget {
+	limit := func(name string) float64 {
+		value := os.Getenv(name)
+		if value == "" {
+			return 0
+		}
+		limit, err := strconv.ParseFloat(value, 64)
+		if err != nil || limit < 0 {
+			log.Printf("Warning: ignoring invalid %s %q", name, value)
+			return 0
+		}
+		return limit
+	}
+	return Budget{
+		Session: limit("LLM_BUDGET_SESSION"),
+		Project: limit("LLM_BUDGET_PROJECT"),
+		Daily:   limit("LLM_BUDGET_DAILY"),
+	}
+}
+
+// UsageReport is what a UsageTracker has counted
+type UsageReport struct {
+	Project string                 `json:"project"`
+	Session UsageTotals            `json:"session"`
+	Today   UsageTotals            `json:"today"`
+	Total   UsageTotals            `json:"projectTotal"`
+	Days    map[string]UsageTotals `json:"days"`
+	Budget  Budget                 `json:"budget"`
+	// Unpriced lists the models used without known prices, whose cost
+	// was counted as zero
+	Unpriced []string `json:"unpricedModels,omitempty"`
+}
+
+// storedUsage is the saved form of the totals
+type storedUsage struct {
+	Projects map[string]UsageTotals `json:"projects"`
+	Days     map[string]UsageTotals `json:"days"`
+}
+
+// UsageTracker adds up the usage and cost of requests for the session, the
+// current project and the day, and keeps the project and daily totals in a
+// file. Requests are refused once a budget is spent.
+type UsageTracker struct {
+	path string
+
+	mu       sync.Mutex
+	project  string
+	budget   Budget
+	session  UsageTotals
+	stored   storedUsage
+	unpriced map[string]bool
+	// now tells the time, which decides the day requests are counted for
+	now func() time.Time
+}
+
+// NewUsageTracker creates a tracker keeping its totals in path, which is
+// read when it exists, and counting requests for project. When the file
+// cannot be read, the error is returned with a tracker starting afresh.
+func NewUsageTracker(path, project string) (*UsageTracker, error) {
+	t := &UsageTracker{
+		path:    path,
+		project: project,
+		stored: storedUsage{
+			Projects: map[string]UsageTotals{},
+			Days:     map[string]UsageTotals{},
+		},
+		unpriced: map[string]bool{},
+		now:      time.Now,
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return t, nil
+	}
+	if err != nil {
+		return t, fmt.Errorf("error reading usage: %w", err)
+	}
+	if err := json.Unmarshal(data, &t.stored); err != nil {
+		return t, fmt.Errorf("error decoding usage %s: %w", path, err)
+	}
+	if t.stored.Projects == nil {
+		t.stored.Projects = map[string]UsageTotals{}
+	}
+	if t.stored.Days == nil {
+		t.stored.Days = map[string]UsageTotals{}
+	}
+	return t, nil
+}
+
+// SetProject sets the project requests are counted for
+func (t *UsageTracker) SetProject(project string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.project = project
+}
+
+// SetBudget sets the spending limits
+func (t *UsageTracker) SetBudget(budget Budget) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.budget = budget
+}
+
+// today is the key of the current day in the daily totals
+func (t *UsageTracker) today() string {
+	return t.now().Format("2006-01-02")
+}
+
+// Check returns an error wrapping ErrBudgetExceeded when a budget is spent
+func (t *UsageTracker) Check() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	exceeded := func(name string, limit, spent float64) error {
+		if limit > 0 && spent >= limit {
+			return fmt.Errorf("%w: $%.4f spent of the %s budget of $%g", ErrBudgetExceeded, spent, name, limit)
+		}
+		return nil
+	}
+	if err := exceeded("session", t.budget.Session, t.session.Cost); err != nil {
+		return err
+	}
+	if err := exceeded("project", t.budget.Project, t.stored.Projects[t.project].Cost); err != nil {
+		return err
+	}
+	return exceeded("daily", t.budget.Daily, t.stored.Days[t.today()].Cost)
+}
+
+// Record counts a request and saves the totals
+func (t *UsageTracker) Record(usage Usage, cost float64) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.recordLocked(usage, cost)
+}
+
+// RecordUnpriced counts a request to a model without known prices, whose
+// cost is counted as zero, and saves the totals. Budgets do not limit such
+// requests, so a warning is logged the first time a model is used while a
+// budget is set.
+func (t *UsageTracker) RecordUnpriced(modelID string, usage Usage) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if !t.unpriced[modelID] {
+		t.unpriced[modelID] = true
+		if t.budget != (Budget{}) {
+			log.Printf("Warning: the prices of model %q are unknown, so its requests are not counted against the budget", modelID)
+		}
+	}
+	return t.recordLocked(usage, 0)
+}
+
+// recordLocked counts a request and saves the totals
+func (t *UsageTracker) recordLocked(usage Usage, cost float64) error {
+	t.session.add(usage, cost)
+	project := t.stored.Projects[t.project]
+	project.add(usage, cost)
+	t.stored.Projects[t.project] = project
+	day := t.stored.Days[t.today()]
+	day.add(usage, cost)
+	t.stored.Days[t.today()] = day
+
+	data, err := json.MarshalIndent(t.stored, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding usage: %w", err)
+	}
+	return writeFileAtomic(t.path, data)
+}
+
+// Report returns the totals counted so far
+func (t *UsageTracker) Report() UsageReport {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	days := make(map[string]UsageTotals, len(t.stored.Days))
+	for day, totals := range t.stored.Days {
+		days[day] = totals
+	}
+	var unpriced []string
+	for modelID := range t.unpriced {
+		unpriced = append(unpriced, modelID)
+	}
+	sort.Strings(unpriced)
+	return UsageReport{
+		Project:  t.project,
+		Session:  t.session,
+		Today:    t.stored.Days[t.today()],
+		Total:    t.stored.Projects[t.project],
+		Days:     days,
+		Budget:   t.budget,
+		Unpriced: unpriced,
+	}
+}
+
+// writeFileAtomic writes data next to path and renames it into place, so
+// that a crash never leaves a partial file
+func writeFileAtomic(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// MeteredProvider is a Provider counting the usage and cost of the requests
+// made to another one with a UsageTracker, and refusing them once a budget
+// is spent. Costs are computed from the pricing of the models listed by
+// the provider, or from knownPrices, unless the API reports them. Requests
+// to models of unknown price count as free, and are listed as unpriced in
+// the usage report.
+type MeteredProvider struct {
+	Provider Provider
+	Tracker  *UsageTracker
+
+	models modelCache
+}
+
+// NewMeteredProvider creates a provider metering the requests made to
+// provider
+func NewMeteredProvider(provider Provider, tracker *UsageTracker) *MeteredProvider {
+	return &MeteredProvider{Provider: provider, Tracker: tracker}
+}
+
+// record counts the usage of a response
+func (m *MeteredProvider) record(ctx context.Context, resp *ChatCompletionResponse, options []any) {
+	if resp == nil || resp.Usage == nil {
+		return
+	}
+	usage := *resp.Usage
+	modelID := resp.Model
+	if modelID == "" {
+		modelID = newChatRequest("", nil, options).Model
+	}
+
+	var err error
+	if usage.Cost > 0 {
+		err = m.Tracker.Record(usage, usage.Cost)
+	} else if model := m.pricedModel(ctx, modelID); model != nil {
+		err = m.Tracker.Record(usage, model.Cost(usage))
+	} else {
+		err = m.Tracker.RecordUnpriced(modelID, usage)
+	}
+	if err != nil {
+		log.Printf("Warning: Could not save usage: %v", err)
+	}
+}
+
+// pricedModel returns the description of a model with its prices, as
+// listed by the provider or else as known, or nil when they are unknown
+func (m *MeteredProvider) pricedModel(ctx context.Context, modelID string) *Model {
+	if model := m.models.lookup(ctx, modelID, m.Provider.GetAvailableModelsContext); model != nil && model.Priced() {
+		return model
+	}
+	model := &Model{ID: modelID}
+	if setKnownPrice(model) {
+		return model
+	}
+	return nil
+}
+
+// GetChatCompletionContext forwards the request unless a budget is spent,
+// and counts its usage
+func (m *MeteredProvider) GetChatCompletionContext(ctx context.Context, messages []ChatMessage, options ...any) (*ChatCompletionResponse, error) {
+	if err := m.Tracker.Check(); err != nil {
+		return nil, err
+	}
+	resp, err := m.Provider.GetChatCompletionContext(ctx, messages, options...)
+	m.record(ctx, resp, options)
+	return resp, err
+}
+
+// StreamChatCompletion forwards the request unless a budget is spent, and
+// counts its usage
+func (m *MeteredProvider) StreamChatCompletion(ctx context.Context, messages []ChatMessage, onToken func(token string), options ...any) (*ChatCompletionResponse, error) {
+	if err := m.Tracker.Check(); err != nil {
+		return nil, err
+	}
+	resp, err := m.Provider.StreamChatCompletion(ctx, messages, onToken, options...)
+	m.record(ctx, resp, options)
+	return resp, err
+}
+
+// GetAvailableModelsContext lists the models of the metered provider
+func (m *MeteredProvider) GetAvailableModelsContext(ctx context.Context) ([]Model, error) {
+	return m.Provider.GetAvailableModelsContext(ctx)
+}
+
+// SetModel sets the model of the metered provider
+func (m *MeteredProvider) SetModel(modelID string) {
+	m.Provider.SetModel(modelID)
+}
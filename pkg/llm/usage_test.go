@@ -0,0 +1,147 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"math"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newTestTracker creates a tracker keeping its totals in a temporary
+// directory, with a clock set to now
+func newTestTracker(t *testing.T, now *time.Time) *UsageTracker {
+	t.Helper()
+
+	tracker, err := NewUsageTracker(filepath.Join(t.TempDir(), UsageFile), "project")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracker.now = func() time.Time { return *now }
+	return tracker
+}
+
+func TestUsageDailyBudget(t *testing.T) {
+	now := time.Date(2026, 3, 1, 23, 0, 0, 0, time.Local)
+	tracker := newTestTracker(t, &now)
+	tracker.SetBudget(Budget{Daily: 1})
+
+	usage := Usage{PromptTokens: 100, CompletionTokens: 50}
+	for i := 0; i < 2; i++ {
+		if err := tracker.Check(); err != nil {
+			t.Fatalf("request %d refused: %v", i+1, err)
+		}
+		if err := tracker.Record(usage, 0.5); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tracker.Check(); !errors.Is(err, ErrBudgetExceeded) {
+		t.Fatalf("got %v, want ErrBudgetExceeded once the daily budget is spent", err)
+	}
+
+	// The next day starts with a new budget, and the project keeps its total
+	now = now.Add(2 * time.Hour)
+	if err := tracker.Check(); err != nil {
+		t.Fatalf("refused on the next day: %v", err)
+	}
+	if err := tracker.Record(usage, 0.25); err != nil {
+		t.Fatal(err)
+	}
+	report := tracker.Report()
+	if report.Today.Cost != 0.25 || report.Today.Requests != 1 {
+		t.Errorf("today's totals %+v, want one request for $0.25", report.Today)
+	}
+	if report.Days["2026-03-01"].Cost != 1 || report.Days["2026-03-02"].Cost != 0.25 {
+		t.Errorf("daily totals %+v", report.Days)
+	}
+	if report.Total.Cost != 1.25 || report.Total.Requests != 3 {
+		t.Errorf("project totals %+v, want three requests for $1.25", report.Total)
+	}
+
+	// The totals are kept across sessions
+	loaded, err := NewUsageTracker(tracker.path, "project")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total := loaded.Report().Total; total.Cost != 1.25 {
+		t.Errorf("saved project total %+v, want $1.25", total)
+	}
+}
+
+func TestMeteredProviderBudget(t *testing.T) {
+	now := time.Now()
+	tracker := newTestTracker(t, &now)
+	tracker.SetBudget(Budget{Session: 0.01})
+
+	fake := NewFakeProvider(FakeRule{Response: "answer"})
+	priced := Model{ID: "fake"}
+	priced.Pricing.Prompt = "0.001"
+	priced.Pricing.Completion = "0.002"
+	fake.Models = []Model{priced}
+	metered := NewMeteredProvider(fake, tracker)
+
+	messages := []ChatMessage{{Role: "user", Content: "question"}}
+	if _, err := metered.GetChatCompletionContext(context.Background(), messages); err != nil {
+		t.Fatalf("first request: %v", err)
+	}
+	if cost := tracker.Report().Session.Cost; cost < 0.01 {
+		t.Fatalf("first request cost $%g, want it to spend the budget", cost)
+	}
+	if _, err := metered.GetChatCompletionContext(context.Background(), messages); !errors.Is(err, ErrBudgetExceeded) {
+		t.Fatalf("got %v, want ErrBudgetExceeded", err)
+	}
+	if _, err := metered.StreamChatCompletion(context.Background(), messages, nil); !errors.Is(err, ErrBudgetExceeded) {
+		t.Fatalf("got %v, want ErrBudgetExceeded for a streamed request", err)
+	}
+	if n := len(fake.Transcript()); n != 1 {
+		t.Errorf("%d requests reached the provider, want 1", n)
+	}
+}
+
+func TestMeteredProviderPrices(t *testing.T) {
+	now := time.Now()
+	tracker := newTestTracker(t, &now)
+	tracker.SetBudget(Budget{Session: 100})
+	metered := NewMeteredProvider(NewFakeProvider(FakeRule{Response: "answer"}), tracker)
+	messages := []ChatMessage{{Role: "user", Content: "question"}}
+
+	// Models the provider does not price are priced when they are known
+	resp, err := metered.GetChatCompletionContext(context.Background(), messages, map[string]interface{}{"model": "claude-sonnet-4-5"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := float64(resp.Usage.PromptTokens)*3e-6 + float64(resp.Usage.CompletionTokens)*15e-6
+	if cost := tracker.Report().Session.Cost; math.Abs(cost-want) > 1e-12 {
+		t.Errorf("cost $%g, want $%g", cost, want)
+	}
+	if unpriced := tracker.Report().Unpriced; len(unpriced) != 0 {
+		t.Errorf("known model reported unpriced: %v", unpriced)
+	}
+
+	// Others count as free and are reported
+	if _, err := metered.GetChatCompletionContext(context.Background(), messages, map[string]interface{}{"model": "llama3:8b"}); err != nil {
+		t.Fatal(err)
+	}
+	report := tracker.Report()
+	if report.Session.Requests != 2 || math.Abs(report.Session.Cost-want) > 1e-12 {
+		t.Errorf("session totals %+v", report.Session)
+	}
+	if len(report.Unpriced) != 1 || report.Unpriced[0] != "llama3:8b" {
+		t.Errorf("unpriced models %v, want llama3:8b", report.Unpriced)
+	}
+}
+
+func TestMeteredProviderListsWithContext(t *testing.T) {
+	now := time.Now()
+	metered := NewMeteredProvider(NewFakeProvider(), newTestTracker(t, &now))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := metered.GetAvailableModelsContext(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v, want the listing to stop with its context", err)
+	}
+	if models, err := metered.GetAvailableModelsContext(context.Background()); err != nil || len(models) != 1 {
+		t.Errorf("got %v, %v, want the fake's model", models, err)
+	}
+}
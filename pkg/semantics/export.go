@@ -0,0 +1,240 @@
+package semantics
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Graph export formats
+const (
+	FormatDOT     = "dot"
+	FormatGraphML = "graphml"
+	FormatMermaid = "mermaid"
+)
+
+// ExportFormats lists the formats accepted by Export
+var ExportFormats = []string{FormatDOT, FormatGraphML, FormatMermaid}
+
+// Export writes the graph in one of the ExportFormats. With a nil query
+// the whole model is exported; otherwise the entities returned by the
+// query are, so "package=auth follow:* reverse:*" exports a package and
+// its neighbours. Every relation between exported entities is included.
+func (m *Model) Export(w io.Writer, format string, q *Query) error {
+	var entities []*Entity
+	if q == nil {
+		entities = m.ListEntities(EntityFilter{})
+	} else {
+		entities, _ = m.Query(q)
+	}
+
+	m.mu.RLock()
+	included := map[*Entity]bool{}
+	for _, entity := range entities {
+		included[entity] = true
+	}
+	var relations []*Relation
+	for _, relation := range m.relations {
+		if included[relation.From] && included[relation.To] {
+			relations = append(relations, relation)
+		}
+	}
+	m.mu.RUnlock()
+
+	sort.SliceStable(relations, func(i, j int) bool {
+		a, b := relations[i], relations[j]
+		if a.From.ID != b.From.ID {
+			return a.From.ID < b.From.ID
+		}
+		if a.Type != b.Type {
+			return a.Type < b.Type
+		}
+		return a.To.ID < b.To.ID
+	})
+
+	g := &graph{entities: entities, relations: relations, ids: map[*Entity]string{}}
+	for i, entity := range entities {
+		g.ids[entity] = "n" + strconv.Itoa(i)
+	}
+
+	out := bufio.NewWriter(w)
+	switch format {
+	case FormatDOT:
+		g.writeDOT(out)
+	case FormatGraphML:
+		g.writeGraphML(out)
+	case FormatMermaid:
+		g.writeMermaid(out)
+	default:
+		return fmt.Errorf("unknown export format %q, expected one of %s", format, strings.Join(ExportFormats, ", "))
+	}
+	return out.Flush()
+}
+
+// graph is the part of the model being exported. Entities are given short
+// node IDs, as entity IDs are not valid identifiers in every format.
+type graph struct {
+	entities  []*Entity
+	relations []*Relation
+	ids       map[*Entity]string
+}
+
+// entityLabel is the text shown for an entity: its name, qualified by the
+// receiver for methods and fields
+func entityLabel(entity *Entity) string {
+	if receiver, ok := entity.Properties["receiverID"].(string); ok && receiver != "" {
+		return receiver[strings.LastIndex(receiver, ".")+1:] + "." + entity.Name
+	}
+	if entity.Type == TypeField {
+		parts := strings.Split(entity.ID, ".")
+		if len(parts) >= 2 {
+			return parts[len(parts)-2] + "." + entity.Name
+		}
+	}
+	return entity.Name
+}
+
+// dotShapes gives each entity type a distinct shape in DOT output
+var dotShapes = map[string]string{
+	TypePackage:   "folder",
+	TypeStruct:    "box",
+	TypeInterface: "component",
+	TypeNamed:     "box",
+	TypeFunction:  "ellipse",
+	TypeMethod:    "ellipse",
+	TypeField:     "plaintext",
+	TypeVariable:  "note",
+	TypeConstant:  "note",
+}
+
+// writeDOT writes the graph for Graphviz, grouping entities by package
+func (g *graph) writeDOT(w *bufio.Writer) {
+	w.WriteString("digraph semantics {\n")
+	w.WriteString("  rankdir=LR;\n")
+	w.WriteString("  node [fontname=\"Helvetica\", fontsize=10];\n")
+	w.WriteString("  edge [fontname=\"Helvetica\", fontsize=8];\n")
+
+	var packages []string
+	byPackage := map[string][]*Entity{}
+	for _, entity := range g.entities {
+		pkg, _ := entity.Properties["package"].(string)
+		if entity.Type == TypePackage {
+			pkg = ""
+		}
+		if _, ok := byPackage[pkg]; !ok {
+			packages = append(packages, pkg)
+		}
+		byPackage[pkg] = append(byPackage[pkg], entity)
+	}
+	sort.Strings(packages)
+
+	for i, pkg := range packages {
+		indent := "  "
+		if pkg != "" {
+			fmt.Fprintf(w, "  subgraph cluster_%d {\n    label=%s;\n", i, dotQuote(pkg))
+			indent = "    "
+		}
+		for _, entity := range byPackage[pkg] {
+			shape := dotShapes[entity.Type]
+			if shape == "" {
+				shape = "box"
+			}
+			fmt.Fprintf(w, "%s%s [label=%s, shape=%s, tooltip=%s];\n",
+				indent, g.ids[entity], dotQuote(entityLabel(entity)), shape, dotQuote(entity.ID))
+		}
+		if pkg != "" {
+			w.WriteString("  }\n")
+		}
+	}
+
+	for _, relation := range g.relations {
+		fmt.Fprintf(w, "  %s -> %s [label=%s];\n", g.ids[relation.From], g.ids[relation.To], dotQuote(relation.Type))
+	}
+	w.WriteString("}\n")
+}
+
+// dotQuote quotes a string as a DOT ID
+func dotQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s) + `"`
+}
+
+// writeGraphML writes the graph as GraphML, with the entity and relation
+// details as data attributes
+func (g *graph) writeGraphML(w *bufio.Writer) {
+	w.WriteString(xml.Header)
+	w.WriteString(`<graphml xmlns="http://graphml.graphdrawing.org/xmlns">` + "\n")
+	for _, key := range []struct{ id, domain, name string }{
+		{"d0", "node", "entity"},
+		{"d1", "node", "type"},
+		{"d2", "node", "name"},
+		{"d3", "node", "description"},
+		{"d4", "node", "file"},
+		{"d5", "edge", "type"},
+	} {
+		fmt.Fprintf(w, `  <key id="%s" for="%s" attr.name="%s" attr.type="string"/>`+"\n", key.id, key.domain, key.name)
+	}
+	w.WriteString(`  <graph id="semantics" edgedefault="directed">` + "\n")
+
+	for _, entity := range g.entities {
+		fmt.Fprintf(w, `    <node id="%s">`+"\n", g.ids[entity])
+		writeGraphMLData(w, "d0", entity.ID)
+		writeGraphMLData(w, "d1", entity.Type)
+		writeGraphMLData(w, "d2", entity.Name)
+		if entity.Description != "" {
+			writeGraphMLData(w, "d3", entity.Description)
+		}
+		if file, _ := entity.Properties["file"].(string); file != "" {
+			writeGraphMLData(w, "d4", file)
+		}
+		w.WriteString("    </node>\n")
+	}
+	for i, relation := range g.relations {
+		fmt.Fprintf(w, `    <edge id="e%d" source="%s" target="%s">`+"\n", i, g.ids[relation.From], g.ids[relation.To])
+		writeGraphMLData(w, "d5", relation.Type)
+		w.WriteString("    </edge>\n")
+	}
+	w.WriteString("  </graph>\n</graphml>\n")
+}
+
+// writeGraphMLData writes one escaped data element
+func writeGraphMLData(w *bufio.Writer, key, value string) {
+	fmt.Fprintf(w, `      <data key="%s">`, key)
+	xml.EscapeText(w, []byte(value))
+	w.WriteString("</data>\n")
+}
+
+// writeMermaid writes the graph as a Mermaid flowchart, which renders in
+// Markdown documents
+func (g *graph) writeMermaid(w *bufio.Writer) {
+	w.WriteString("flowchart LR\n")
+	for _, entity := range g.entities {
+		label := mermaidQuote(entityLabel(entity))
+		switch entity.Type {
+		case TypePackage:
+			fmt.Fprintf(w, "  %s[/%s/]\n", g.ids[entity], label)
+		case TypeInterface:
+			fmt.Fprintf(w, "  %s{{%s}}\n", g.ids[entity], label)
+		case TypeFunction, TypeMethod:
+			fmt.Fprintf(w, "  %s(%s)\n", g.ids[entity], label)
+		default:
+			fmt.Fprintf(w, "  %s[%s]\n", g.ids[entity], label)
+		}
+	}
+	for _, relation := range g.relations {
+		arrow := "-->"
+		if relation.Type == RelImplements || relation.Type == RelEmbeds {
+			arrow = "-.->"
+		}
+		fmt.Fprintf(w, "  %s %s|%s| %s\n", g.ids[relation.From], arrow, relation.Type, g.ids[relation.To])
+	}
+}
+
+// mermaidQuote quotes a label, using Mermaid's entity codes for the
+// characters that would end it
+func mermaidQuote(s string) string {
+	return `"` + strings.NewReplacer(`"`, "#quot;", "\n", " ").Replace(s) + `"`
+}
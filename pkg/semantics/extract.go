@@ -0,0 +1,890 @@
+package semantics
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/printer"
+	"go/token"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Entity types produced from Go code
+const (
+	TypePackage   = "Package"
+	TypeStruct    = "Struct"
+	TypeInterface = "Interface"
+	TypeNamed     = "Type"
+	TypeFunction  = "Function"
+	TypeMethod    = "Method"
+	TypeField     = "Field"
+	TypeVariable  = "Variable"
+	TypeConstant  = "Constant"
+)
+
+// Relation types produced from Go code
+const (
+	RelContains   = "Contains"
+	RelCalls      = "Calls"
+	RelImplements = "Implements"
+	RelEmbeds     = "Embeds"
+	RelUses       = "Uses"
+	RelReturns    = "Returns"
+)
+
+// goFile is implemented by trees that can provide the Go file they
+// describe, such as the ast package's Node
+type goFile interface {
+	GoFile() (*token.FileSet, *ast.File, error)
+}
+
+// reference is a relation whose target is only known by name. References
+// are resolved against the whole model, so a call into a file that is
+// indexed later is picked up once that file arrives.
+type reference struct {
+	kind       string
+	from       string
+	candidates []string
+}
+
+// fileFacts records what was extracted from one file so that it can be
+// replaced when the file changes. The size and modification time of the
+// file at the time tell Refresh whether it changed since.
+type fileFacts struct {
+	pkg        string
+	entities   []string
+	references []reference
+	modTime    int64
+	size       int64
+}
+
+// changeSet collects the files and entities touched by an update, so that
+// only the relations depending on them are resolved again
+type changeSet struct {
+	files    map[string]bool
+	entities map[string]bool
+}
+
+func newChangeSet() *changeSet {
+	return &changeSet{files: map[string]bool{}, entities: map[string]bool{}}
+}
+
+// ErrNoFilename is returned by UpdateFromAST for a file without a name, as
+// the model keeps what it extracts per file
+var ErrNoFilename = errors.New("file has no name")
+
+// extractor collects entities, relations and references from one file.
+// Entity IDs are qualified by path, which is the import path of the file's
+// package as given by fileQualifier or the Loader, so that packages of the
+// same name in different directories never share IDs.
+type extractor struct {
+	fset     *token.FileSet
+	filename string
+	pkg      string
+	path     string
+	imports  map[string]string
+
+	entities   []*Entity
+	relations  []*Relation
+	references []reference
+	seen       map[string]bool
+}
+
+// UpdateFromAST updates the semantic model from a tree that can rebuild
+// the Go file it describes, such as an ast.Node. Everything previously
+// extracted from the same file is replaced, so calling it again after a
+// file changed keeps the model current. A *go/ast.File carries no name
+// without its file set, so ErrNoFilename is returned for it; use
+// UpdateFromFile instead.
+func (m *Model) UpdateFromAST(node interface{}) error {
+	switch n := node.(type) {
+	case *ast.File:
+		return ErrNoFilename
+	case goFile:
+		fset, file, err := n.GoFile()
+		if err != nil {
+			return err
+		}
+		return m.UpdateFromFile(fset, file)
+	}
+	return errors.New("unsupported node type for semantic extraction")
+}
+
+// UpdateFromFile updates the semantic model from a Go file parsed into
+// fset. The file must have a name, which identifies it in the model. Only
+// the relations depending on the file are resolved again.
+func (m *Model) UpdateFromFile(fset *token.FileSet, file *ast.File) error {
+	if file == nil || file.Name == nil {
+		return errors.New("file has no package clause")
+	}
+
+	filename := ""
+	if tf := fset.File(file.Pos()); tf != nil {
+		filename = tf.Name()
+	}
+	if filename == "" {
+		return ErrNoFilename
+	}
+
+	e := newExtractor(fset, filename, file.Name.Name, fileQualifier(filename, file.Name.Name))
+	e.extractFile(file)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	changes := newChangeSet()
+	m.replaceFileLocked(e, changes)
+	m.resolveChangesLocked(changes)
+	return nil
+}
+
+// fileQualifier returns the path that qualifies the IDs of the entities of
+// a file: the import path of its directory when the directory is in a
+// module, as the Loader gives it, or else the directory. A file named
+// without a directory is qualified by its package name alone.
+func fileQualifier(filename, pkg string) string {
+	if filepath.Dir(filename) == "." {
+		return pkg
+	}
+	dir, err := filepath.Abs(filepath.Dir(filename))
+	if err != nil {
+		return pkg
+	}
+	for root := dir; ; {
+		if modulePath, err := readModulePath(filepath.Join(root, "go.mod")); err == nil {
+			rel, err := filepath.Rel(root, dir)
+			if err != nil || rel == "." {
+				return modulePath
+			}
+			return path.Join(modulePath, filepath.ToSlash(rel))
+		}
+		parent := filepath.Dir(root)
+		if parent == root {
+			return filepath.ToSlash(dir)
+		}
+		root = parent
+	}
+}
+
+// readModulePath returns the module path declared by a go.mod file
+func readModulePath(gomod string) (string, error) {
+	data, err := os.ReadFile(gomod)
+	if err != nil {
+		return "", err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if fields := strings.Fields(line); len(fields) >= 2 && fields[0] == "module" {
+			return strings.Trim(fields[1], `"`), nil
+		}
+	}
+	return "", fmt.Errorf("%s has no module directive", gomod)
+}
+
+// newExtractor prepares the extraction of one file of a package
+func newExtractor(fset *token.FileSet, filename, pkg, path string) *extractor {
+	return &extractor{
+		fset:     fset,
+		filename: filename,
+		pkg:      pkg,
+		path:     path,
+		imports:  map[string]string{},
+		seen:     map[string]bool{},
+	}
+}
+
+// replaceFileLocked replaces everything known about a file with what the
+// extractor found in it and records the change. References are left for
+// resolveChangesLocked.
+func (m *Model) replaceFileLocked(e *extractor, changes *changeSet) {
+	filename := e.filename
+	m.removeFileLocked(filename, changes)
+	changes.files[filename] = true
+
+	facts := &fileFacts{pkg: packageID(e.path), references: e.references}
+	superseded := map[*Entity]*Entity{}
+	for _, entity := range e.entities {
+		existing, exists := m.entities[entity.ID]
+		switch {
+		case exists && entity.Type == TypePackage:
+			// Packages are shared by all of their files
+			entity = existing
+		case exists && m.ingested[entity.ID]:
+			// The code now declares what was only described before
+			superseded[existing] = entity
+			delete(m.ingested, entity.ID)
+			fallthrough
+		default:
+			entity.Relations = nil
+			m.entities[entity.ID] = entity
+		}
+		facts.entities = append(facts.entities, entity.ID)
+		changes.addEntity(entity)
+	}
+	for _, relation := range e.relations {
+		relation.From = m.entities[relation.From.ID]
+		relation.To = m.entities[relation.To.ID]
+		m.addRelationLocked(relation)
+	}
+	m.repointLocked(superseded)
+	if info, err := os.Stat(filename); err == nil && filename != "" {
+		facts.modTime, facts.size = info.ModTime().UnixNano(), info.Size()
+	}
+	m.files[filename] = facts
+	m.indexReferencesLocked(filename, facts, true)
+}
+
+// addEntity records that an entity changed. A method also changes the
+// method set of its receiver.
+func (c *changeSet) addEntity(entity *Entity) {
+	c.entities[entity.ID] = true
+	if receiver, _ := entity.Properties["receiverID"].(string); receiver != "" {
+		c.entities[receiver] = true
+	}
+}
+
+// repointLocked moves the relations of replaced entities to the entities
+// replacing them. Relations the replacements already have are dropped.
+func (m *Model) repointLocked(replaced map[*Entity]*Entity) {
+	if len(replaced) == 0 {
+		return
+	}
+
+	var moved []*Relation
+	for _, relation := range m.relations {
+		_, from := replaced[relation.From]
+		_, to := replaced[relation.To]
+		if from || to {
+			moved = append(moved, relation)
+		}
+	}
+	m.dropRelationsLocked(func(r *Relation) bool {
+		_, from := replaced[r.From]
+		_, to := replaced[r.To]
+		return from || to
+	})
+
+	for _, relation := range moved {
+		if entity, ok := replaced[relation.From]; ok {
+			relation.From = entity
+		}
+		if entity, ok := replaced[relation.To]; ok {
+			relation.To = entity
+		}
+		if relation.From != relation.To {
+			m.addRelationLocked(relation)
+		}
+	}
+}
+
+// RemoveFile drops everything that was extracted from a file
+func (m *Model) RemoveFile(filename string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	changes := newChangeSet()
+	m.removeFileLocked(filename, changes)
+	m.resolveChangesLocked(changes)
+}
+
+// removeFileLocked drops the entities and relations extracted from a file
+// and records the change. A package entity is kept while other files of
+// the package remain.
+func (m *Model) removeFileLocked(filename string, changes *changeSet) {
+	facts, ok := m.files[filename]
+	if !ok {
+		return
+	}
+	delete(m.files, filename)
+	m.indexReferencesLocked(filename, facts, false)
+	changes.files[filename] = true
+
+	removed := map[*Entity]bool{}
+	for _, id := range facts.entities {
+		entity, ok := m.entities[id]
+		if !ok {
+			continue
+		}
+		changes.addEntity(entity)
+		if entity.Type == TypePackage && m.packageInUseLocked(id) {
+			continue
+		}
+		removed[entity] = true
+		delete(m.entities, id)
+	}
+
+	m.dropRelationsLocked(func(r *Relation) bool {
+		return removed[r.From] || removed[r.To] || r.Metadata["file"] == filename
+	})
+}
+
+// packageInUseLocked reports whether any indexed file belongs to a package
+func (m *Model) packageInUseLocked(id string) bool {
+	for _, facts := range m.files {
+		if facts.pkg == id {
+			return true
+		}
+	}
+	return false
+}
+
+// indexReferencesLocked adds the references of a file to, or removes them
+// from, the index of files referring to each entity
+func (m *Model) indexReferencesLocked(filename string, facts *fileFacts, add bool) {
+	for _, ref := range facts.references {
+		for _, id := range append([]string{ref.from}, ref.candidates...) {
+			if add {
+				if m.referrers[id] == nil {
+					m.referrers[id] = map[string]bool{}
+				}
+				m.referrers[id][filename] = true
+			} else if files := m.referrers[id]; files != nil {
+				delete(files, filename)
+				if len(files) == 0 {
+					delete(m.referrers, id)
+				}
+			}
+		}
+	}
+}
+
+// resolveLocked recomputes every relation that depends on more than one
+// file, as is needed after the model was loaded as a whole
+func (m *Model) resolveLocked() {
+	all := newChangeSet()
+	for filename := range m.files {
+		all.files[filename] = true
+	}
+	for id := range m.entities {
+		all.entities[id] = true
+	}
+	m.resolveChangesLocked(all)
+}
+
+// resolveChangesLocked recomputes the relations that depend on more than
+// one file and may have been affected by changes: the references of the
+// changed files and of the files referring to a changed entity, and the
+// interface implementations of changed types and interfaces.
+func (m *Model) resolveChangesLocked(changes *changeSet) {
+	files := map[string]bool{}
+	for filename := range changes.files {
+		if _, ok := m.files[filename]; ok {
+			files[filename] = true
+		}
+	}
+	for id := range changes.entities {
+		for filename := range m.referrers[id] {
+			files[filename] = true
+		}
+	}
+
+	m.dropRelationsLocked(func(r *Relation) bool {
+		if r.Metadata["resolved"] != true || r.Metadata["inferred"] == true {
+			return false
+		}
+		filename, _ := r.Metadata["file"].(string)
+		return files[filename]
+	})
+
+	for filename := range files {
+		for _, ref := range m.files[filename].references {
+			from, ok := m.entities[ref.from]
+			if !ok {
+				continue
+			}
+			for _, candidate := range ref.candidates {
+				if to, ok := m.entities[candidate]; ok && to != from {
+					m.addRelationLocked(&Relation{
+						Type:     ref.kind,
+						From:     from,
+						To:       to,
+						Metadata: map[string]interface{}{"file": filename, "resolved": true},
+					})
+					break
+				}
+			}
+		}
+	}
+
+	m.resolveImplementsLocked(changes.entities)
+}
+
+// resolveImplementsLocked relates named types to the interfaces whose
+// methods they have, for every pair where the type or the interface is
+// among changed. Methods are matched by name only, as the syntax does not
+// carry enough information to compare signatures; these relations are
+// marked as inferred, and pairs of entities loaded by the type-checking
+// Loader are left to it.
+func (m *Model) resolveImplementsLocked(changed map[string]bool) {
+	var types, ifaces, changedTypes, changedIfaces []*Entity
+	for _, entity := range m.entities {
+		switch entity.Type {
+		case TypeStruct, TypeNamed:
+			types = append(types, entity)
+			if changed[entity.ID] {
+				changedTypes = append(changedTypes, entity)
+			}
+		case TypeInterface:
+			ifaces = append(ifaces, entity)
+			if changed[entity.ID] {
+				changedIfaces = append(changedIfaces, entity)
+			}
+		}
+	}
+	if len(changedTypes) == 0 && len(changedIfaces) == 0 {
+		return
+	}
+
+	m.dropRelationsLocked(func(r *Relation) bool {
+		return r.Metadata["inferred"] == true && (changed[r.From.ID] || changed[r.To.ID])
+	})
+
+	for _, typ := range changedTypes {
+		for _, iface := range ifaces {
+			m.inferImplementsLocked(typ, iface)
+		}
+	}
+	for _, iface := range changedIfaces {
+		for _, typ := range types {
+			m.inferImplementsLocked(typ, iface)
+		}
+	}
+}
+
+// inferImplementsLocked relates typ to iface when typ has methods with the
+// names of all methods of iface
+func (m *Model) inferImplementsLocked(typ, iface *Entity) {
+	required, _ := iface.Properties["methods"].([]string)
+	if len(required) == 0 {
+		return
+	}
+	// Type-checked entities get exact relations from the Loader
+	if typ.Properties["typed"] == true && iface.Properties["typed"] == true {
+		return
+	}
+
+	have := map[string]bool{}
+	for _, relation := range typ.Relations {
+		if relation.Type == RelContains && relation.To.Type == TypeMethod {
+			have[relation.To.Name] = true
+		}
+	}
+	for _, name := range required {
+		if !have[name] {
+			return
+		}
+	}
+	m.addRelationLocked(&Relation{
+		Type:     RelImplements,
+		From:     typ,
+		To:       iface,
+		Metadata: map[string]interface{}{"file": typ.Properties["file"], "resolved": true, "inferred": true},
+	})
+}
+
+// addRelationLocked records a relation on the model and on its source,
+// unless the same relation between the same entities is already known
+func (m *Model) addRelationLocked(relation *Relation) {
+	for _, existing := range relation.From.Relations {
+		if existing.Type == relation.Type && existing.To == relation.To {
+			return
+		}
+	}
+	m.relations = append(m.relations, relation)
+	relation.From.Relations = append(relation.From.Relations, relation)
+}
+
+// dropRelationsLocked removes the relations matching drop from the model
+// and from the entities they start at
+func (m *Model) dropRelationsLocked(drop func(*Relation) bool) {
+	kept := m.relations[:0]
+	touched := map[*Entity]bool{}
+	for _, relation := range m.relations {
+		if drop(relation) {
+			touched[relation.From] = true
+			continue
+		}
+		kept = append(kept, relation)
+	}
+	for i := len(kept); i < len(m.relations); i++ {
+		m.relations[i] = nil
+	}
+	m.relations = kept
+
+	for entity := range touched {
+		var relations []*Relation
+		for _, relation := range entity.Relations {
+			if !drop(relation) {
+				relations = append(relations, relation)
+			}
+		}
+		entity.Relations = relations
+	}
+}
+
+// extractFile collects the entities of a file
+func (e *extractor) extractFile(file *ast.File) {
+	pkg := e.add(&Entity{
+		ID:   packageID(e.path),
+		Type: TypePackage,
+		Name: e.pkg,
+		Properties: map[string]interface{}{
+			"path": e.path,
+		},
+	}, file)
+	delete(pkg.Properties, "file")
+	delete(pkg.Properties, "line")
+
+	for _, imp := range file.Imports {
+		importPath, err := strconv.Unquote(imp.Path.Value)
+		if err != nil {
+			continue
+		}
+		name := path.Base(importPath)
+		if imp.Name != nil {
+			name = imp.Name.Name
+		}
+		if name == "_" || name == "." {
+			continue
+		}
+		// Packages qualified by their name alone refer to others by name
+		e.imports[name] = importPath
+		if e.path == e.pkg {
+			e.imports[name] = path.Base(importPath)
+		}
+	}
+
+	for _, decl := range file.Decls {
+		switch d := decl.(type) {
+		case *ast.GenDecl:
+			e.genDecl(pkg, d)
+		case *ast.FuncDecl:
+			e.funcDecl(pkg, d)
+		}
+	}
+}
+
+// genDecl collects the types, variables and constants of a declaration
+func (e *extractor) genDecl(pkg *Entity, decl *ast.GenDecl) {
+	for _, spec := range decl.Specs {
+		switch s := spec.(type) {
+		case *ast.TypeSpec:
+			e.typeSpec(pkg, decl, s)
+
+		case *ast.ValueSpec:
+			kind, entityType := "var", TypeVariable
+			if decl.Tok == token.CONST {
+				kind, entityType = "const", TypeConstant
+			}
+			for _, name := range s.Names {
+				if name.Name == "_" {
+					continue
+				}
+				entity := e.add(&Entity{
+					ID:          kind + ":" + e.path + "." + name.Name,
+					Type:        entityType,
+					Name:        name.Name,
+					Description: docText(s.Doc, decl.Doc),
+					Properties:  map[string]interface{}{},
+				}, name)
+				if s.Type != nil {
+					entity.Properties["type"] = e.source(s.Type)
+					e.typeReferences(RelUses, entity.ID, s.Type)
+				}
+				e.contains(pkg, entity)
+				for _, value := range s.Values {
+					e.bodyReferences(entity.ID, value, nil)
+				}
+			}
+		}
+	}
+}
+
+// typeSpec collects a named type together with its fields or methods
+func (e *extractor) typeSpec(pkg *Entity, decl *ast.GenDecl, spec *ast.TypeSpec) {
+	entity := e.add(&Entity{
+		ID:          typeID(e.path, spec.Name.Name),
+		Type:        TypeNamed,
+		Name:        spec.Name.Name,
+		Description: docText(spec.Doc, decl.Doc),
+		Properties: map[string]interface{}{
+			"underlying": e.source(spec.Type),
+		},
+	}, spec)
+	e.contains(pkg, entity)
+
+	switch t := spec.Type.(type) {
+	case *ast.StructType:
+		entity.Type = TypeStruct
+		for _, field := range t.Fields.List {
+			names := field.Names
+			if len(names) == 0 {
+				// Embedded fields are named after their type
+				e.typeReferences(RelEmbeds, entity.ID, field.Type)
+				if name := baseTypeName(field.Type); name != "" {
+					names = []*ast.Ident{{Name: name, NamePos: field.Pos()}}
+				}
+			}
+			for _, name := range names {
+				f := e.add(&Entity{
+					ID:          "field:" + e.path + "." + spec.Name.Name + "." + name.Name,
+					Type:        TypeField,
+					Name:        name.Name,
+					Description: docText(field.Doc, field.Comment),
+					Properties: map[string]interface{}{
+						"type":     e.source(field.Type),
+						"embedded": len(field.Names) == 0,
+					},
+				}, name)
+				if field.Tag != nil {
+					f.Properties["tag"] = field.Tag.Value
+				}
+				e.contains(entity, f)
+				e.typeReferences(RelUses, f.ID, field.Type)
+			}
+		}
+
+	case *ast.InterfaceType:
+		entity.Type = TypeInterface
+		var methods []string
+		for _, field := range t.Methods.List {
+			if len(field.Names) == 0 {
+				e.typeReferences(RelEmbeds, entity.ID, field.Type)
+				continue
+			}
+			for _, name := range field.Names {
+				methods = append(methods, name.Name)
+				method := e.add(&Entity{
+					ID:          methodID(e.path, spec.Name.Name, name.Name),
+					Type:        TypeMethod,
+					Name:        name.Name,
+					Description: docText(field.Doc, field.Comment),
+					Properties: map[string]interface{}{
+						"signature": "func" + strings.TrimPrefix(e.source(field.Type), "func"),
+						"abstract":  true,
+					},
+				}, name)
+				e.contains(entity, method)
+				if fn, ok := field.Type.(*ast.FuncType); ok {
+					e.signatureReferences(method.ID, fn)
+				}
+			}
+		}
+		entity.Properties["methods"] = methods
+
+	default:
+		e.typeReferences(RelUses, entity.ID, spec.Type)
+	}
+}
+
+// funcDecl collects a function or method and the references in its body
+func (e *extractor) funcDecl(pkg *Entity, decl *ast.FuncDecl) {
+	entity := e.add(&Entity{
+		ID:          "func:" + e.path + "." + decl.Name.Name,
+		Type:        TypeFunction,
+		Name:        decl.Name.Name,
+		Description: docText(decl.Doc),
+		Properties: map[string]interface{}{
+			"signature": e.source(decl.Type),
+		},
+	}, decl.Name)
+
+	receivers := map[string]string{}
+	if decl.Recv != nil && len(decl.Recv.List) > 0 {
+		recv := decl.Recv.List[0]
+		recvType := baseTypeName(recv.Type)
+		entity.ID = methodID(e.path, recvType, decl.Name.Name)
+		entity.Type = TypeMethod
+		entity.Properties["receiver"] = e.source(recv.Type)
+		entity.Properties["receiverID"] = typeID(e.path, recvType)
+		for _, name := range recv.Names {
+			receivers[name.Name] = recvType
+		}
+		// The type may be declared in another file
+		e.references = append(e.references, reference{
+			kind:       RelContains,
+			from:       typeID(e.path, recvType),
+			candidates: []string{methodID(e.path, recvType, decl.Name.Name)},
+		})
+	} else {
+		e.contains(pkg, entity)
+	}
+
+	e.signatureReferences(entity.ID, decl.Type)
+	if decl.Body != nil {
+		e.bodyReferences(entity.ID, decl.Body, receivers)
+	}
+}
+
+// signatureReferences records the types a function takes and returns
+func (e *extractor) signatureReferences(from string, fn *ast.FuncType) {
+	if fn.Params != nil {
+		for _, field := range fn.Params.List {
+			e.typeReferences(RelUses, from, field.Type)
+		}
+	}
+	if fn.Results != nil {
+		for _, field := range fn.Results.List {
+			e.typeReferences(RelReturns, from, field.Type)
+		}
+	}
+}
+
+// typeReferences records a reference from an entity to every named type
+// in a type expression
+func (e *extractor) typeReferences(kind, from string, expr ast.Expr) {
+	ast.Inspect(expr, func(n ast.Node) bool {
+		switch x := n.(type) {
+		case *ast.SelectorExpr:
+			if pkg, ok := x.X.(*ast.Ident); ok {
+				if imported, ok := e.imports[pkg.Name]; ok {
+					e.reference(kind, from, typeID(imported, x.Sel.Name))
+				}
+			}
+			return false
+		case *ast.Ident:
+			e.reference(kind, from, typeID(e.path, x.Name))
+		}
+		return true
+	})
+}
+
+// bodyReferences records the calls and uses of package-level names in a
+// function body or initializer. Local names that shadow package-level ones
+// are not tracked, which is a known limitation of syntactic extraction.
+func (e *extractor) bodyReferences(from string, body ast.Node, receivers map[string]string) {
+	var visit func(ast.Node) bool
+	visit = func(n ast.Node) bool {
+		switch x := n.(type) {
+		case *ast.CallExpr:
+			switch fun := x.Fun.(type) {
+			case *ast.Ident:
+				e.reference(RelCalls, from, "func:"+e.path+"."+fun.Name)
+			case *ast.SelectorExpr:
+				if recv, ok := fun.X.(*ast.Ident); ok {
+					if imported, ok := e.imports[recv.Name]; ok {
+						e.reference(RelCalls, from, "func:"+imported+"."+fun.Sel.Name)
+					} else if recvType, ok := receivers[recv.Name]; ok {
+						e.reference(RelCalls, from, methodID(e.path, recvType, fun.Sel.Name))
+					}
+				}
+			}
+
+		case *ast.CompositeLit:
+			if x.Type != nil {
+				e.typeReferences(RelUses, from, x.Type)
+			}
+
+		case *ast.SelectorExpr:
+			// Only the left-hand side can refer to a package-level name
+			if pkg, ok := x.X.(*ast.Ident); ok {
+				if imported, ok := e.imports[pkg.Name]; ok {
+					e.reference(RelUses, from,
+						typeID(imported, x.Sel.Name),
+						"var:"+imported+"."+x.Sel.Name,
+						"const:"+imported+"."+x.Sel.Name)
+					return false
+				}
+			}
+			ast.Inspect(x.X, visit)
+			return false
+
+		case *ast.Ident:
+			e.reference(RelUses, from,
+				"var:"+e.path+"."+x.Name,
+				"const:"+e.path+"."+x.Name,
+				typeID(e.path, x.Name))
+		}
+		return true
+	}
+	ast.Inspect(body, visit)
+}
+
+// reference records a reference once per source, kind and target
+func (e *extractor) reference(kind, from string, candidates ...string) {
+	key := kind + " " + from + " " + strings.Join(candidates, " ")
+	if e.seen[key] {
+		return
+	}
+	e.seen[key] = true
+	e.references = append(e.references, reference{kind: kind, from: from, candidates: candidates})
+}
+
+// add records an entity found at node, filling in the common properties
+func (e *extractor) add(entity *Entity, node ast.Node) *Entity {
+	if entity.Properties == nil {
+		entity.Properties = map[string]interface{}{}
+	}
+	entity.Properties["package"] = e.pkg
+	entity.Properties["file"] = e.filename
+	entity.Properties["exported"] = token.IsExported(entity.Name)
+	if pos := e.fset.Position(node.Pos()); pos.IsValid() {
+		entity.Properties["line"] = pos.Line
+	}
+	e.entities = append(e.entities, entity)
+	return entity
+}
+
+// contains records that parent contains child
+func (e *extractor) contains(parent, child *Entity) {
+	e.relations = append(e.relations, &Relation{
+		Type:     RelContains,
+		From:     parent,
+		To:       child,
+		Metadata: map[string]interface{}{"file": e.filename},
+	})
+}
+
+// source renders an expression back to source form
+func (e *extractor) source(n ast.Node) string {
+	var buf bytes.Buffer
+	if err := printer.Fprint(&buf, e.fset, n); err != nil {
+		return ""
+	}
+	return buf.String()
+}
+
+// baseTypeName returns the name of the type an expression refers to,
+// ignoring pointers and type arguments
+func baseTypeName(expr ast.Expr) string {
+	switch t := expr.(type) {
+	case *ast.Ident:
+		return t.Name
+	case *ast.StarExpr:
+		return baseTypeName(t.X)
+	case *ast.SelectorExpr:
+		return t.Sel.Name
+	case *ast.IndexExpr:
+		return baseTypeName(t.X)
+	case *ast.IndexListExpr:
+		return baseTypeName(t.X)
+	}
+	return ""
+}
+
+// docText returns the text of the first non-empty comment group
+func docText(groups ...*ast.CommentGroup) string {
+	for _, group := range groups {
+		if text := strings.TrimSpace(group.Text()); text != "" {
+			return text
+		}
+	}
+	return ""
+}
+
+// packageID, typeID and methodID build the IDs of extracted entities
+func packageID(pkg string) string {
+	return "package:" + pkg
+}
+
+func typeID(pkg, name string) string {
+	return "type:" + pkg + "." + name
+}
+
+func methodID(pkg, typ, name string) string {
+	return "method:" + pkg + "." + typ + "." + name
+}
@@ -0,0 +1,178 @@
+package semantics
+
+import (
+	"errors"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// updateFile parses src as filename and extracts it into m
+func updateFile(t *testing.T, m *Model, filename, src string) {
+	t.Helper()
+
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, filename, src, parser.ParseComments)
+	if err != nil {
+		t.Fatalf("parse %s: %v", filename, err)
+	}
+	if err := m.UpdateFromFile(fset, file); err != nil {
+		t.Fatalf("UpdateFromFile(%s): %v", filename, err)
+	}
+}
+
+// hasRelation reports whether the model relates two entities
+func hasRelation(m *Model, relationType, fromID, toID string) bool {
+	from, ok := m.GetEntity(fromID)
+	if !ok {
+		return false
+	}
+	for _, relation := range from.Relations {
+		if relation.Type == relationType && relation.To.ID == toID {
+			return true
+		}
+	}
+	return false
+}
+
+func TestUpdateResolvesAcrossFiles(t *testing.T) {
+	m := NewModel()
+	updateFile(t, m, "a.go", `package p
+func Run() { Helper() }
+`)
+	if hasRelation(m, RelCalls, "func:p.Run", "func:p.Helper") {
+		t.Fatal("call resolved before its target was indexed")
+	}
+
+	updateFile(t, m, "b.go", `package p
+func Helper() {}
+`)
+	if !hasRelation(m, RelCalls, "func:p.Run", "func:p.Helper") {
+		t.Fatal("call not resolved once its target was indexed")
+	}
+
+	// Replacing the target file re-points the call at the new entity
+	updateFile(t, m, "b.go", `package p
+// Helper helps.
+func Helper() {}
+`)
+	if !hasRelation(m, RelCalls, "func:p.Run", "func:p.Helper") {
+		t.Fatal("call lost when its target's file was updated")
+	}
+	helper, _ := m.GetEntity("func:p.Helper")
+	run, _ := m.GetEntity("func:p.Run")
+	for _, relation := range run.Relations {
+		if relation.Type == RelCalls && relation.To != helper {
+			t.Error("call points at a stale entity")
+		}
+	}
+
+	m.RemoveFile("b.go")
+	if hasRelation(m, RelCalls, "func:p.Run", "func:p.Helper") {
+		t.Error("call kept after its target's file was removed")
+	}
+}
+
+func TestUpdateResolvesImplements(t *testing.T) {
+	m := NewModel()
+	updateFile(t, m, "iface.go", `package p
+type Greeter interface{ Greet() string }
+`)
+	updateFile(t, m, "type.go", `package p
+type English struct{}
+`)
+	if hasRelation(m, RelImplements, "type:p.English", "type:p.Greeter") {
+		t.Fatal("type without methods implements the interface")
+	}
+
+	// A method in a third file completes the method set
+	updateFile(t, m, "method.go", `package p
+func (English) Greet() string { return "hello" }
+`)
+	if !hasRelation(m, RelImplements, "type:p.English", "type:p.Greeter") {
+		t.Fatal("implementation not found once the method was indexed")
+	}
+
+	m.RemoveFile("method.go")
+	if hasRelation(m, RelImplements, "type:p.English", "type:p.Greeter") {
+		t.Error("implementation kept after the method was removed")
+	}
+}
+
+func TestUpdateRequiresFilename(t *testing.T) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "", "package p\nfunc F() {}\n", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := NewModel()
+	if err := m.UpdateFromFile(fset, file); !errors.Is(err, ErrNoFilename) {
+		t.Fatalf("got %v, want ErrNoFilename", err)
+	}
+	if err := m.UpdateFromAST(file); !errors.Is(err, ErrNoFilename) {
+		t.Fatalf("UpdateFromAST: got %v, want ErrNoFilename", err)
+	}
+	if _, ok := m.GetEntity("func:p.F"); ok {
+		t.Error("entity of an unnamed file was added")
+	}
+}
+
+// writeFile writes src to name under dir, creating its directory
+func writeFile(t *testing.T, dir, name, src string) string {
+	t.Helper()
+
+	filename := filepath.Join(dir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filename, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+func TestUpdateQualifiesByDirectory(t *testing.T) {
+	dir := t.TempDir()
+	tool := writeFile(t, dir, "cmd/tool/main.go", "package main\nfunc main() {}\n")
+	script := writeFile(t, dir, "main.go", "package main\nfunc main() {}\n")
+
+	m := NewModel()
+	updateFile(t, m, tool, "package main\nfunc main() {}\n")
+	updateFile(t, m, script, "package main\nfunc main() {}\n")
+
+	toolID := "func:" + filepath.ToSlash(filepath.Dir(tool)) + ".main"
+	scriptID := "func:" + filepath.ToSlash(dir) + ".main"
+	for _, id := range []string{toolID, scriptID} {
+		if _, ok := m.GetEntity(id); !ok {
+			t.Fatalf("entity %s missing", id)
+		}
+	}
+
+	m.RemoveFile(script)
+	if _, ok := m.GetEntity(toolID); !ok {
+		t.Error("removing one package main dropped the other's entity")
+	}
+}
+
+func TestUpdateQualifiesByImportPath(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "go.mod", "module example.com/m\n\ngo 1.22\n")
+	auth := writeFile(t, dir, "auth/auth.go", "package auth\nfunc Login() {}\n")
+	api := writeFile(t, dir, "api/api.go", "package api\nimport \"example.com/m/auth\"\nfunc Serve() { auth.Login() }\n")
+
+	m := NewModel()
+	for _, filename := range []string{auth, api} {
+		src, err := os.ReadFile(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		updateFile(t, m, filename, string(src))
+	}
+
+	if !hasRelation(m, RelCalls, "func:example.com/m/api.Serve", "func:example.com/m/auth.Login") {
+		t.Error("call across packages of the module not resolved by import path")
+	}
+}
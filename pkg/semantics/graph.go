@@ -0,0 +1,371 @@
+package semantics
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ErrNoPath is returned when no call path connects two entities
+var ErrNoPath = errors.New("no call path")
+
+// Caller is an entity that calls another one, directly when Distance is 1
+// or through Distance-1 intermediate calls
+type Caller struct {
+	Entity   *Entity
+	Distance int
+}
+
+// Impact describes what may break when an entity changes or is deleted
+type Impact struct {
+	Entity *Entity
+	// Callers are the functions and methods that call the entity,
+	// directly or transitively, nearest first
+	Callers []Caller
+	// Dependents are the entities that refer to the entity other than by
+	// calling it: they use, embed, implement or return it
+	Dependents []*Relation
+	// Files are the files holding the entity, its callers and dependents
+	Files []string
+}
+
+// String summarises the impact for display
+func (i *Impact) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %s: %d callers, %d dependents, %d files\n",
+		i.Entity.Type, i.Entity.ID, len(i.Callers), len(i.Dependents), len(i.Files))
+	for _, caller := range i.Callers {
+		how := "directly"
+		if caller.Distance > 1 {
+			how = fmt.Sprintf("through %d calls", caller.Distance)
+		}
+		fmt.Fprintf(&b, "  called by %s (%s)\n", caller.Entity.ID, how)
+	}
+	for _, relation := range i.Dependents {
+		fmt.Fprintf(&b, "  %s by %s\n", strings.ToLower(relation.Type), relation.From.ID)
+	}
+	return b.String()
+}
+
+// TransitiveCallers returns everything that calls the entity, directly or
+// through other calls, ordered by distance and then ID. A maxDepth of zero
+// or less follows calls without limit.
+func (m *Model) TransitiveCallers(id string, maxDepth int) ([]Caller, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	entity, ok := m.entities[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrEntityNotFound, id)
+	}
+	return m.transitiveCallersLocked(entity, maxDepth), nil
+}
+
+// transitiveCallersLocked walks the call graph backwards from entity
+func (m *Model) transitiveCallersLocked(entity *Entity, maxDepth int) []Caller {
+	callers := m.incomingLocked(RelCalls)
+	distance := map[*Entity]int{entity: 0}
+	frontier := []*Entity{entity}
+	var result []Caller
+	for depth := 1; len(frontier) > 0 && (maxDepth <= 0 || depth <= maxDepth); depth++ {
+		var next []*Entity
+		for _, callee := range frontier {
+			for _, relation := range callers[callee] {
+				if _, seen := distance[relation.From]; seen {
+					continue
+				}
+				distance[relation.From] = depth
+				next = append(next, relation.From)
+				result = append(result, Caller{Entity: relation.From, Distance: depth})
+			}
+		}
+		frontier = next
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Distance != result[j].Distance {
+			return result[i].Distance < result[j].Distance
+		}
+		return result[i].Entity.ID < result[j].Entity.ID
+	})
+	return result
+}
+
+// ShortestCallPath returns the shortest chain of calls leading from one
+// entity to another, both included. It returns an error wrapping ErrNoPath
+// when the first never reaches the second.
+func (m *Model) ShortestCallPath(fromID, toID string) ([]*Entity, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	from, ok := m.entities[fromID]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrEntityNotFound, fromID)
+	}
+	to, ok := m.entities[toID]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrEntityNotFound, toID)
+	}
+
+	// Breadth-first search, visiting callees in ID order so that the same
+	// path is found every time
+	previous := map[*Entity]*Entity{from: nil}
+	queue := []*Entity{from}
+	for len(queue) > 0 && previous[to] == nil && from != to {
+		current := queue[0]
+		queue = queue[1:]
+
+		var callees []*Entity
+		for _, relation := range current.Relations {
+			if relation.Type == RelCalls {
+				callees = append(callees, relation.To)
+			}
+		}
+		sortEntities(callees)
+		for _, callee := range callees {
+			if _, seen := previous[callee]; seen {
+				continue
+			}
+			previous[callee] = current
+			queue = append(queue, callee)
+		}
+	}
+
+	if _, reached := previous[to]; !reached {
+		return nil, fmt.Errorf("%w from %s to %s", ErrNoPath, fromID, toID)
+	}
+	var path []*Entity
+	for entity := to; entity != nil; entity = previous[entity] {
+		path = append([]*Entity{entity}, path...)
+	}
+	return path, nil
+}
+
+// PackageComponents returns the strongly connected components of the
+// graph of dependencies between packages, where a package depends on
+// another when one of its entities calls, uses, embeds, implements or
+// returns an entity of the other. Components with more than one package
+// are dependency cycles. Packages within a component are ordered by ID,
+// and components by their first package.
+func (m *Model) PackageComponents() [][]*Entity {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	owner := m.packageOfLocked()
+	edges := map[*Entity]map[*Entity]bool{}
+	var packages []*Entity
+	for _, entity := range m.entities {
+		if entity.Type == TypePackage {
+			packages = append(packages, entity)
+			edges[entity] = map[*Entity]bool{}
+		}
+	}
+	sortEntities(packages)
+	for _, relation := range m.relations {
+		if relation.Type == RelContains {
+			continue
+		}
+		from, to := owner[relation.From], owner[relation.To]
+		if from != nil && to != nil && from != to {
+			edges[from][to] = true
+		}
+	}
+
+	// Tarjan's algorithm
+	index := map[*Entity]int{}
+	lowlink := map[*Entity]int{}
+	onStack := map[*Entity]bool{}
+	var stack []*Entity
+	var components [][]*Entity
+	var connect func(v *Entity)
+	connect = func(v *Entity) {
+		index[v] = len(index)
+		lowlink[v] = index[v]
+		stack = append(stack, v)
+		onStack[v] = true
+
+		var targets []*Entity
+		for w := range edges[v] {
+			targets = append(targets, w)
+		}
+		sortEntities(targets)
+		for _, w := range targets {
+			if _, visited := index[w]; !visited {
+				connect(w)
+				lowlink[v] = min(lowlink[v], lowlink[w])
+			} else if onStack[w] {
+				lowlink[v] = min(lowlink[v], index[w])
+			}
+		}
+
+		if lowlink[v] == index[v] {
+			var component []*Entity
+			for {
+				w := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				onStack[w] = false
+				component = append(component, w)
+				if w == v {
+					break
+				}
+			}
+			sortEntities(component)
+			components = append(components, component)
+		}
+	}
+	for _, p := range packages {
+		if _, visited := index[p]; !visited {
+			connect(p)
+		}
+	}
+
+	sort.Slice(components, func(i, j int) bool {
+		return components[i][0].ID < components[j][0].ID
+	})
+	return components
+}
+
+// DeadEntities returns the functions, methods, types, variables and
+// constants that nothing in the model refers to. Exported entities may be
+// used by code outside the model and are only included when asked for.
+// Entry points (main and init functions), methods that implement an
+// interface and blank identifiers are never reported.
+func (m *Model) DeadEntities(includeExported bool) []*Entity {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	referenced := map[*Entity]bool{}
+	implementing := map[string]bool{}
+	for _, relation := range m.relations {
+		if relation.Type != RelContains {
+			referenced[relation.To] = true
+		}
+		if relation.Type == RelImplements {
+			methods, _ := relation.To.Properties["methods"].([]string)
+			for _, method := range methods {
+				implementing[relation.From.ID+"."+method] = true
+			}
+		}
+	}
+
+	var dead []*Entity
+	for _, entity := range m.entities {
+		switch entity.Type {
+		case TypePackage, TypeField:
+			continue
+		case TypeFunction:
+			if entity.Name == "main" || entity.Name == "init" {
+				continue
+			}
+		case TypeMethod:
+			receiver, _ := entity.Properties["receiverID"].(string)
+			if implementing[receiver+"."+entity.Name] {
+				continue
+			}
+		}
+		if referenced[entity] || entity.Name == "_" {
+			continue
+		}
+		if exported, _ := entity.Properties["exported"].(bool); exported && !includeExported {
+			continue
+		}
+		dead = append(dead, entity)
+	}
+	sortEntities(dead)
+	return dead
+}
+
+// ImpactOf reports the blast radius of changing or deleting an entity:
+// its transitive callers, the entities depending on it otherwise, and the
+// files involved
+func (m *Model) ImpactOf(id string) (*Impact, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	entity, ok := m.entities[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrEntityNotFound, id)
+	}
+
+	impact := &Impact{Entity: entity, Callers: m.transitiveCallersLocked(entity, 0)}
+	for _, relation := range m.incomingLocked("")[entity] {
+		if relation.Type != RelCalls && relation.Type != RelContains {
+			impact.Dependents = append(impact.Dependents, relation)
+		}
+	}
+	sort.Slice(impact.Dependents, func(i, j int) bool {
+		a, b := impact.Dependents[i], impact.Dependents[j]
+		if a.From.ID != b.From.ID {
+			return a.From.ID < b.From.ID
+		}
+		return a.Type < b.Type
+	})
+
+	files := map[string]bool{}
+	addFile := func(e *Entity) {
+		if file, _ := e.Properties["file"].(string); file != "" {
+			files[file] = true
+		}
+	}
+	addFile(entity)
+	for _, caller := range impact.Callers {
+		addFile(caller.Entity)
+	}
+	for _, relation := range impact.Dependents {
+		addFile(relation.From)
+	}
+	for file := range files {
+		impact.Files = append(impact.Files, file)
+	}
+	sort.Strings(impact.Files)
+	return impact, nil
+}
+
+// RelationsOf returns the relations starting and ending at an entity
+func (m *Model) RelationsOf(id string) (outgoing, incoming []*Relation, err error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	entity, ok := m.entities[id]
+	if !ok {
+		return nil, nil, fmt.Errorf("%w: %s", ErrEntityNotFound, id)
+	}
+	outgoing = append(outgoing, entity.Relations...)
+	for _, relation := range m.relations {
+		if relation.To == entity {
+			incoming = append(incoming, relation)
+		}
+	}
+	return outgoing, incoming, nil
+}
+
+// incomingLocked indexes the relations of a type by their target; an
+// empty type indexes all relations
+func (m *Model) incomingLocked(relationType string) map[*Entity][]*Relation {
+	incoming := map[*Entity][]*Relation{}
+	for _, relation := range m.relations {
+		if relationType == "" || relation.Type == relationType {
+			incoming[relation.To] = append(incoming[relation.To], relation)
+		}
+	}
+	return incoming
+}
+
+// packageOfLocked maps every entity extracted from a file to the entity
+// of its package
+func (m *Model) packageOfLocked() map[*Entity]*Entity {
+	owner := map[*Entity]*Entity{}
+	for _, facts := range m.files {
+		pkg := m.entities[facts.pkg]
+		if pkg == nil {
+			continue
+		}
+		for _, id := range facts.entities {
+			if entity, ok := m.entities[id]; ok {
+				owner[entity] = pkg
+			}
+		}
+	}
+	return owner
+}
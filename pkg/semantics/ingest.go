@@ -0,0 +1,371 @@
+package semantics
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// SectionSchema describes the JSON expected in the semantics section of a
+// language model's answer. It is given to the model in the prompt.
+const SectionSchema = `{
+  "entities": [
+    {"id": "Login", "type": "Function", "name": "Login", "description": "what it does",
+     "properties": {"receiver": "for methods and fields, the name of their type"}}
+  ],
+  "relations": [
+    {"type": "Calls", "from": "Login", "to": "hashPassword"}
+  ]
+}
+Entity types: Package, Struct, Interface, Type, Function, Method, Field, Variable, Constant.
+Relation types: Contains, Calls, Implements, Embeds, Uses, Returns.
+Relations refer to entities by their "id", or by the ID of an entity already in the code base.`
+
+// SectionJSONSchema is the JSON Schema of a Section, for models that can be
+// constrained to produce structured output
+const SectionJSONSchema = `{
+  "type": "object",
+  "properties": {
+    "entities": {
+      "type": "array",
+      "items": {
+        "type": "object",
+        "properties": {
+          "id": {"type": "string"},
+          "type": {"type": "string", "enum": ["Package", "Struct", "Interface", "Type", "Function", "Method", "Field", "Variable", "Constant"]},
+          "name": {"type": "string"},
+          "description": {"type": "string"},
+          "properties": {"type": "object"}
+        },
+        "required": ["id", "type", "name"]
+      }
+    },
+    "relations": {
+      "type": "array",
+      "items": {
+        "type": "object",
+        "properties": {
+          "type": {"type": "string", "enum": ["Contains", "Calls", "Implements", "Embeds", "Uses", "Returns"]},
+          "from": {"type": "string"},
+          "to": {"type": "string"}
+        },
+        "required": ["type", "from", "to"]
+      }
+    }
+  },
+  "required": ["entities", "relations"]
+}`
+
+// Section is the semantics section of a language model's answer
+type Section struct {
+	Entities  []SectionEntity   `json:"entities"`
+	Relations []SectionRelation `json:"relations"`
+}
+
+// SectionEntity is an entity declared in a Section. Its ID only needs to
+// be unique within the section; Ingest maps it to an ID of the model.
+type SectionEntity struct {
+	ID          string                 `json:"id"`
+	Type        string                 `json:"type"`
+	Name        string                 `json:"name"`
+	Description string                 `json:"description,omitempty"`
+	Properties  map[string]interface{} `json:"properties,omitempty"`
+}
+
+// SectionRelation is a relation declared in a Section
+type SectionRelation struct {
+	Type string `json:"type"`
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// IngestResult reports what Ingest did with a section. Invalid entities
+// and relations are skipped and described in Skipped.
+type IngestResult struct {
+	Added     []string          `json:"added"`
+	Merged    []string          `json:"merged"`
+	Relations int               `json:"relations"`
+	Skipped   []string          `json:"skipped,omitempty"`
+	IDs       map[string]string `json:"ids"`
+}
+
+// entityAliases map type names models commonly use to ours
+var entityAliases = map[string]string{
+	"class":    TypeStruct,
+	"func":     TypeFunction,
+	"var":      TypeVariable,
+	"const":    TypeConstant,
+	"module":   TypePackage,
+	"property": TypeField,
+}
+
+// ParseSection decodes the semantics section of an answer. Surrounding
+// markdown code fences are ignored.
+func ParseSection(text string) (*Section, error) {
+	text = stripCodeFence(text)
+	if text == "" {
+		return nil, errors.New("semantics section is empty")
+	}
+
+	var section Section
+	if err := json.Unmarshal([]byte(text), &section); err != nil {
+		return nil, fmt.Errorf("semantics section is not valid JSON: %w", err)
+	}
+	return &section, nil
+}
+
+// Ingest validates a section and merges it into the model. Entities are
+// given the IDs the extractor would give them in package pkg, unless the
+// section already uses an ID of the model; an entity that exists under
+// that ID, or is the only one of its type and name, is merged rather than
+// added. Merging fills in the description and properties the model does
+// not have, and never overrides what was extracted from code. Everything
+// ingested is marked with the "source" property or metadata "llm".
+// Added entities belong to no file until code declaring them is extracted,
+// which takes them over together with their relations; until then
+// RemoveIngested drops them.
+func (m *Model) Ingest(section *Section, pkg string) *IngestResult {
+	result := &IngestResult{Added: []string{}, Merged: []string{}, IDs: map[string]string{}}
+	if pkg == "" {
+		pkg = "main"
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Types of methods and fields may be given by Contains relations
+	parents := map[string]string{}
+	for _, relation := range section.Relations {
+		if t, ok := canonicalName(relation.Type, relationTypes); ok && t == RelContains {
+			parents[relation.To] = relation.From
+		}
+	}
+	// Packages contain their declarations but are not receivers
+	names := map[string]string{}
+	for _, se := range section.Entities {
+		if t, _ := sectionEntityType(se); t != TypePackage {
+			names[se.ID] = se.Name
+		}
+	}
+
+	// Types come first so that their members can use the IDs they were
+	// reconciled to
+	order := make([]int, 0, len(section.Entities))
+	for pass := 0; pass < 2; pass++ {
+		for i, se := range section.Entities {
+			t, _ := sectionEntityType(se)
+			if (t == TypeMethod || t == TypeField) == (pass == 1) {
+				order = append(order, i)
+			}
+		}
+	}
+	ownerIDs := map[string]string{}
+
+	for _, i := range order {
+		se := section.Entities[i]
+		entityType, err := sectionEntityType(se)
+		if err != nil {
+			result.Skipped = append(result.Skipped, fmt.Sprintf("entity %d: %v", i, err))
+			continue
+		}
+		if se.ID == "" {
+			se.ID = se.Name
+		}
+		if _, dup := result.IDs[se.ID]; dup {
+			result.Skipped = append(result.Skipped, fmt.Sprintf("entity %d: duplicate id %q", i, se.ID))
+			continue
+		}
+
+		owner, _ := se.Properties["receiver"].(string)
+		if owner == "" {
+			owner = names[parents[se.ID]]
+		}
+		owner = strings.TrimPrefix(owner, "*")
+		if (entityType == TypeMethod || entityType == TypeField) && owner == "" {
+			result.Skipped = append(result.Skipped, fmt.Sprintf("entity %d: %s %q without a receiver type", i, strings.ToLower(entityType), se.Name))
+			continue
+		}
+
+		qualifier := pkg
+		if ownerID, ok := ownerIDs[owner]; ok && owner != "" {
+			qualifier = strings.TrimSuffix(strings.TrimPrefix(ownerID, "type:"), "."+owner)
+		}
+		id := sectionEntityID(entityType, qualifier, owner, se.Name)
+
+		existing := m.reconcileLocked(se.ID, id, entityType, se.Name, qualifier)
+		if existing != nil {
+			if existing.Description == "" {
+				existing.Description = se.Description
+			}
+			if existing.Properties == nil {
+				existing.Properties = map[string]interface{}{}
+			}
+			for key, value := range se.Properties {
+				if _, ok := existing.Properties[key]; !ok {
+					existing.Properties[key] = value
+				}
+			}
+			result.IDs[se.ID] = existing.ID
+			result.Merged = append(result.Merged, existing.ID)
+			if strings.HasPrefix(existing.ID, "type:") {
+				ownerIDs[se.Name] = existing.ID
+			}
+			continue
+		}
+
+		entity := &Entity{
+			ID:          id,
+			Type:        entityType,
+			Name:        se.Name,
+			Description: se.Description,
+			Properties:  map[string]interface{}{},
+		}
+		for key, value := range se.Properties {
+			entity.Properties[key] = value
+		}
+		entity.Properties["package"] = path.Base(qualifier)
+		entity.Properties["source"] = "llm"
+		if owner != "" {
+			entity.Properties["receiverID"] = typeID(qualifier, owner)
+		}
+		if strings.HasPrefix(id, "type:") {
+			ownerIDs[se.Name] = id
+		}
+		m.entities[entity.ID] = entity
+		m.ingested[entity.ID] = true
+		result.IDs[se.ID] = entity.ID
+		result.Added = append(result.Added, entity.ID)
+	}
+
+	for i, sr := range section.Relations {
+		relationType, ok := canonicalName(sr.Type, relationTypes)
+		if !ok {
+			result.Skipped = append(result.Skipped, fmt.Sprintf("relation %d: unknown relation type %q", i, sr.Type))
+			continue
+		}
+		from, to := m.sectionEndLocked(result, sr.From), m.sectionEndLocked(result, sr.To)
+		if from == nil || to == nil || from == to {
+			result.Skipped = append(result.Skipped, fmt.Sprintf("relation %d: %s from %q to %q does not connect two known entities", i, relationType, sr.From, sr.To))
+			continue
+		}
+		before := len(m.relations)
+		m.addRelationLocked(&Relation{
+			Type:     relationType,
+			From:     from,
+			To:       to,
+			Metadata: map[string]interface{}{"source": "llm"},
+		})
+		result.Relations += len(m.relations) - before
+	}
+
+	sort.Strings(result.Added)
+	sort.Strings(result.Merged)
+	return result
+}
+
+// RemoveIngested removes the entities that are only known from Ingest,
+// together with their relations, and returns how many there were
+func (m *Model) RemoveIngested() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	removed := map[*Entity]bool{}
+	for id := range m.ingested {
+		if entity, ok := m.entities[id]; ok {
+			removed[entity] = true
+			delete(m.entities, id)
+		}
+	}
+	m.ingested = map[string]bool{}
+	m.dropRelationsLocked(func(r *Relation) bool {
+		return removed[r.From] || removed[r.To]
+	})
+	return len(removed)
+}
+
+// reconcileLocked finds the entity of the model a section entity refers
+// to: the entity with the section's ID, the one with the ID the extractor
+// would have given it, or the only entity with its type and name in the
+// package at pkg
+func (m *Model) reconcileLocked(sectionID, canonicalID, entityType, name, pkg string) *Entity {
+	if entity, ok := m.entities[sectionID]; ok && entity.Type == entityType {
+		return entity
+	}
+	if entity, ok := m.entities[canonicalID]; ok {
+		return entity
+	}
+	var match *Entity
+	for _, entity := range m.entities {
+		if entity.Type == entityType && entity.Name == name && entity.Properties["package"] == path.Base(pkg) {
+			if match != nil {
+				return nil
+			}
+			match = entity
+		}
+	}
+	return match
+}
+
+// sectionEndLocked finds the entity a relation of a section refers to,
+// either by section ID or by model ID
+func (m *Model) sectionEndLocked(result *IngestResult, id string) *Entity {
+	if mapped, ok := result.IDs[id]; ok {
+		return m.entities[mapped]
+	}
+	return m.entities[id]
+}
+
+// sectionEntityType validates a section entity and returns its type
+func sectionEntityType(se SectionEntity) (string, error) {
+	if se.Name == "" {
+		return "", errors.New("entity has no name")
+	}
+	if strings.ContainsAny(se.Name, " \t\n") {
+		return "", fmt.Errorf("invalid entity name %q", se.Name)
+	}
+	if t, ok := canonicalName(se.Type, entityTypes); ok {
+		return t, nil
+	}
+	if t, ok := entityAliases[strings.ToLower(se.Type)]; ok {
+		return t, nil
+	}
+	return "", fmt.Errorf("unknown entity type %q", se.Type)
+}
+
+// stripCodeFence removes a surrounding markdown code fence and the space
+// around it
+func stripCodeFence(text string) string {
+	text = strings.TrimSpace(text)
+	if !strings.HasPrefix(text, "```") {
+		return text
+	}
+	text = strings.TrimPrefix(text, "```")
+	if newline := strings.Index(text, "\n"); newline >= 0 {
+		text = text[newline+1:]
+	}
+	return strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(text), "```"))
+}
+
+// sectionEntityID gives a section entity the ID the extractor would give
+// the same declaration
+func sectionEntityID(entityType, pkg, owner, name string) string {
+	switch entityType {
+	case TypePackage:
+		return packageID(name)
+	case TypeStruct, TypeInterface, TypeNamed:
+		return typeID(pkg, name)
+	case TypeMethod:
+		return methodID(pkg, owner, name)
+	case TypeField:
+		return "field:" + pkg + "." + owner + "." + name
+	case TypeVariable:
+		return "var:" + pkg + "." + name
+	case TypeConstant:
+		return "const:" + pkg + "." + name
+	default:
+		return "func:" + pkg + "." + name
+	}
+}
@@ -0,0 +1,120 @@
+package semantics
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const loginSection = `{
+  "entities": [
+    {"id": "Login", "type": "Function", "name": "Login", "description": "signs a user in"},
+    {"id": "hashPassword", "type": "Function", "name": "hashPassword"},
+    {"id": "Audit", "type": "Function", "name": "Audit"}
+  ],
+  "relations": [
+    {"type": "Calls", "from": "Login", "to": "hashPassword"},
+    {"type": "Calls", "from": "Login", "to": "Audit"}
+  ]
+}`
+
+// ingestLogin ingests loginSection into m
+func ingestLogin(t *testing.T, m *Model) {
+	t.Helper()
+
+	section, err := ParseSection(loginSection)
+	if err != nil {
+		t.Fatalf("ParseSection: %v", err)
+	}
+	result := m.Ingest(section, "auth")
+	if len(result.Added) != 3 || len(result.Skipped) != 0 || result.Relations != 2 {
+		t.Fatalf("unexpected ingest result %+v", result)
+	}
+}
+
+func TestIngestThenExtract(t *testing.T) {
+	m := NewModel()
+	ingestLogin(t, m)
+
+	updateFile(t, m, "auth.go", `package auth
+func Login() { hashPassword() }
+func hashPassword() {}
+`)
+
+	login, _ := m.GetEntity("func:auth.Login")
+	hash, _ := m.GetEntity("func:auth.hashPassword")
+	audit, _ := m.GetEntity("func:auth.Audit")
+	if login.Properties["file"] != "auth.go" || hash.Properties["file"] != "auth.go" {
+		t.Fatal("extracted entities did not replace the ingested ones")
+	}
+
+	// The relations of the replaced entities point at the current ones
+	for _, relation := range m.relations {
+		from, _ := m.GetEntity(relation.From.ID)
+		to, _ := m.GetEntity(relation.To.ID)
+		if from != relation.From || to != relation.To {
+			t.Errorf("%s relation from %s to %s points at a replaced entity", relation.Type, relation.From.ID, relation.To.ID)
+		}
+	}
+	calls := map[*Entity]int{}
+	for _, relation := range login.Relations {
+		if relation.Type == RelCalls {
+			calls[relation.To]++
+		}
+	}
+	if calls[hash] != 1 || calls[audit] != 1 {
+		t.Errorf("Login calls %v, want hashPassword and Audit once each", calls)
+	}
+
+	// Only the entity the code does not declare is still ingested
+	if n := m.RemoveIngested(); n != 1 {
+		t.Errorf("RemoveIngested removed %d entities, want 1", n)
+	}
+	if _, ok := m.GetEntity("func:auth.Audit"); ok {
+		t.Error("ingested entity kept by RemoveIngested")
+	}
+	for _, relation := range login.Relations {
+		if relation.To == audit {
+			t.Error("relation to a removed ingested entity kept")
+		}
+	}
+
+	m.RemoveFile("auth.go")
+	if _, ok := m.GetEntity("func:auth.Login"); ok {
+		t.Error("entity taken over by code kept after its file was removed")
+	}
+}
+
+func TestParseSectionInCodeFence(t *testing.T) {
+	section, err := ParseSection("```json\n" + loginSection + "\n```\n")
+	if err != nil {
+		t.Fatalf("ParseSection: %v", err)
+	}
+	if len(section.Entities) != 3 {
+		t.Errorf("got %d entities, want 3", len(section.Entities))
+	}
+}
+
+func TestIngestReconcilesWithinPackage(t *testing.T) {
+	m := NewModel()
+	updateFile(t, m, "billing.go", "package billing\nfunc Charge() {}\n")
+	updateFile(t, m, filepath.Join(t.TempDir(), "auth", "auth.go"), "package auth\nfunc Login() {}\n")
+
+	section, err := ParseSection(`{"entities": [
+    {"id": "Login", "type": "Function", "name": "Login"},
+    {"id": "Charge", "type": "Function", "name": "Charge"}
+  ], "relations": []}`)
+	if err != nil {
+		t.Fatalf("ParseSection: %v", err)
+	}
+	result := m.Ingest(section, "auth")
+
+	// Login is the only one in auth, whatever its ID; Charge of another
+	// package is a different function
+	if len(result.Merged) != 1 || !strings.HasSuffix(result.Merged[0], "/auth.Login") {
+		t.Errorf("merged %v, want the Login of auth", result.Merged)
+	}
+	if len(result.Added) != 1 || result.Added[0] != "func:auth.Charge" {
+		t.Errorf("added %v, want func:auth.Charge", result.Added)
+	}
+}
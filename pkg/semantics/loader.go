@@ -0,0 +1,668 @@
+package semantics
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Loader type-checks every package of a Go module with go/types and feeds
+// the result into a Model. Imports are resolved from the module itself, its
+// vendor directory, the standard library and the local module cache; nothing
+// is downloaded. Entity IDs are qualified by import path, for example
+// "func:github.com/org/repo/pkg/auth.Login".
+type Loader struct {
+	Root       string
+	ModulePath string
+
+	// Errors collects the type errors found while loading. They do not stop
+	// the load, as partially typed packages still carry useful information.
+	Errors []error
+
+	fset     *token.FileSet
+	requires map[string]string
+	replaces map[string]string
+	modCache string
+	packages map[string]*types.Package
+	module   map[string]*loadedPackage
+	loading  map[string]bool
+}
+
+// loadedPackage is a type-checked package of the module
+type loadedPackage struct {
+	path  string
+	files []*ast.File
+	info  *types.Info
+	pkg   *types.Package
+}
+
+// NewLoader creates a loader for the module whose go.mod is in root
+func NewLoader(root string) (*Loader, error) {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	l := &Loader{
+		Root:     root,
+		fset:     token.NewFileSet(),
+		requires: map[string]string{},
+		replaces: map[string]string{},
+		packages: map[string]*types.Package{},
+		module:   map[string]*loadedPackage{},
+		loading:  map[string]bool{},
+	}
+	if err := l.readGoMod(); err != nil {
+		return nil, err
+	}
+
+	l.modCache = os.Getenv("GOMODCACHE")
+	if l.modCache == "" {
+		gopath := os.Getenv("GOPATH")
+		if gopath == "" {
+			gopath = build.Default.GOPATH
+		}
+		l.modCache = filepath.Join(strings.Split(gopath, string(os.PathListSeparator))[0], "pkg", "mod")
+	}
+	return l, nil
+}
+
+// readGoMod reads the module path, requirements and local replacements
+func (l *Loader) readGoMod() error {
+	f, err := os.Open(filepath.Join(l.Root, "go.mod"))
+	if err != nil {
+		return fmt.Errorf("error reading go.mod: %w", err)
+	}
+	defer f.Close()
+
+	block := ""
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "//"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		// Directives are either single lines or blocks in parentheses
+		directive := block
+		switch {
+		case fields[0] == ")":
+			block = ""
+			continue
+		case len(fields) == 2 && fields[1] == "(":
+			block = fields[0]
+			continue
+		case block == "":
+			directive, fields = fields[0], fields[1:]
+		}
+
+		switch directive {
+		case "module":
+			if len(fields) > 0 {
+				l.ModulePath = strings.Trim(fields[0], `"`)
+			}
+		case "require":
+			if len(fields) >= 2 {
+				l.requires[fields[0]] = fields[1]
+			}
+		case "replace":
+			// Only replacements by local directories are honoured
+			arrow := indexOf(fields, "=>")
+			if arrow > 0 && arrow+1 < len(fields) {
+				target := fields[arrow+1]
+				if strings.HasPrefix(target, ".") || filepath.IsAbs(target) {
+					if !filepath.IsAbs(target) {
+						target = filepath.Join(l.Root, target)
+					}
+					l.replaces[fields[0]] = target
+				}
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if l.ModulePath == "" {
+		return errors.New("go.mod has no module directive")
+	}
+	return nil
+}
+
+// Load type-checks every package of the module and replaces the entities
+// of their files in the model. Call edges, interface implementations and
+// method sets come from the type checker rather than from names.
+func (l *Loader) Load(m *Model) error {
+	dirs, err := l.packageDirs()
+	if err != nil {
+		return err
+	}
+
+	var loaded []*loadedPackage
+	for _, dir := range dirs {
+		rel, err := filepath.Rel(l.Root, dir)
+		if err != nil {
+			return err
+		}
+		importPath := l.ModulePath
+		if rel != "." {
+			importPath = path.Join(l.ModulePath, filepath.ToSlash(rel))
+		}
+		// Packages imported by ones loaded earlier are already checked
+		lp, ok := l.module[importPath]
+		if !ok {
+			if lp, err = l.check(importPath, dir, true); err != nil {
+				l.Errors = append(l.Errors, err)
+				continue
+			}
+		}
+		if lp != nil {
+			loaded = append(loaded, lp)
+		}
+	}
+
+	// Interfaces are matched across every package of the module
+	var named []*types.Named
+	for _, lp := range loaded {
+		scope := lp.pkg.Scope()
+		for _, name := range scope.Names() {
+			if tn, ok := scope.Lookup(name).(*types.TypeName); ok && !tn.IsAlias() {
+				if n, ok := tn.Type().(*types.Named); ok {
+					named = append(named, n)
+				}
+			}
+		}
+	}
+
+	extractors := make([]*extractor, 0)
+	for _, lp := range loaded {
+		for _, file := range lp.files {
+			extractors = append(extractors, l.extract(lp, file, named))
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	changes := newChangeSet()
+	for _, e := range extractors {
+		m.replaceFileLocked(e, changes)
+	}
+	m.resolveChangesLocked(changes)
+	return nil
+}
+
+// LoadDir indexes the Go files under root. When root holds a go.mod, its
+// module is type-checked with a Loader first; the files the Loader did not
+// load, or all of them when it fails, are then refreshed from their syntax.
+// updated counts the files indexed either way.
+func (m *Model) LoadDir(root string) (updated, removed int, err error) {
+	loaded := 0
+	loader, err := NewLoader(root)
+	if err == nil {
+		err = loader.Load(m)
+	}
+	switch {
+	case err == nil:
+		for _, lp := range loader.module {
+			if lp != nil {
+				loaded += len(lp.files)
+			}
+		}
+		if len(loader.Errors) > 0 {
+			log.Printf("Semantic model: %d type errors in %s, first: %v", len(loader.Errors), root, loader.Errors[0])
+		}
+	case !errors.Is(err, os.ErrNotExist):
+		log.Printf("Semantic model: could not type-check %s, indexing its syntax only: %v", root, err)
+	}
+
+	updated, removed, err = m.Refresh(root)
+	return loaded + updated, removed, err
+}
+
+// packageDirs lists the directories of the module that hold Go files,
+// skipping vendor, testdata, hidden directories and nested modules
+func (l *Loader) packageDirs() ([]string, error) {
+	var dirs []string
+	err := filepath.Walk(l.Root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			name := info.Name()
+			if p != l.Root {
+				if name == "vendor" || name == "testdata" || strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_") {
+					return filepath.SkipDir
+				}
+				if _, err := os.Stat(filepath.Join(p, "go.mod")); err == nil {
+					return filepath.SkipDir
+				}
+			}
+			return nil
+		}
+		if strings.HasSuffix(p, ".go") && !strings.HasSuffix(p, "_test.go") {
+			dir := filepath.Dir(p)
+			if len(dirs) == 0 || dirs[len(dirs)-1] != dir {
+				dirs = append(dirs, dir)
+			}
+		}
+		return nil
+	})
+	sort.Strings(dirs)
+	return dirs, err
+}
+
+// Import implements types.Importer, type-checking imported packages from
+// source the first time they are needed
+func (l *Loader) Import(importPath string) (*types.Package, error) {
+	if importPath == "unsafe" {
+		return types.Unsafe, nil
+	}
+	if pkg, ok := l.packages[importPath]; ok {
+		return pkg, nil
+	}
+	dir, err := l.resolve(importPath)
+	if err != nil {
+		return nil, err
+	}
+	lp, err := l.check(importPath, dir, l.inModule(importPath))
+	if err != nil {
+		return nil, err
+	}
+	if lp == nil {
+		return nil, fmt.Errorf("no Go files for %s in %s", importPath, dir)
+	}
+	return lp.pkg, nil
+}
+
+// resolve finds the directory holding the source of an import path
+func (l *Loader) resolve(importPath string) (string, error) {
+	if l.inModule(importPath) {
+		return filepath.Join(l.Root, filepath.FromSlash(strings.TrimPrefix(importPath, l.ModulePath))), nil
+	}
+
+	candidates := []string{filepath.Join(l.Root, "vendor", filepath.FromSlash(importPath))}
+	if !strings.Contains(strings.SplitN(importPath, "/", 2)[0], ".") {
+		goroot := filepath.Join(runtime.GOROOT(), "src")
+		candidates = append(candidates,
+			filepath.Join(goroot, filepath.FromSlash(importPath)),
+			filepath.Join(goroot, "vendor", filepath.FromSlash(importPath)))
+	} else {
+		candidates = append(candidates, filepath.Join(runtime.GOROOT(), "src", "vendor", filepath.FromSlash(importPath)))
+	}
+
+	// The module providing the path is the required one with the longest prefix
+	modulePath := ""
+	for required := range l.requires {
+		if (importPath == required || strings.HasPrefix(importPath, required+"/")) && len(required) > len(modulePath) {
+			modulePath = required
+		}
+	}
+	if modulePath != "" {
+		sub := filepath.FromSlash(strings.TrimPrefix(importPath, modulePath))
+		if replaced, ok := l.replaces[modulePath]; ok {
+			candidates = append(candidates, filepath.Join(replaced, sub))
+		}
+		candidates = append(candidates, filepath.Join(l.modCache,
+			escapeModulePath(modulePath)+"@"+escapeModulePath(l.requires[modulePath]), sub))
+	}
+
+	for _, dir := range candidates {
+		if info, err := os.Stat(dir); err == nil && info.IsDir() {
+			return dir, nil
+		}
+	}
+	return "", fmt.Errorf("cannot find package %s in the module, vendor, GOROOT or module cache", importPath)
+}
+
+// inModule reports whether an import path belongs to the loaded module
+func (l *Loader) inModule(importPath string) bool {
+	return importPath == l.ModulePath || strings.HasPrefix(importPath, l.ModulePath+"/")
+}
+
+// check parses and type-checks the package in dir. Full information is
+// kept for packages of the module; dependencies only keep their types.
+func (l *Loader) check(importPath, dir string, full bool) (*loadedPackage, error) {
+	if l.loading[importPath] {
+		return nil, fmt.Errorf("import cycle through %s", importPath)
+	}
+	l.loading[importPath] = true
+	defer delete(l.loading, importPath)
+
+	bp, err := build.Default.ImportDir(dir, 0)
+	if err != nil {
+		var noGo *build.NoGoError
+		if errors.As(err, &noGo) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	mode := parser.SkipObjectResolution
+	if full {
+		mode |= parser.ParseComments
+	}
+	var files []*ast.File
+	for _, name := range append(bp.GoFiles, bp.CgoFiles...) {
+		file, err := parser.ParseFile(l.fset, filepath.Join(dir, name), nil, mode)
+		if err != nil {
+			if file == nil {
+				continue
+			}
+			l.Errors = append(l.Errors, err)
+		}
+		if !full {
+			// Dependencies only need their declarations
+			for _, decl := range file.Decls {
+				if fn, ok := decl.(*ast.FuncDecl); ok {
+					fn.Body = nil
+				}
+			}
+		}
+		files = append(files, file)
+	}
+
+	lp := &loadedPackage{path: importPath, files: files}
+	if full {
+		lp.info = &types.Info{
+			Defs:       map[*ast.Ident]types.Object{},
+			Uses:       map[*ast.Ident]types.Object{},
+			Selections: map[*ast.SelectorExpr]*types.Selection{},
+		}
+	}
+	conf := types.Config{
+		Importer:    l,
+		FakeImportC: true,
+		Error: func(err error) {
+			if full {
+				l.Errors = append(l.Errors, err)
+			}
+		},
+		IgnoreFuncBodies: !full,
+	}
+	lp.pkg, _ = conf.Check(importPath, l.fset, files, lp.info)
+	l.packages[importPath] = lp.pkg
+	if full {
+		l.module[importPath] = lp
+	}
+	return lp, nil
+}
+
+// extract collects the entities of one file of the module and the
+// relations the type checker resolved for it
+func (l *Loader) extract(lp *loadedPackage, file *ast.File, named []*types.Named) *extractor {
+	filename := l.fset.File(file.Pos()).Name()
+	e := newExtractor(l.fset, filename, lp.pkg.Name(), lp.path)
+	e.extractFile(file)
+
+	// Keep the entities and structure, but replace the name-based
+	// references with resolved ones
+	var references []reference
+	for _, ref := range e.references {
+		if ref.kind == RelContains {
+			references = append(references, ref)
+		}
+	}
+	e.references = references
+	e.seen = map[string]bool{}
+	for _, entity := range e.entities {
+		entity.Properties["typed"] = true
+	}
+
+	for _, decl := range file.Decls {
+		switch d := decl.(type) {
+		case *ast.FuncDecl:
+			fn, ok := lp.info.Defs[d.Name].(*types.Func)
+			if !ok {
+				continue
+			}
+			from := objectID(fn)
+			sig := fn.Type().(*types.Signature)
+			l.typeRefs(e, RelUses, from, sig.Params())
+			l.typeRefs(e, RelReturns, from, sig.Results())
+			if d.Body != nil {
+				l.bodyRefs(e, lp, from, d.Body)
+			}
+
+		case *ast.GenDecl:
+			for _, spec := range d.Specs {
+				switch s := spec.(type) {
+				case *ast.TypeSpec:
+					l.typeSpecRefs(e, lp, s, named)
+				case *ast.ValueSpec:
+					for i, name := range s.Names {
+						obj := lp.info.Defs[name]
+						if obj == nil || name.Name == "_" {
+							continue
+						}
+						from := objectID(obj)
+						l.namedRefs(e, RelUses, from, obj.Type())
+						if i < len(s.Values) {
+							l.bodyRefs(e, lp, from, s.Values[i])
+						}
+					}
+				}
+			}
+		}
+	}
+	return e
+}
+
+// typeSpecRefs records what a named type embeds, uses and implements, and
+// stores its method set
+func (l *Loader) typeSpecRefs(e *extractor, lp *loadedPackage, spec *ast.TypeSpec, named []*types.Named) {
+	tn, ok := lp.info.Defs[spec.Name].(*types.TypeName)
+	if !ok {
+		return
+	}
+	from := objectID(tn)
+
+	switch underlying := tn.Type().Underlying().(type) {
+	case *types.Struct:
+		for i := 0; i < underlying.NumFields(); i++ {
+			field := underlying.Field(i)
+			if field.Embedded() {
+				l.namedRefs(e, RelEmbeds, from, field.Type())
+			}
+			l.namedRefs(e, RelUses, "field:"+lp.path+"."+tn.Name()+"."+field.Name(), field.Type())
+		}
+	case *types.Interface:
+		for i := 0; i < underlying.NumEmbeddeds(); i++ {
+			l.namedRefs(e, RelEmbeds, from, underlying.EmbeddedType(i))
+		}
+		return
+	default:
+		l.namedRefs(e, RelUses, from, underlying)
+	}
+
+	// Record the method set and the interfaces of the module it satisfies
+	ptr := types.NewPointer(tn.Type())
+	var methods []string
+	set := types.NewMethodSet(ptr)
+	for i := 0; i < set.Len(); i++ {
+		methods = append(methods, set.At(i).Obj().Name())
+	}
+	for _, entity := range e.entities {
+		if entity.ID == from {
+			entity.Properties["methodSet"] = methods
+		}
+	}
+	for _, n := range named {
+		iface, ok := n.Underlying().(*types.Interface)
+		if !ok || iface.NumMethods() == 0 {
+			continue
+		}
+		if types.Implements(tn.Type(), iface) || types.Implements(ptr, iface) {
+			e.reference(RelImplements, from, objectID(n.Obj()))
+		}
+	}
+}
+
+// bodyRefs records the calls and uses resolved by the type checker below n.
+// Functions referred to without being called, such as method values passed
+// as handlers, are recorded as uses.
+func (l *Loader) bodyRefs(e *extractor, lp *loadedPackage, from string, n ast.Node) {
+	called := map[*ast.Ident]bool{}
+	ast.Inspect(n, func(n ast.Node) bool {
+		if call, ok := n.(*ast.CallExpr); ok {
+			if fn, ident := calledFunc(lp.info, call); fn != nil {
+				called[ident] = true
+				e.reference(RelCalls, from, objectID(fn))
+			}
+			return true
+		}
+		ident, ok := n.(*ast.Ident)
+		if !ok || called[ident] {
+			return true
+		}
+		switch obj := lp.info.Uses[ident].(type) {
+		case *types.TypeName, *types.Const, *types.Var, *types.Func:
+			if id := objectID(obj); id != "" {
+				e.reference(RelUses, from, id)
+			}
+		}
+		return true
+	})
+}
+
+// calledFunc returns the function or method a call statically refers to,
+// and the identifier naming it
+func calledFunc(info *types.Info, call *ast.CallExpr) (*types.Func, *ast.Ident) {
+	fun := ast.Unparen(call.Fun)
+	switch f := fun.(type) {
+	case *ast.IndexExpr:
+		fun = f.X
+	case *ast.IndexListExpr:
+		fun = f.X
+	}
+	var ident *ast.Ident
+	switch f := fun.(type) {
+	case *ast.Ident:
+		ident = f
+	case *ast.SelectorExpr:
+		ident = f.Sel
+	default:
+		return nil, nil
+	}
+	if fn, ok := info.Uses[ident].(*types.Func); ok {
+		return fn, ident
+	}
+	return nil, nil
+}
+
+// typeRefs records references to the named types of a tuple
+func (l *Loader) typeRefs(e *extractor, kind, from string, tuple *types.Tuple) {
+	for i := 0; i < tuple.Len(); i++ {
+		l.namedRefs(e, kind, from, tuple.At(i).Type())
+	}
+}
+
+// namedRefs records a reference to every named type that t is built from
+func (l *Loader) namedRefs(e *extractor, kind, from string, t types.Type) {
+	switch t := t.(type) {
+	case *types.Named:
+		if id := objectID(t.Obj()); id != "" {
+			e.reference(kind, from, id)
+		}
+		args := t.TypeArgs()
+		for i := 0; i < args.Len(); i++ {
+			l.namedRefs(e, kind, from, args.At(i))
+		}
+	case *types.Alias:
+		l.namedRefs(e, kind, from, types.Unalias(t))
+	case *types.Pointer:
+		l.namedRefs(e, kind, from, t.Elem())
+	case *types.Slice:
+		l.namedRefs(e, kind, from, t.Elem())
+	case *types.Array:
+		l.namedRefs(e, kind, from, t.Elem())
+	case *types.Map:
+		l.namedRefs(e, kind, from, t.Key())
+		l.namedRefs(e, kind, from, t.Elem())
+	case *types.Chan:
+		l.namedRefs(e, kind, from, t.Elem())
+	case *types.Signature:
+		l.typeRefs(e, kind, from, t.Params())
+		l.typeRefs(e, kind, from, t.Results())
+	}
+}
+
+// objectID returns the ID of the entity for a package-level object, a
+// method or a field, qualified by import path. Local objects have no ID.
+func objectID(obj types.Object) string {
+	if obj == nil || obj.Pkg() == nil {
+		return ""
+	}
+	pkgPath := obj.Pkg().Path()
+	scope := obj.Pkg().Scope()
+
+	switch o := obj.(type) {
+	case *types.Func:
+		sig, _ := o.Type().(*types.Signature)
+		if sig != nil && sig.Recv() != nil {
+			recv := sig.Recv().Type()
+			if ptr, ok := recv.(*types.Pointer); ok {
+				recv = ptr.Elem()
+			}
+			if n, ok := types.Unalias(recv).(*types.Named); ok {
+				return methodID(pkgPath, n.Origin().Obj().Name(), o.Name())
+			}
+			return ""
+		}
+		if o.Parent() == scope {
+			return "func:" + pkgPath + "." + o.Name()
+		}
+	case *types.TypeName:
+		if o.Parent() == scope {
+			return typeID(pkgPath, o.Name())
+		}
+	case *types.Var:
+		if !o.IsField() && o.Parent() == scope {
+			return "var:" + pkgPath + "." + o.Name()
+		}
+	case *types.Const:
+		if o.Parent() == scope {
+			return "const:" + pkgPath + "." + o.Name()
+		}
+	}
+	return ""
+}
+
+// escapeModulePath applies the module cache's case encoding, in which
+// upper-case letters are written as "!" followed by the lower-case letter
+func escapeModulePath(p string) string {
+	var b strings.Builder
+	for _, r := range p {
+		if unicode.IsUpper(r) {
+			b.WriteByte('!')
+			b.WriteRune(unicode.ToLower(r))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// indexOf returns the position of s in list, or -1
+func indexOf(list []string, s string) int {
+	for i, item := range list {
+		if item == s {
+			return i
+		}
+	}
+	return -1
+}
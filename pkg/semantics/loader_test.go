@@ -0,0 +1,54 @@
+package semantics
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadDir(t *testing.T) {
+	const src = `package m
+
+type Server struct{}
+
+func NewServer() *Server { return &Server{} }
+
+func (s *Server) Start() {}
+
+func Run() { NewServer().Start() }
+`
+	calls := func(m *Model) bool {
+		outgoing, _, err := m.RelationsOf("func:example.com/m.Run")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, r := range outgoing {
+			if r.Type == RelCalls && r.To.ID == "method:example.com/m.Server.Start" {
+				return true
+			}
+		}
+		return false
+	}
+
+	// A module is type-checked, which resolves calls on returned values
+	dir := t.TempDir()
+	writeFile(t, dir, "go.mod", "module example.com/m\n\ngo 1.22\n")
+	writeFile(t, dir, "m.go", src)
+	m := NewModel()
+	if updated, _, err := m.LoadDir(dir); err != nil || updated != 1 {
+		t.Fatalf("LoadDir: %d files updated, %v", updated, err)
+	}
+	if !calls(m) {
+		t.Error("call to Start not resolved by the type checker")
+	}
+
+	// A directory that is no module is indexed from its syntax
+	dir = t.TempDir()
+	writeFile(t, dir, "m.go", src)
+	m = NewModel()
+	if updated, _, err := m.LoadDir(dir); err != nil || updated != 1 {
+		t.Fatalf("LoadDir without go.mod: %d files updated, %v", updated, err)
+	}
+	if _, ok := m.GetEntity("func:" + filepath.ToSlash(dir) + ".Run"); !ok {
+		t.Error("Run missing from the syntax-only index")
+	}
+}
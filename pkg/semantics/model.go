@@ -1,6 +1,9 @@
 package semantics
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
 	"sync"
 )
 
@@ -22,11 +25,26 @@ type Relation struct {
 	Metadata map[string]interface{}
 }
 
+// MarshalJSON encodes the relation with its entities given by ID, as
+// entities and relations refer to each other
+func (r *Relation) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Type     string
+		From     string
+		To       string
+		Metadata map[string]interface{} `json:",omitempty"`
+	}{r.Type, r.From.ID, r.To.ID, r.Metadata})
+}
+
 // Model represents our semantic understanding of the code
 type Model struct {
-	entities  map[string]*Entity
-	relations []*Relation
-	mu        sync.RWMutex
+	entities   map[string]*Entity
+	relations  []*Relation
+	files      map[string]*fileFacts
+	referrers  map[string]map[string]bool // entity ID -> files referring to it
+	ingested   map[string]bool            // entities only known from Ingest
+	translator QueryTranslator
+	mu         sync.RWMutex
 }
 
 // NewModel creates a new semantic model
@@ -34,15 +52,47 @@ func NewModel() *Model {
 	return &Model{
 		entities:  make(map[string]*Entity),
 		relations: []*Relation{},
+		files:     make(map[string]*fileFacts),
+		referrers: make(map[string]map[string]bool),
+		ingested:  make(map[string]bool),
 	}
 }
 
-// AddEntity adds a new entity to the model
+// ErrEntityNotFound is returned when an operation names an entity that is
+// not in the model
+var ErrEntityNotFound = errors.New("entity not found")
+
+// EntityFilter selects entities for ListEntities. Empty fields match
+// everything; Name and property values may use * and ? wildcards.
+type EntityFilter struct {
+	Types      []string
+	Name       string
+	File       string
+	Properties map[string]string
+}
+
+// AddEntity adds a new entity to the model. An entity with the same ID is
+// replaced, and the relations of the old entity are moved to the new one.
 func (m *Model) AddEntity(entity *Entity) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	
+	old, exists := m.entities[entity.ID]
 	m.entities[entity.ID] = entity
+	if !exists || old == entity {
+		return
+	}
+	
+	entity.Relations = nil
+	for _, relation := range m.relations {
+		if relation.From == old {
+			relation.From = entity
+			entity.Relations = append(entity.Relations, relation)
+		}
+		if relation.To == old {
+			relation.To = entity
+		}
+	}
 }
 
 // GetEntity retrieves an entity by ID
@@ -54,36 +104,119 @@ func (m *Model) GetEntity(id string) (*Entity, bool) {
 	return entity, exists
 }
 
-// AddRelation adds a relationship between entities
-func (m *Model) AddRelation(relation *Relation) {
+// UpdateEntity replaces the type, name, description and properties of the
+// entity with the same ID. The entity keeps its relations.
+func (m *Model) UpdateEntity(entity *Entity) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	
-	m.relations = append(m.relations, relation)
-	relation.From.Relations = append(relation.From.Relations, relation)
+	existing, exists := m.entities[entity.ID]
+	if !exists {
+		return fmt.Errorf("%w: %s", ErrEntityNotFound, entity.ID)
+	}
+	
+	existing.Type = entity.Type
+	existing.Name = entity.Name
+	existing.Description = entity.Description
+	existing.Properties = entity.Properties
+	return nil
 }
 
-// QueryByIntent finds entities and relations based on natural language intent
-func (m *Model) QueryByIntent(intent string) ([]*Entity, []*Relation) {
-	// This would use NLP to find relevant entities and relations
-	// Simplified for demonstration
+// RemoveEntity removes an entity together with every relation starting or
+// ending at it
+func (m *Model) RemoveEntity(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	
+	entity, exists := m.entities[id]
+	if !exists {
+		return fmt.Errorf("%w: %s", ErrEntityNotFound, id)
+	}
+	
+	delete(m.entities, id)
+	delete(m.ingested, id)
+	m.dropRelationsLocked(func(r *Relation) bool {
+		return r.From == entity || r.To == entity
+	})
+	return nil
+}
+
+// ListEntities returns the entities matching filter, ordered by ID
+func (m *Model) ListEntities(filter EntityFilter) []*Entity {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 	
-	// This is just a placeholder that would return some results
 	var entities []*Entity
-	var relations []*Relation
+	for _, entity := range m.entities {
+		if filter.matches(entity) {
+			entities = append(entities, entity)
+		}
+	}
+	sortEntities(entities)
+	return entities
+}
+
+// matches reports whether an entity passes the filter
+func (f EntityFilter) matches(entity *Entity) bool {
+	if len(f.Types) > 0 && !containsName(f.Types, entity.Type) {
+		return false
+	}
+	if f.Name != "" && !matchAny([]string{f.Name}, entity.Name) {
+		return false
+	}
+	if f.File != "" {
+		file, _ := entity.Properties["file"].(string)
+		if !matchAny([]string{f.File}, file) {
+			return false
+		}
+	}
+	for key, pattern := range f.Properties {
+		value, ok := entity.Properties[key]
+		if !ok || !matchAny([]string{pattern}, fmt.Sprint(value)) {
+			return false
+		}
+	}
+	return true
+}
+
+// AddRelation adds a relationship between entities of the model. Adding a
+// relation that already exists between the same entities has no effect.
+func (m *Model) AddRelation(relation *Relation) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	
-	// In a real implementation, we would use NLP/LLM to find relevant items
+	if relation.From == nil || relation.To == nil {
+		return errors.New("relation needs both a source and a target entity")
+	}
+	from, ok := m.entities[relation.From.ID]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrEntityNotFound, relation.From.ID)
+	}
+	to, ok := m.entities[relation.To.ID]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrEntityNotFound, relation.To.ID)
+	}
 	
-	return entities, relations
+	relation.From, relation.To = from, to
+	m.addRelationLocked(relation)
+	return nil
 }
 
-// UpdateFromAST updates the semantic model based on AST changes
-func (m *Model) UpdateFromAST(node interface{}) {
-	// This would update our semantic understanding based on AST changes
-	// Simplified for demonstration
+// RemoveRelation removes the relation of the given type between two
+// entities. It reports whether there was one.
+func (m *Model) RemoveRelation(relationType, fromID, toID string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	
+	removed := false
+	m.dropRelationsLocked(func(r *Relation) bool {
+		if r.Type != relationType || r.From.ID != fromID || r.To.ID != toID {
+			return false
+		}
+		removed = true
+		return true
+	})
+	return removed
 }
 
 // GenerateEntitiesFromIntent creates new entities based on natural language intent
@@ -0,0 +1,546 @@
+package semantics
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// QueryGrammar describes the query language accepted by ParseQuery. It is
+// also given to language models asked to translate questions into queries.
+const QueryGrammar = `A query is a list of space-separated clauses; all clauses must hold.
+  type:T[,T...]      entity type: Package, Struct, Interface, Type, Function, Method, Field, Variable, Constant
+  name:GLOB[,GLOB]   entity name, * and ? wildcards, case-insensitive
+  id:GLOB            entity ID, such as func:auth.Login or method:auth.Service.Login
+  KEY=GLOB           entity property, such as exported=true, file=*auth.go or receiver=*Service
+  follow:R[,R...]    also return what the matches reach through these relations (* for all)
+  reverse:R[,R...]   also return what reaches the matches through these relations (* for all)
+  depth:N            how many relations to follow, 1 by default
+  limit:N            return at most N matching entities
+Relations are Contains, Calls, Implements, Embeds, Uses and Returns.
+Example: type:Function,Method name:Login* reverse:Calls depth:2`
+
+// Query is a parsed query over the semantic graph. Entities matching every
+// filter are returned together with the entities reached from them by
+// following Follow relations forwards and Reverse relations backwards.
+type Query struct {
+	Types      []string
+	Names      []string
+	IDs        []string
+	Properties map[string]string
+	Follow     []string
+	Reverse    []string
+	Depth      int
+	Limit      int
+}
+
+// QueryTranslator turns a natural language question into a query in the
+// language described by QueryGrammar. It should give up when ctx is done.
+type QueryTranslator func(ctx context.Context, question string) (string, error)
+
+// ParseQuery parses a query written in the language described by
+// QueryGrammar
+func ParseQuery(text string) (*Query, error) {
+	q := &Query{Properties: map[string]string{}, Depth: 1}
+	clauses := strings.Fields(text)
+	if len(clauses) == 0 {
+		return nil, fmt.Errorf("empty query")
+	}
+
+	for _, clause := range clauses {
+		if key, value, ok := strings.Cut(clause, "="); ok {
+			if key == "" || value == "" {
+				return nil, fmt.Errorf("invalid property clause %q", clause)
+			}
+			q.Properties[key] = value
+			continue
+		}
+
+		key, value, ok := strings.Cut(clause, ":")
+		if !ok || value == "" {
+			return nil, fmt.Errorf("invalid query clause %q", clause)
+		}
+		list := strings.Split(value, ",")
+		switch strings.ToLower(key) {
+		case "type":
+			for _, t := range list {
+				name, ok := canonicalName(t, entityTypes)
+				if !ok {
+					return nil, fmt.Errorf("unknown entity type %q", t)
+				}
+				q.Types = append(q.Types, name)
+			}
+		case "name":
+			q.Names = append(q.Names, list...)
+		case "id":
+			q.IDs = append(q.IDs, list...)
+		case "follow", "reverse":
+			var relations []string
+			for _, r := range list {
+				name, ok := canonicalName(r, relationTypes)
+				if !ok && r != "*" {
+					return nil, fmt.Errorf("unknown relation type %q", r)
+				}
+				if r == "*" {
+					name = r
+				}
+				relations = append(relations, name)
+			}
+			if strings.ToLower(key) == "follow" {
+				q.Follow = append(q.Follow, relations...)
+			} else {
+				q.Reverse = append(q.Reverse, relations...)
+			}
+		case "depth", "limit":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("invalid %s %q", key, value)
+			}
+			if strings.ToLower(key) == "depth" {
+				q.Depth = n
+			} else {
+				q.Limit = n
+			}
+		default:
+			return nil, fmt.Errorf("unknown query clause %q", key)
+		}
+	}
+	return q, nil
+}
+
+// String formats the query so that ParseQuery reads it back
+func (q *Query) String() string {
+	var clauses []string
+	list := func(key string, values []string) {
+		if len(values) > 0 {
+			clauses = append(clauses, key+":"+strings.Join(values, ","))
+		}
+	}
+	list("type", q.Types)
+	list("name", q.Names)
+	list("id", q.IDs)
+
+	keys := make([]string, 0, len(q.Properties))
+	for key := range q.Properties {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		clauses = append(clauses, key+"="+q.Properties[key])
+	}
+
+	list("follow", q.Follow)
+	list("reverse", q.Reverse)
+	if (len(q.Follow) > 0 || len(q.Reverse) > 0) && q.Depth != 1 {
+		clauses = append(clauses, "depth:"+strconv.Itoa(q.Depth))
+	}
+	if q.Limit > 0 {
+		clauses = append(clauses, "limit:"+strconv.Itoa(q.Limit))
+	}
+	return strings.Join(clauses, " ")
+}
+
+// Query returns the entities matching q, followed by the entities reached
+// from them, and the relations that were followed. Without follow or
+// reverse clauses the relations between the matching entities are
+// returned instead. Results are ordered by entity ID.
+func (m *Model) Query(q *Query) ([]*Entity, []*Relation) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var matches []*Entity
+	for _, entity := range m.entities {
+		if q.matches(entity) {
+			matches = append(matches, entity)
+		}
+	}
+	sortEntities(matches)
+	if q.Limit > 0 && len(matches) > q.Limit {
+		matches = matches[:q.Limit]
+	}
+
+	included := map[*Entity]bool{}
+	for _, entity := range matches {
+		included[entity] = true
+	}
+
+	var relations []*Relation
+	if len(q.Follow) == 0 && len(q.Reverse) == 0 {
+		for _, relation := range m.relations {
+			if included[relation.From] && included[relation.To] {
+				relations = append(relations, relation)
+			}
+		}
+		return matches, relations
+	}
+
+	// Walk breadth-first in both directions from the matches
+	incoming := map[*Entity][]*Relation{}
+	if len(q.Reverse) > 0 {
+		for _, relation := range m.relations {
+			incoming[relation.To] = append(incoming[relation.To], relation)
+		}
+	}
+	followed := map[*Relation]bool{}
+	var reached []*Entity
+	walk := func(kinds []string, next func(*Entity) []*Relation, other func(*Relation) *Entity) {
+		if len(kinds) == 0 {
+			return
+		}
+		frontier := matches
+		visited := map[*Entity]bool{}
+		for _, entity := range matches {
+			visited[entity] = true
+		}
+		for depth := 0; depth < q.Depth && len(frontier) > 0; depth++ {
+			var nextFrontier []*Entity
+			for _, entity := range frontier {
+				for _, relation := range next(entity) {
+					if !containsName(kinds, relation.Type) {
+						continue
+					}
+					if !followed[relation] {
+						followed[relation] = true
+						relations = append(relations, relation)
+					}
+					target := other(relation)
+					if visited[target] {
+						continue
+					}
+					visited[target] = true
+					nextFrontier = append(nextFrontier, target)
+					if !included[target] {
+						included[target] = true
+						reached = append(reached, target)
+					}
+				}
+			}
+			frontier = nextFrontier
+		}
+	}
+	walk(q.Follow,
+		func(e *Entity) []*Relation { return e.Relations },
+		func(r *Relation) *Entity { return r.To })
+	walk(q.Reverse,
+		func(e *Entity) []*Relation { return incoming[e] },
+		func(r *Relation) *Entity { return r.From })
+
+	sortEntities(reached)
+	sort.SliceStable(relations, func(i, j int) bool {
+		a, b := relations[i], relations[j]
+		if a.From.ID != b.From.ID {
+			return a.From.ID < b.From.ID
+		}
+		if a.Type != b.Type {
+			return a.Type < b.Type
+		}
+		return a.To.ID < b.To.ID
+	})
+	return append(matches, reached...), relations
+}
+
+// matches reports whether an entity passes every filter of the query
+func (q *Query) matches(entity *Entity) bool {
+	if len(q.Types) > 0 && !containsName(q.Types, entity.Type) {
+		return false
+	}
+	if len(q.Names) > 0 && !matchAny(q.Names, entity.Name) {
+		return false
+	}
+	if len(q.IDs) > 0 && !matchAny(q.IDs, entity.ID) {
+		return false
+	}
+	for key, pattern := range q.Properties {
+		value, ok := entity.Properties[key]
+		if !ok || !matchAny([]string{pattern}, fmt.Sprint(value)) {
+			return false
+		}
+	}
+	return true
+}
+
+// QueryByIntent finds entities and relations based on natural language
+// intent. The question is translated into a query by the translator set
+// with SetQueryTranslator when there is one, and by keyword heuristics
+// otherwise or when the translation is unusable.
+func (m *Model) QueryByIntent(intent string) ([]*Entity, []*Relation) {
+	return m.QueryByIntentContext(context.Background(), intent)
+}
+
+// QueryByIntentContext is QueryByIntent with a context for the translator.
+// Nothing is returned once ctx is done.
+func (m *Model) QueryByIntentContext(ctx context.Context, intent string) ([]*Entity, []*Relation) {
+	m.mu.RLock()
+	translate := m.translator
+	m.mu.RUnlock()
+
+	return m.QueryByIntentWith(ctx, intent, translate)
+}
+
+// QueryByIntentWith is QueryByIntentContext with the translator of a single
+// call instead of the one set with SetQueryTranslator; nil uses keyword
+// heuristics
+func (m *Model) QueryByIntentWith(ctx context.Context, intent string, translate QueryTranslator) ([]*Entity, []*Relation) {
+	if translate != nil {
+		text, err := translate(ctx, intent)
+		if ctx.Err() != nil {
+			return nil, nil
+		}
+		if err == nil {
+			var q *Query
+			if q, err = ParseQuery(text); err == nil {
+				log.Printf("Query for %q: %s", intent, q)
+				return m.Query(q)
+			}
+		}
+		log.Printf("Warning: Could not translate intent into a query, using keywords: %v", err)
+	}
+
+	q := m.HeuristicQuery(intent)
+	if q == nil {
+		return nil, nil
+	}
+	log.Printf("Query for %q: %s", intent, q)
+	return m.Query(q)
+}
+
+// SetQueryTranslator sets the translator used by QueryByIntent; nil
+// restores the keyword heuristics
+func (m *Model) SetQueryTranslator(translator QueryTranslator) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.translator = translator
+}
+
+// Keywords recognised by HeuristicQuery
+var (
+	typeKeywords = map[string][]string{
+		"function":   {TypeFunction, TypeMethod},
+		"functions":  {TypeFunction, TypeMethod},
+		"func":       {TypeFunction, TypeMethod},
+		"method":     {TypeMethod},
+		"methods":    {TypeMethod},
+		"struct":     {TypeStruct},
+		"structs":    {TypeStruct},
+		"class":      {TypeStruct},
+		"classes":    {TypeStruct},
+		"interface":  {TypeInterface},
+		"interfaces": {TypeInterface},
+		"type":       {TypeStruct, TypeInterface, TypeNamed},
+		"types":      {TypeStruct, TypeInterface, TypeNamed},
+		"field":      {TypeField},
+		"fields":     {TypeField},
+		"variable":   {TypeVariable},
+		"variables":  {TypeVariable},
+		"var":        {TypeVariable},
+		"constant":   {TypeConstant},
+		"constants":  {TypeConstant},
+		"const":      {TypeConstant},
+		"package":    {TypePackage},
+		"packages":   {TypePackage},
+		"module":     {TypePackage},
+	}
+	reversePhrases = []string{"who calls", "what calls", "callers", "called by", "uses of", "usages", "used by",
+		"references to", "depends on", "depend on", "dependents", "reverse dependencies", "impact", "affected"}
+	followPhrases = []string{"what does", "calls made", "callees", "dependencies of", "depends upon", "uses what"}
+	deepPhrases   = []string{"transitive", "transitively", "indirect", "indirectly", "all the way"}
+)
+
+// HeuristicQuery builds a query from keywords in a natural language
+// question. Words naming entities of the model become name filters and
+// words such as "function" or "interface" become type filters. It returns
+// nil when the question names nothing the model knows about.
+func (m *Model) HeuristicQuery(question string) *Query {
+	m.mu.RLock()
+	names := map[string]string{}
+	for _, entity := range m.entities {
+		names[strings.ToLower(entity.Name)] = entity.Name
+	}
+	m.mu.RUnlock()
+
+	lower := strings.ToLower(question)
+	q := &Query{Properties: map[string]string{}, Depth: 1}
+	words := strings.FieldsFunc(question, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_' && r != '*' && r != '?' && r != '.'
+	})
+	for i, word := range words {
+		word = strings.Trim(word, ".")
+		lowerWord := strings.ToLower(word)
+		if types, ok := typeKeywords[lowerWord]; ok {
+			for _, t := range types {
+				if !containsName(q.Types, t) {
+					q.Types = append(q.Types, t)
+				}
+			}
+			continue
+		}
+		switch lowerWord {
+		case "exported", "public":
+			q.Properties["exported"] = "true"
+			continue
+		case "unexported", "private":
+			q.Properties["exported"] = "false"
+			continue
+		case "depth", "levels":
+			if n, err := strconv.Atoi(neighbour(words, i)); err == nil && n > 0 {
+				q.Depth = n
+			}
+			continue
+		}
+		if strings.HasSuffix(lowerWord, ".go") {
+			q.Properties["file"] = "*" + word
+			continue
+		}
+
+		// Qualified names such as Service.Login name the last element
+		if dot := strings.LastIndex(word, "."); dot >= 0 {
+			word = word[dot+1:]
+			lowerWord = strings.ToLower(word)
+		}
+		if strings.ContainsAny(word, "*?") {
+			q.Names = append(q.Names, word)
+		} else if name, ok := names[lowerWord]; ok && !isStopWord(lowerWord) && !containsName(q.Names, name) {
+			q.Names = append(q.Names, name)
+		}
+	}
+
+	var relations []string
+	if containsAny(lower, reversePhrases) || strings.Contains(lower, "who uses") {
+		relations = []string{RelCalls, RelUses, RelEmbeds, RelImplements}
+		if strings.Contains(lower, "call") {
+			relations = []string{RelCalls}
+		}
+		q.Reverse = relations
+	} else if containsAny(lower, followPhrases) {
+		relations = []string{RelCalls, RelUses}
+		if strings.Contains(lower, "call") {
+			relations = []string{RelCalls}
+		}
+		q.Follow = relations
+	}
+	if relations != nil && containsAny(lower, deepPhrases) && q.Depth == 1 {
+		q.Depth = 5
+	}
+
+	// A type alone is enough for listing questions, but a question that
+	// names nothing at all cannot be answered
+	if len(q.Names) == 0 && len(q.Types) == 0 && len(q.Properties) == 0 {
+		return nil
+	}
+	return q
+}
+
+// Entity and relation type names accepted in queries
+var (
+	entityTypes = []string{TypePackage, TypeStruct, TypeInterface, TypeNamed,
+		TypeFunction, TypeMethod, TypeField, TypeVariable, TypeConstant}
+	relationTypes = []string{RelContains, RelCalls, RelImplements, RelEmbeds, RelUses, RelReturns}
+)
+
+// canonicalName finds name in names ignoring case
+func canonicalName(name string, names []string) (string, bool) {
+	for _, candidate := range names {
+		if strings.EqualFold(candidate, name) {
+			return candidate, true
+		}
+	}
+	return "", false
+}
+
+// containsName reports whether names contains name, or the "*" wildcard
+func containsName(names []string, name string) bool {
+	for _, candidate := range names {
+		if candidate == name || candidate == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// matchAny reports whether value matches one of the glob patterns,
+// ignoring case
+func matchAny(patterns []string, value string) bool {
+	value = strings.ToLower(value)
+	for _, pattern := range patterns {
+		if wildcardMatch(strings.ToLower(pattern), value) {
+			return true
+		}
+	}
+	return false
+}
+
+// wildcardMatch matches value against a pattern in which * stands for any
+// run of characters, including slashes, and ? for a single character
+func wildcardMatch(pattern, value string) bool {
+	p, v := []rune(pattern), []rune(value)
+	star, mark := -1, 0
+	for i, j := 0, 0; j < len(v); {
+		switch {
+		case i < len(p) && (p[i] == '?' || p[i] == v[j]):
+			i++
+			j++
+		case i < len(p) && p[i] == '*':
+			star, mark = i, j
+			i++
+		case star >= 0:
+			i = star + 1
+			mark++
+			j = mark
+		default:
+			return false
+		}
+		if j == len(v) {
+			for i < len(p) && p[i] == '*' {
+				i++
+			}
+			return i == len(p)
+		}
+	}
+	return strings.Trim(pattern, "*") == ""
+}
+
+// containsAny reports whether text contains one of the phrases
+func containsAny(text string, phrases []string) bool {
+	for _, phrase := range phrases {
+		if strings.Contains(text, phrase) {
+			return true
+		}
+	}
+	return false
+}
+
+// neighbour returns the word before or after words[i] that is a number
+func neighbour(words []string, i int) string {
+	for _, j := range []int{i - 1, i + 1} {
+		if j >= 0 && j < len(words) {
+			if _, err := strconv.Atoi(words[j]); err == nil {
+				return words[j]
+			}
+		}
+	}
+	return ""
+}
+
+// isStopWord reports whether a word is too common to be taken as the name
+// of an entity even when one has that name
+func isStopWord(word string) bool {
+	switch word {
+	case "a", "an", "the", "to", "of", "in", "on", "for", "and", "or", "is", "it", "by", "with", "from",
+		"all", "add", "get", "set", "new", "make", "create", "modify", "change", "delete", "remove",
+		"find", "show", "list", "query", "what", "who", "which", "where", "calls", "uses", "error", "string":
+		return true
+	}
+	return false
+}
+
+// sortEntities orders entities by ID
+func sortEntities(entities []*Entity) {
+	sort.Slice(entities, func(i, j int) bool {
+		return entities[i].ID < entities[j].ID
+	})
+}
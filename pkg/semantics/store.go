@@ -0,0 +1,442 @@
+package semantics
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// StoreVersion is the version of the file format written by Save.
+//
+//	1: entities, relations and the facts of every file
+//	2: adds the IDs of the entities only known from Ingest
+const StoreVersion = 2
+
+// StoreFile is where a workspace keeps its semantic model, relative to the
+// workspace directory
+const StoreFile = ".ainative/semantics.json"
+
+// storeFormat identifies semantic model files
+const storeFormat = "ai-native-semantics"
+
+// ErrCorruptStore is returned when a saved model cannot be read back
+var ErrCorruptStore = errors.New("semantic model file is corrupt")
+
+// storeMigrations upgrade a decoded model document from the version they
+// are keyed by to the next one. Loading applies them in order, so files
+// written by older versions keep working after the format changes.
+var storeMigrations = map[int]func(model map[string]interface{}) error{
+	1: migrateIngested,
+}
+
+// migrateIngested upgrades a version 1 document, which did not list the
+// entities only known from Ingest. They are the entities marked as coming
+// from the language model that no file declares.
+func migrateIngested(model map[string]interface{}) error {
+	owned := map[string]bool{}
+	files, _ := model["files"].(map[string]interface{})
+	for _, file := range files {
+		facts, _ := file.(map[string]interface{})
+		ids, _ := facts["entities"].([]interface{})
+		for _, id := range ids {
+			if s, ok := id.(string); ok {
+				owned[s] = true
+			}
+		}
+	}
+
+	entities, ok := model["entities"].([]interface{})
+	if !ok && model["entities"] != nil {
+		return errors.New("entities is not a list")
+	}
+	var ingested []interface{}
+	for _, e := range entities {
+		entity, _ := e.(map[string]interface{})
+		id, _ := entity["id"].(string)
+		properties, _ := entity["properties"].(map[string]interface{})
+		if id != "" && !owned[id] && properties["source"] == "llm" {
+			ingested = append(ingested, id)
+		}
+	}
+	if len(ingested) > 0 {
+		model["ingested"] = ingested
+	}
+	return nil
+}
+
+// storeEnvelope is the top-level form of a saved model. The checksum
+// covers the exact bytes of Model.
+type storeEnvelope struct {
+	Format   string          `json:"format"`
+	Version  int             `json:"version"`
+	Checksum string          `json:"checksum"`
+	Model    json.RawMessage `json:"model"`
+}
+
+// storedModel is the saved form of a model. Relations resolved across
+// files are not saved; they are rebuilt from the references on load.
+type storedModel struct {
+	Entities  []*storedEntity        `json:"entities"`
+	Relations []*storedRelation      `json:"relations"`
+	Files     map[string]*storedFile `json:"files"`
+	Ingested  []string               `json:"ingested,omitempty"`
+}
+
+type storedEntity struct {
+	ID          string                 `json:"id"`
+	Type        string                 `json:"type"`
+	Name        string                 `json:"name"`
+	Description string                 `json:"description,omitempty"`
+	Properties  map[string]interface{} `json:"properties,omitempty"`
+}
+
+type storedRelation struct {
+	Type     string                 `json:"type"`
+	From     string                 `json:"from"`
+	To       string                 `json:"to"`
+	Metadata map[string]interface{} `json:"metadata,omitempty"`
+}
+
+type storedFile struct {
+	Package    string             `json:"package"`
+	Entities   []string           `json:"entities"`
+	References []*storedReference `json:"references,omitempty"`
+	ModTime    int64              `json:"modTime,omitempty"`
+	Size       int64              `json:"size,omitempty"`
+}
+
+type storedReference struct {
+	Kind       string   `json:"kind"`
+	From       string   `json:"from"`
+	Candidates []string `json:"candidates"`
+}
+
+// Save writes the model to path. The file is written next to its final
+// location and renamed into place, so a crash never leaves a partial file.
+func (m *Model) Save(path string) error {
+	m.mu.RLock()
+	stored := m.storedLocked()
+	m.mu.RUnlock()
+
+	model, err := json.Marshal(stored)
+	if err != nil {
+		return fmt.Errorf("error encoding semantic model: %w", err)
+	}
+	sum := sha256.Sum256(model)
+	data, err := json.Marshal(storeEnvelope{
+		Format:   storeFormat,
+		Version:  StoreVersion,
+		Checksum: hex.EncodeToString(sum[:]),
+		Model:    model,
+	})
+	if err != nil {
+		return fmt.Errorf("error encoding semantic model: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// LoadModel reads a model written by Save. Files written by older versions
+// are migrated; damaged files are reported with an error wrapping
+// ErrCorruptStore, after which the caller should index from scratch.
+func LoadModel(path string) (*Model, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var envelope storeEnvelope
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCorruptStore, err)
+	}
+	if envelope.Format != storeFormat {
+		return nil, fmt.Errorf("%w: not a semantic model file", ErrCorruptStore)
+	}
+	sum := sha256.Sum256(envelope.Model)
+	if hex.EncodeToString(sum[:]) != envelope.Checksum {
+		return nil, fmt.Errorf("%w: checksum mismatch", ErrCorruptStore)
+	}
+	if envelope.Version > StoreVersion {
+		return nil, fmt.Errorf("semantic model file version %d is newer than supported version %d", envelope.Version, StoreVersion)
+	}
+
+	raw := envelope.Model
+	if envelope.Version < StoreVersion {
+		if raw, err = migrateStore(raw, envelope.Version); err != nil {
+			return nil, err
+		}
+	}
+
+	var stored storedModel
+	if err := json.Unmarshal(raw, &stored); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCorruptStore, err)
+	}
+
+	m := NewModel()
+	if err := m.restoreLocked(&stored); err != nil {
+		return nil, err
+	}
+	m.resolveLocked()
+	return m, nil
+}
+
+// migrateStore upgrades a model document from version to StoreVersion
+func migrateStore(raw json.RawMessage, version int) (json.RawMessage, error) {
+	var doc map[string]interface{}
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCorruptStore, err)
+	}
+	for v := version; v < StoreVersion; v++ {
+		migrate, ok := storeMigrations[v]
+		if !ok {
+			return nil, fmt.Errorf("no migration for semantic model file version %d", v)
+		}
+		if err := migrate(doc); err != nil {
+			return nil, fmt.Errorf("error migrating semantic model file from version %d: %w", v, err)
+		}
+	}
+	return json.Marshal(doc)
+}
+
+// storedLocked converts the model to its saved form
+func (m *Model) storedLocked() *storedModel {
+	stored := &storedModel{Files: map[string]*storedFile{}}
+	for _, entity := range m.entities {
+		stored.Entities = append(stored.Entities, &storedEntity{
+			ID:          entity.ID,
+			Type:        entity.Type,
+			Name:        entity.Name,
+			Description: entity.Description,
+			Properties:  entity.Properties,
+		})
+	}
+	sortStored(stored.Entities)
+
+	for _, relation := range m.relations {
+		if relation.Metadata["resolved"] == true {
+			continue
+		}
+		stored.Relations = append(stored.Relations, &storedRelation{
+			Type:     relation.Type,
+			From:     relation.From.ID,
+			To:       relation.To.ID,
+			Metadata: relation.Metadata,
+		})
+	}
+
+	for id := range m.ingested {
+		stored.Ingested = append(stored.Ingested, id)
+	}
+	sort.Strings(stored.Ingested)
+
+	for filename, facts := range m.files {
+		file := &storedFile{
+			Package:  facts.pkg,
+			Entities: facts.entities,
+			ModTime:  facts.modTime,
+			Size:     facts.size,
+		}
+		for _, ref := range facts.references {
+			file.References = append(file.References, &storedReference{
+				Kind:       ref.kind,
+				From:       ref.from,
+				Candidates: ref.candidates,
+			})
+		}
+		stored.Files[filename] = file
+	}
+	return stored
+}
+
+// restoreLocked fills an empty model from its saved form
+func (m *Model) restoreLocked(stored *storedModel) error {
+	for _, se := range stored.Entities {
+		if se == nil || se.ID == "" {
+			return fmt.Errorf("%w: entity without an ID", ErrCorruptStore)
+		}
+		m.entities[se.ID] = &Entity{
+			ID:          se.ID,
+			Type:        se.Type,
+			Name:        se.Name,
+			Description: se.Description,
+			Properties:  normalizeProperties(se.Properties),
+		}
+	}
+
+	for _, sr := range stored.Relations {
+		if sr == nil {
+			return fmt.Errorf("%w: null relation", ErrCorruptStore)
+		}
+		from, ok := m.entities[sr.From]
+		to, ok2 := m.entities[sr.To]
+		if !ok || !ok2 {
+			return fmt.Errorf("%w: relation %s between unknown entities %q and %q", ErrCorruptStore, sr.Type, sr.From, sr.To)
+		}
+		m.addRelationLocked(&Relation{Type: sr.Type, From: from, To: to, Metadata: sr.Metadata})
+	}
+
+	for filename, sf := range stored.Files {
+		if sf == nil {
+			return fmt.Errorf("%w: no facts for file %q", ErrCorruptStore, filename)
+		}
+		facts := &fileFacts{pkg: sf.Package, entities: sf.Entities, modTime: sf.ModTime, size: sf.Size}
+		for _, sr := range sf.References {
+			facts.references = append(facts.references, reference{kind: sr.Kind, from: sr.From, candidates: sr.Candidates})
+		}
+		m.files[filename] = facts
+		m.indexReferencesLocked(filename, facts, true)
+	}
+
+	for _, id := range stored.Ingested {
+		if _, ok := m.entities[id]; !ok {
+			return fmt.Errorf("%w: unknown ingested entity %q", ErrCorruptStore, id)
+		}
+		m.ingested[id] = true
+	}
+	return nil
+}
+
+// Refresh brings the model up to date with the Go files below root. Files
+// whose size or modification time changed since they were indexed are
+// extracted again, new files are added and deleted files are removed, so
+// a saved model only costs the work for what changed since it was saved.
+// Files are extracted again from their syntax alone: relations the
+// type-checking Loader resolved for a changed file are replaced by the
+// name-based ones, and only loading the module again restores them.
+func (m *Model) Refresh(root string) (updated, removed int, err error) {
+	root, err = filepath.Abs(root)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	onDisk := map[string]os.FileInfo{}
+	err = filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		name := info.Name()
+		if info.IsDir() {
+			if path != root && (name == "vendor" || name == "testdata" || strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_")) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if strings.HasSuffix(name, ".go") && !strings.HasSuffix(name, "_test.go") {
+			onDisk[path] = info
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+
+	m.mu.RLock()
+	var stale, gone []string
+	qualifiers := map[string]string{}
+	for path, info := range onDisk {
+		facts, ok := m.files[path]
+		if ok {
+			qualifiers[path] = strings.TrimPrefix(facts.pkg, "package:")
+		}
+		if !ok || facts.size != info.Size() || facts.modTime != info.ModTime().UnixNano() {
+			stale = append(stale, path)
+		}
+	}
+	for filename := range m.files {
+		if _, ok := onDisk[filename]; !ok && strings.HasPrefix(filename, root+string(filepath.Separator)) {
+			gone = append(gone, filename)
+		}
+	}
+	m.mu.RUnlock()
+
+	var extracted []*extractor
+	for _, path := range stale {
+		fset := token.NewFileSet()
+		file, err := parser.ParseFile(fset, path, nil, parser.ParseComments)
+		if err != nil {
+			// Keep what was known about files that stopped parsing
+			continue
+		}
+		qualifier := qualifiers[path]
+		if qualifier == "" {
+			qualifier = fileQualifier(path, file.Name.Name)
+		}
+		e := newExtractor(fset, path, file.Name.Name, qualifier)
+		e.extractFile(file)
+		extracted = append(extracted, e)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	changes := newChangeSet()
+	for _, filename := range gone {
+		m.removeFileLocked(filename, changes)
+	}
+	for _, e := range extracted {
+		m.replaceFileLocked(e, changes)
+	}
+	m.resolveChangesLocked(changes)
+	return len(extracted), len(gone), nil
+}
+
+// normalizeProperties restores the Go types of property values after they
+// went through encoding/json
+func normalizeProperties(properties map[string]interface{}) map[string]interface{} {
+	result := make(map[string]interface{}, len(properties))
+	for key, value := range properties {
+		switch v := value.(type) {
+		case float64:
+			if v == float64(int(v)) {
+				value = int(v)
+			}
+		case []interface{}:
+			strs := make([]string, 0, len(v))
+			for _, item := range v {
+				if s, ok := item.(string); ok {
+					strs = append(strs, s)
+				}
+			}
+			if len(strs) == len(v) {
+				value = strs
+			}
+		}
+		result[key] = value
+	}
+	return result
+}
+
+// sortStored orders saved entities by ID so that saving an unchanged model
+// produces the same file
+func sortStored(entities []*storedEntity) {
+	sort.Slice(entities, func(i, j int) bool {
+		return entities[i].ID < entities[j].ID
+	})
+}
@@ -0,0 +1,144 @@
+package semantics
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSaveLoad(t *testing.T) {
+	m := NewModel()
+	updateFile(t, m, "a.go", `package p
+// Run runs.
+func Run() { Helper() }
+`)
+	updateFile(t, m, "b.go", `package p
+func Helper() {}
+`)
+	ingestLogin(t, m)
+
+	path := filepath.Join(t.TempDir(), StoreFile)
+	if err := m.Save(path); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	loaded, err := LoadModel(path)
+	if err != nil {
+		t.Fatalf("LoadModel: %v", err)
+	}
+
+	run, ok := loaded.GetEntity("func:p.Run")
+	if !ok || run.Description != "Run runs." || run.Properties["line"] != 3 {
+		t.Errorf("entity not restored: %+v", run)
+	}
+	if !hasRelation(loaded, RelCalls, "func:p.Run", "func:p.Helper") {
+		t.Error("resolved relation not rebuilt on load")
+	}
+	if !hasRelation(loaded, RelCalls, "func:auth.Login", "func:auth.Audit") {
+		t.Error("ingested relation not restored")
+	}
+	if n := loaded.RemoveIngested(); n != 3 {
+		t.Errorf("%d ingested entities restored, want 3", n)
+	}
+}
+
+func TestLoadDetectsCorruption(t *testing.T) {
+	m := NewModel()
+	updateFile(t, m, "a.go", "package p\nfunc Run() {}\n")
+	path := filepath.Join(t.TempDir(), "model.json")
+	if err := m.Save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered := strings.Replace(string(data), "Run", "Walk", 1)
+	if tampered == string(data) {
+		t.Fatal("nothing to tamper with")
+	}
+	if err := os.WriteFile(path, []byte(tampered), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadModel(path); !errors.Is(err, ErrCorruptStore) {
+		t.Errorf("got %v, want ErrCorruptStore for a checksum mismatch", err)
+	}
+
+	if err := os.WriteFile(path, []byte(`{"format": "ai-native-semantics", "version": 2`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadModel(path); !errors.Is(err, ErrCorruptStore) {
+		t.Errorf("got %v, want ErrCorruptStore for a truncated file", err)
+	}
+}
+
+func TestLoadMigratesVersion1(t *testing.T) {
+	// A version 1 document has no list of ingested entities
+	model := `{
+  "entities": [
+    {"id": "package:p", "type": "Package", "name": "p"},
+    {"id": "func:p.Run", "type": "Function", "name": "Run", "properties": {"file": "a.go", "source": "llm"}},
+    {"id": "func:p.Plan", "type": "Function", "name": "Plan", "properties": {"source": "llm"}}
+  ],
+  "relations": [
+    {"type": "Calls", "from": "func:p.Run", "to": "func:p.Plan", "metadata": {"source": "llm"}}
+  ],
+  "files": {
+    "a.go": {"package": "package:p", "entities": ["package:p", "func:p.Run"]}
+  }
+}`
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, []byte(model)); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(compact.Bytes())
+	data, err := json.Marshal(storeEnvelope{
+		Format:   storeFormat,
+		Version:  1,
+		Checksum: hex.EncodeToString(sum[:]),
+		Model:    compact.Bytes(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "model.json")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := LoadModel(path)
+	if err != nil {
+		t.Fatalf("LoadModel: %v", err)
+	}
+	if !hasRelation(loaded, RelCalls, "func:p.Run", "func:p.Plan") {
+		t.Error("relation lost in migration")
+	}
+	if n := loaded.RemoveIngested(); n != 1 {
+		t.Errorf("%d entities migrated as ingested, want 1", n)
+	}
+	if _, ok := loaded.GetEntity("func:p.Run"); !ok {
+		t.Error("entity declared by a file was treated as ingested")
+	}
+}
+
+func TestRefreshQualifiesByDirectory(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "main.go", "package main\nfunc main() {}\n")
+	writeFile(t, dir, "cmd/tool/main.go", "package main\nfunc main() {}\n")
+
+	m := NewModel()
+	if updated, _, err := m.Refresh(dir); err != nil || updated != 2 {
+		t.Fatalf("Refresh: %d files updated, %v", updated, err)
+	}
+	for _, qualifier := range []string{dir, filepath.Join(dir, "cmd", "tool")} {
+		if _, ok := m.GetEntity("func:" + filepath.ToSlash(qualifier) + ".main"); !ok {
+			t.Errorf("main of %s missing", qualifier)
+		}
+	}
+}
@@ -1,10 +1,17 @@
 package server
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
 	
 	"github.com/knoxai/AI-Native-Development-System/pkg/ast"
 	"github.com/knoxai/AI-Native-Development-System/pkg/intent"
@@ -17,15 +24,47 @@ type Server struct {
 	intentProcessor *intent.Processor
 	astProcessor    *ast.Processor
 	semanticModel   *semantics.Model
-	llmClient       *llm.Client
+	llmClient       llm.Provider
+	// demoProvider answers intents when there is neither a provider nor
+	// an API key, so that the API can be tried offline
+	demoProvider    *llm.FakeProvider
+	// usage counts the usage and cost of the requests to the LLM
+	usage           *llm.UsageTracker
+	// model is the model selected for the server's provider, used by
+	// requests naming none; the shared provider itself is not changed
+	mu              sync.RWMutex
+	model           string
 }
 
 // New creates a new server
 func New(intentProc *intent.Processor, astProc *ast.Processor, semModel *semantics.Model) *Server {
-	// Initialize LLM client
-	client, err := llm.NewClient()
+	// Count usage for the project in the working directory, where the
+	// web UI is served from too
+	dir, _ := os.Getwd()
+	usage, err := llm.NewUsageTracker(filepath.Join(dir, llm.UsageFile), dir)
 	if err != nil {
+		log.Printf("Warning: Could not load usage totals: %v", err)
+	}
+	usage.SetBudget(llm.BudgetFromEnv())
+	
+	// Initialize the LLM provider configured by the environment
+	var client llm.Provider
+	if provider, err := llm.NewProviderFromEnv(); err != nil {
 		log.Printf("Warning: Could not initialize LLM client: %v", err)
+	} else {
+		client = llm.NewMeteredProvider(provider, usage)
+	}
+	
+	// Index the project in the working directory in the background
+	if semModel != nil {
+		go func() {
+			updated, removed, err := semModel.LoadDir(dir)
+			if err != nil {
+				log.Printf("Warning: Could not index %s: %v", dir, err)
+				return
+			}
+			log.Printf("Semantic model: %d files indexed, %d removed", updated, removed)
+		}()
 	}
 	
 	return &Server{
@@ -33,6 +72,8 @@ func New(intentProc *intent.Processor, astProc *ast.Processor, semModel *semanti
 		astProcessor:    astProc,
 		semanticModel:   semModel,
 		llmClient:       client,
+		demoProvider:    intent.NewDemoProvider(semModel),
+		usage:           usage,
 	}
 }
 
@@ -43,18 +84,27 @@ func (s *Server) Start(addr string) error {
 	// Intent-based API endpoint
 	mux.HandleFunc("/api/intent", s.handleIntent)
 	
+	// Intent endpoint streaming generated code as server-sent events
+	mux.HandleFunc("/api/intent/stream", s.handleIntentStream)
+	
 	// AST manipulation endpoint
 	mux.HandleFunc("/api/ast", s.handleAST)
 	
 	// Semantic model query endpoint
 	mux.HandleFunc("/api/semantics", s.handleSemantics)
 	
+	// Semantic graph export endpoint
+	mux.HandleFunc("/api/semantics/export", s.handleSemanticsExport)
+	
 	// Models list endpoint
 	mux.HandleFunc("/api/models", s.handleModels)
 	
 	// Model selection endpoint
 	mux.HandleFunc("/api/models/select", s.handleModelSelect)
 	
+	// Usage and cost endpoint
+	mux.HandleFunc("/api/usage", s.handleUsage)
+	
 	// Health check
 	mux.HandleFunc("/health", s.handleHealth)
 	
@@ -90,7 +140,7 @@ func (s *Server) handleModels(w http.ResponseWriter, r *http.Request) {
 	}
 	
 	// Try to use client-provided API key or server's LLM client
-	var client *llm.Client
+	var client llm.Provider
 	var err error
 	
 	if clientAPIKey != "" {
@@ -111,8 +161,8 @@ func (s *Server) handleModels(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	
-	// Fetch models from OpenRouter
-	models, err := client.GetAvailableModels()
+	// Fetch models from OpenRouter, giving up when the client disconnects
+	models, err := client.GetAvailableModelsContext(r.Context())
 	if err != nil {
 		log.Printf("Error fetching models: %v", err)
 		http.Error(w, "Failed to fetch models: "+err.Error(), http.StatusInternalServerError)
@@ -186,8 +236,10 @@ func (s *Server) handleModelSelect(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	
-	// Set the model in the LLM client
-	s.llmClient.SetModel(req.ModelID)
+	// Select the model for the requests naming none
+	s.mu.Lock()
+	s.model = req.ModelID
+	s.mu.Unlock()
 	log.Printf("Model set to: %s", req.ModelID)
 	
 	// Return success response
@@ -199,6 +251,13 @@ func (s *Server) handleModelSelect(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// intentRequest is the body of intent requests
+type intentRequest struct {
+	Intent  string `json:"intent"`
+	ModelID string `json:"model_id"`
+	APIKey  string `json:"api_key"`
+}
+
 // handleIntent processes intent-based requests
 func (s *Server) handleIntent(w http.ResponseWriter, r *http.Request) {
 	log.Println("Received intent request")
@@ -208,12 +267,60 @@ func (s *Server) handleIntent(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	
-	var req struct {
-		Intent  string `json:"intent"`
-		ModelID string `json:"model_id"`
-		APIKey  string `json:"api_key"`
+	var req intentRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("Error decoding request: %v", err)
+		http.Error(w, "Bad request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	
+	log.Printf("Processing intent: %s", req.Intent)
+	opts := s.intentOptions(req)
+	
+	// Parse and execute the intent
+	parsedIntent, err := s.intentProcessor.ParseIntentContext(r.Context(), req.Intent, opts)
+	if err != nil {
+		log.Printf("Error parsing intent: %v", err)
+		http.Error(w, "Failed to parse intent: "+err.Error(), llmErrorStatus(err, http.StatusBadRequest))
+		return
+	}
+	
+	// Execute the intent
+	result, err := s.intentProcessor.ExecuteIntentContext(r.Context(), parsedIntent, opts)
+	if err != nil {
+		log.Printf("Error executing intent: %v", err)
+		http.Error(w, "Failed to execute intent: "+err.Error(), llmErrorStatus(err, http.StatusInternalServerError))
+		return
 	}
 	
+	json.NewEncoder(w).Encode(intentResponse(result, req.Intent))
+}
+
+// handleIntentStream processes intent requests like handleIntent, but
+// answers with server-sent events so that clients can show the code as it
+// is generated. The events are:
+//
+//	intent  the parsed intent
+//	code    a piece of generated code, as a JSON string
+//	result  the response handleIntent would have given
+//	error   {"error": message} when the intent fails
+//
+// Closing the connection stops generation.
+func (s *Server) handleIntentStream(w http.ResponseWriter, r *http.Request) {
+	log.Println("Received streaming intent request")
+	
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	
+	var req intentRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		log.Printf("Error decoding request: %v", err)
 		http.Error(w, "Bad request: "+err.Error(), http.StatusBadRequest)
@@ -221,60 +328,122 @@ func (s *Server) handleIntent(w http.ResponseWriter, r *http.Request) {
 	}
 	
 	log.Printf("Processing intent: %s", req.Intent)
+	opts := s.intentOptions(req)
 	
-	// Check if we need to create a temporary client with the provided API key
-	var tempClient *llm.Client
-	if req.APIKey != "" && s.llmClient == nil {
-		log.Printf("Creating temporary client with client-provided API key")
-		tempClient = &llm.Client{
-			APIKey:       req.APIKey,
-			DefaultModel: req.ModelID,
-			HTTPClient:   &http.Client{},
-		}
-		
-		// Temporarily set the client for intent processing
-		s.intentProcessor.SetLLMClient(tempClient)
-		// Reset it after we're done
-		defer s.intentProcessor.SetLLMClient(nil)
-	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
 	
-	// Use existing client if available and set the model
-	if s.llmClient != nil && req.ModelID != "" {
-		log.Printf("Using model: %s", req.ModelID)
-		// Set the model before processing
-		s.llmClient.SetModel(req.ModelID)
-	} else if tempClient != nil && req.ModelID != "" {
-		tempClient.SetModel(req.ModelID)
+	send := func(event string, data interface{}) {
+		payload, err := json.Marshal(data)
+		if err != nil {
+			log.Printf("Error encoding %s event: %v", event, err)
+			return
+		}
+		fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, payload)
+		flusher.Flush()
 	}
 	
-	// Parse and execute the intent
-	parsedIntent, err := s.intentProcessor.ParseIntent(req.Intent)
+	parsedIntent, err := s.intentProcessor.ParseIntentContext(r.Context(), req.Intent, opts)
 	if err != nil {
 		log.Printf("Error parsing intent: %v", err)
-		http.Error(w, "Failed to parse intent: "+err.Error(), http.StatusBadRequest)
+		send("error", map[string]string{"error": "Failed to parse intent: " + err.Error()})
 		return
 	}
+	send("intent", map[string]interface{}{
+		"type":        parsedIntent.Type,
+		"target":      parsedIntent.Target,
+		"constraints": parsedIntent.Constraints,
+		"parameters":  parsedIntent.Parameters,
+	})
 	
-	// Execute the intent
-	result, err := s.intentProcessor.ExecuteIntent(parsedIntent)
+	result, err := s.intentProcessor.ExecuteIntentStream(r.Context(), parsedIntent, opts, func(code string) {
+		send("code", code)
+	})
 	if err != nil {
 		log.Printf("Error executing intent: %v", err)
-		http.Error(w, "Failed to execute intent: "+err.Error(), http.StatusInternalServerError)
+		send("error", map[string]string{"error": "Failed to execute intent: " + err.Error()})
 		return
 	}
 	
+	send("result", intentResponse(result, req.Intent))
+}
+
+// llmErrorStatus chooses the status reporting an error of the LLM API, or
+// fallback for other errors
+func llmErrorStatus(err error, fallback int) int {
+	switch {
+	case errors.Is(err, llm.ErrAuth):
+		return http.StatusUnauthorized
+	case errors.Is(err, llm.ErrRateLimited):
+		return http.StatusTooManyRequests
+	case errors.Is(err, llm.ErrContextTooLong):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, llm.ErrModelUnavailable):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, llm.ErrBudgetExceeded):
+		return http.StatusPaymentRequired
+	default:
+		return fallback
+	}
+}
+
+// intentOptions chooses the provider and model of an intent request. The
+// server's provider is used when there is one, otherwise a client for the
+// request's API key or, without one, the offline demo provider. Nothing
+// shared is changed, so concurrent requests cannot affect each other.
+func (s *Server) intentOptions(req intentRequest) intent.Options {
+	opts := intent.Options{Model: req.ModelID}
+	switch {
+	case s.llmClient != nil:
+		opts.Provider = s.llmClient
+		if opts.Model == "" {
+			s.mu.RLock()
+			opts.Model = s.model
+			s.mu.RUnlock()
+		}
+	case req.APIKey != "":
+		log.Printf("Creating temporary client with client-provided API key")
+		opts.Provider = llm.NewMeteredProvider(&llm.Client{
+			APIKey:       req.APIKey,
+			DefaultModel: req.ModelID,
+			HTTPClient:   &http.Client{},
+		}, s.usage)
+	default:
+		log.Printf("No API key, answering with the offline demo provider")
+		opts.Provider = s.demoProvider
+	}
+	if req.ModelID != "" {
+		log.Printf("Using model: %s", req.ModelID)
+	}
+	return opts
+}
+
+// intentResponse builds the response to an intent from its result
+func intentResponse(result interface{}, originalIntent string) map[string]interface{} {
 	// Check if the result is from the LLM (has sections)
-	sections, ok := result.(map[string]string)
-	if ok {
+	if sections, ok := result.(map[string]string); ok {
 		// Process LLM-generated sections
-		response := processLLMSections(sections, req.Intent)
-		json.NewEncoder(w).Encode(response)
-		return
+		return processLLMSections(sections, originalIntent)
+	}
+	
+	// Modifications and deletions report the entities they found as
+	// before, with their impact alongside
+	if impact, ok := result.(*intent.ImpactResult); ok {
+		return map[string]interface{}{
+			"intent":    originalIntent,
+			"result":    impact.Entities,
+			"impact":    impact.Impact,
+			"semantics": impact.Report,
+		}
 	}
 	
-	// Handle legacy mock response for non-LLM processing
-	mockResponse := generateMockResponse(req.Intent)
-	json.NewEncoder(w).Encode(mockResponse)
+	// Queries report what they found
+	return map[string]interface{}{
+		"intent": originalIntent,
+		"result": result,
+	}
 }
 
 // processLLMSections processes the sections returned by the LLM
@@ -307,6 +476,14 @@ func processLLMSections(sections map[string]string, originalIntent string) map[s
 		}
 	}
 	
+	// Report how the model's own AST differed from the parsed code
+	if astDiff, ok := sections["ast_diff"]; ok && astDiff != "" {
+		response["astDiff"] = strings.Split(astDiff, "\n")
+	}
+	if astError, ok := sections["ast_error"]; ok {
+		response["astError"] = astError
+	}
+	
 	// Parse and add the semantic entities
 	if semanticsStr, ok := sections["semantics"]; ok {
 		var semantics interface{}
@@ -323,157 +500,35 @@ func processLLMSections(sections map[string]string, originalIntent string) map[s
 		}
 	}
 	
-	return response
-}
-
-// generateMockResponse generates a mock response for non-LLM processing
-func generateMockResponse(intentStr string) map[string]interface{} {
-	// Generate code for the login function
-	generatedCode := fmt.Sprintf(`// Generated code for: %s
-package auth
-
-import (
-	"errors"
-	"crypto/sha256"
-	"encoding/hex"
-)
-
-// Login validates user credentials and returns a user ID or an error
-func Login(username, password string) (string, error) {
-	// In a real application, this would check against a database
-	// For demonstration, we'll use a hardcoded example
-	
-	if username == "" || password == "" {
-		return "", errors.New("username and password are required")
-	}
-	
-	// Hash the password (in a real system, you would compare with stored hash)
-	hasher := sha256.New()
-	hasher.Write([]byte(password))
-	hashedPassword := hex.EncodeToString(hasher.Sum(nil))
-	
-	// Mock validation
-	if username == "admin" && hashedPassword == "8c6976e5b5410415bde908bd4dee15dfb167a9c873fc4bb8a81f6f2ab448a918" {
-		return "user-123", nil
-	}
-	
-	return "", errors.New("invalid username or password")
-}`, intentStr)
-
-	// Generate AST representation
-	mockASTNode := map[string]interface{}{
-		"type": "Program",
-		"body": []map[string]interface{}{
-			{
-				"type": "Package",
-				"name": "auth",
-			},
-			{
-				"type": "Import",
-				"declarations": []string{"errors", "crypto/sha256", "encoding/hex"},
-			},
-			{
-				"type": "FunctionDeclaration",
-				"name": "Login",
-				"params": []map[string]string{
-					{"name": "username", "type": "string"},
-					{"name": "password", "type": "string"},
-				},
-				"returnTypes": []string{"string", "error"},
-				"body": []map[string]interface{}{
-					{
-						"type": "IfStatement",
-						"test": map[string]interface{}{
-							"type":     "BinaryExpression",
-							"operator": "||",
-							"left": map[string]interface{}{
-								"type":     "BinaryExpression",
-								"operator": "==",
-								"left":     map[string]string{"type": "Identifier", "name": "username"},
-								"right":    map[string]string{"type": "StringLiteral", "value": ""},
-							},
-							"right": map[string]interface{}{
-								"type":     "BinaryExpression",
-								"operator": "==",
-								"left":     map[string]string{"type": "Identifier", "name": "password"},
-								"right":    map[string]string{"type": "StringLiteral", "value": ""},
-							},
-						},
-						"consequent": map[string]interface{}{
-							"type": "BlockStatement",
-							"body": []map[string]interface{}{
-								{
-									"type": "ReturnStatement",
-									"arguments": []map[string]interface{}{
-										{"type": "StringLiteral", "value": ""},
-										{
-											"type": "CallExpression",
-											"callee": map[string]string{"type": "Identifier", "name": "errors.New"},
-											"arguments": []map[string]string{
-												{"type": "StringLiteral", "value": "username and password are required"},
-											},
-										},
-									},
-								},
-							},
-						},
-					},
-				},
-			},
-		},
-	}
-
-	// Generate semantic entities
-	mockEntities := []map[string]interface{}{
-		{
-			"id":          "func-login-001",
-			"type":        "Function",
-			"name":        "Login",
-			"description": "Validates user credentials and returns a user ID or error",
-			"properties": map[string]interface{}{
-				"parameters": []map[string]string{
-					{"name": "username", "type": "string"},
-					{"name": "password", "type": "string"},
-				},
-				"returnTypes": []string{"string", "error"},
-				"visibility":  "public",
-				"package":     "auth",
-			},
-		},
-		{
-			"id":          "pkg-auth-001",
-			"type":        "Package",
-			"name":        "auth",
-			"description": "Authentication related functionality",
-		},
-	}
-
-	// Generate relationships
-	mockRelations := []map[string]interface{}{
-		{
-			"id":       "rel-001",
-			"type":     "Contains",
-			"fromID":   "pkg-auth-001",
-			"toID":     "func-login-001",
-			"metadata": map[string]interface{}{},
-		},
-	}
-
-	// Create final response
-	response := map[string]interface{}{
-		"intent":        intentStr,
-		"generatedCode": generatedCode,
-		"ast":           mockASTNode,
-		"semantics": map[string]interface{}{
-			"entities":  mockEntities,
-			"relations": mockRelations,
-		},
+	// Report what was merged into the semantic model
+	if ingest, ok := sections["semantics_ingest"]; ok {
+		var result semantics.IngestResult
+		if err := json.Unmarshal([]byte(ingest), &result); err == nil {
+			response["semanticsIngest"] = result
+		}
 	}
-
+	if semanticsError, ok := sections["semantics_error"]; ok {
+		response["semanticsError"] = semanticsError
+	}
+	
+	// Report an answer that needed continuations or stayed incomplete
+	if continuations, err := strconv.Atoi(sections["continuations"]); err == nil {
+		response["continuations"] = continuations
+	}
+	if truncated, ok := sections["truncated"]; ok {
+		response["truncated"] = truncated
+	}
+	
 	return response
 }
 
-// handleAST processes AST manipulation requests
+// handleAST processes AST manipulation requests. The tree to work on is
+// given either as Go source in "code" or as a serialized ast.Node in "node".
+// Code is parsed as the file named by "filename"; only modifications of
+// named files are reflected in the semantic model.
+// The "parse" operation returns the tree for the code, "generate" returns
+// the code for the tree, and every other operation is applied with
+// ast.Processor.ModifyAST. The response carries the resulting tree and code.
 func (s *Server) handleAST(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -482,7 +537,9 @@ func (s *Server) handleAST(w http.ResponseWriter, r *http.Request) {
 
 	var req struct {
 		Operation string                 `json:"operation"`
-		Node      map[string]interface{} `json:"node"`
+		Code      string                 `json:"code"`
+		Filename  string                 `json:"filename"`
+		Node      json.RawMessage        `json:"node"`
 		Params    map[string]interface{} `json:"params"`
 	}
 
@@ -491,18 +548,115 @@ func (s *Server) handleAST(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// This is a simplified implementation that would perform AST operations
-	// For demonstration, we'll just return a success message
+	// Build the tree from the code or the serialized node
+	var node *ast.Node
+	switch {
+	case req.Code != "":
+		parsed, err := s.astProcessor.ParseGoFile(req.Filename, req.Code)
+		if err != nil {
+			writeASTError(w, http.StatusBadRequest, "parse error", err)
+			return
+		}
+		node = parsed
+	case len(req.Node) > 0 && string(req.Node) != "null":
+		node = &ast.Node{}
+		if err := json.Unmarshal(req.Node, node); err != nil {
+			writeASTError(w, http.StatusBadRequest, "invalid node", err)
+			return
+		}
+	default:
+		writeASTError(w, http.StatusBadRequest, "missing input", errors.New("either code or node is required"))
+		return
+	}
+
+	switch req.Operation {
+	case "parse", "generate":
+		// Nothing to modify, the tree and its code are returned below
+	case "":
+		writeASTError(w, http.StatusBadRequest, "missing operation", errors.New("operation is required"))
+		return
+	default:
+		modified, err := s.astProcessor.ModifyAST(node, req.Operation, req.Params)
+		if err != nil {
+			status := http.StatusBadRequest
+			if errors.Is(err, ast.ErrTargetNotFound) {
+				status = http.StatusNotFound
+			}
+			writeASTError(w, status, "operation failed", err)
+			return
+		}
+		node = modified
+	}
+
+	code, err := s.astProcessor.GenerateCode(node)
+	if err != nil {
+		writeASTError(w, http.StatusUnprocessableEntity, "code generation failed", err)
+		return
+	}
+
+	response := map[string]interface{}{
+		"status":    "success",
+		"operation": req.Operation,
+		"ast":       node,
+		"code":      code,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
 
+// writeASTError reports a failed AST request, including the details of an
+// ast.OperationError when there is one
+func writeASTError(w http.ResponseWriter, status int, message string, err error) {
 	response := map[string]interface{}{
-		"status":  "success",
-		"message": "AST operation processed",
+		"status":  "error",
+		"message": message,
+		"error":   err.Error(),
 	}
 
+	var opErr *ast.OperationError
+	if errors.As(err, &opErr) {
+		response["operation"] = opErr.Operation
+		response["target"] = opErr.Target
+		response["detail"] = opErr.Detail
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
 	json.NewEncoder(w).Encode(response)
 }
 
-// handleSemantics processes semantic model queries
+// Page sizes of semantic query results
+const (
+	defaultSemanticsLimit = 50
+	maxSemanticsLimit     = 500
+)
+
+// semanticEntity is an entity as returned by /api/semantics
+type semanticEntity struct {
+	ID          string                 `json:"id"`
+	Type        string                 `json:"type"`
+	Name        string                 `json:"name"`
+	Description string                 `json:"description,omitempty"`
+	Properties  map[string]interface{} `json:"properties,omitempty"`
+	Outgoing    []semanticRelation     `json:"outgoing,omitempty"`
+	Incoming    []semanticRelation     `json:"incoming,omitempty"`
+}
+
+// semanticRelation is a relation as returned by /api/semantics
+type semanticRelation struct {
+	Type string `json:"type"`
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// handleSemantics runs a query against the semantic model. The query is
+// either written in the semantic query language ("query") or asked in
+// natural language ("question"); with neither every entity is listed.
+// Results can be restricted to entity types, are paginated with offset
+// and limit, and carry their relations when "expand" is set. A query that
+// matches nothing succeeds with an empty "results" list; invalid requests
+// are answered with status "error".
 func (s *Server) handleSemantics(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -510,33 +664,189 @@ func (s *Server) handleSemantics(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var req struct {
-		Query string `json:"query"`
+		Query    string   `json:"query"`
+		Question string   `json:"question"`
+		Types    []string `json:"types"`
+		Offset   int      `json:"offset"`
+		Limit    int      `json:"limit"`
+		Expand   bool     `json:"expand"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Bad request: "+err.Error(), http.StatusBadRequest)
+		writeSemanticsError(w, http.StatusBadRequest, "Bad request: "+err.Error())
+		return
+	}
+	if req.Offset < 0 || req.Limit < 0 {
+		writeSemanticsError(w, http.StatusBadRequest, "offset and limit must not be negative")
 		return
 	}
+	if req.Limit == 0 {
+		req.Limit = defaultSemanticsLimit
+	}
+	if req.Limit > maxSemanticsLimit {
+		req.Limit = maxSemanticsLimit
+	}
+	if s.semanticModel == nil {
+		writeSemanticsError(w, http.StatusServiceUnavailable, "no semantic model is loaded")
+		return
+	}
+
+	var entities []*semantics.Entity
+	var relations []*semantics.Relation
+	query := req.Query
+	switch {
+	case req.Query != "":
+		q, err := semantics.ParseQuery(req.Query)
+		if err != nil {
+			writeSemanticsError(w, http.StatusBadRequest, "Invalid query: "+err.Error())
+			return
+		}
+		query = q.String()
+		entities, relations = s.semanticModel.Query(q)
+	case req.Question != "":
+		entities, relations = s.semanticModel.QueryByIntentContext(r.Context(), req.Question)
+	default:
+		entities = s.semanticModel.ListEntities(semantics.EntityFilter{})
+	}
 
-	// This is a simplified implementation that would query the semantic model
-	// For demonstration, we'll just return a mock response
+	// Restrict the results to the requested entity types
+	if len(req.Types) > 0 {
+		filtered := entities[:0:0]
+		for _, entity := range entities {
+			for _, t := range req.Types {
+				if strings.EqualFold(entity.Type, t) {
+					filtered = append(filtered, entity)
+					break
+				}
+			}
+		}
+		entities = filtered
+	}
+
+	total := len(entities)
+	page := []*semantics.Entity{}
+	if req.Offset < total {
+		page = entities[req.Offset:min(req.Offset+req.Limit, total)]
+	}
+
+	results := make([]semanticEntity, 0, len(page))
+	for _, entity := range page {
+		result := semanticEntity{
+			ID:          entity.ID,
+			Type:        entity.Type,
+			Name:        entity.Name,
+			Description: entity.Description,
+			Properties:  entity.Properties,
+		}
+		if req.Expand {
+			outgoing, incoming, err := s.semanticModel.RelationsOf(entity.ID)
+			if err == nil {
+				result.Outgoing = toSemanticRelations(outgoing)
+				result.Incoming = toSemanticRelations(incoming)
+			}
+		}
+		results = append(results, result)
+	}
 
 	response := map[string]interface{}{
-		"status":  "success",
-		"message": "Semantic query processed",
-		"results": []map[string]interface{}{
-			{
-				"id":          "func-login-001",
-				"type":        "Function",
-				"name":        "Login",
-				"description": "Validates user credentials and returns a user ID or error",
-			},
-		},
+		"status":    "success",
+		"message":   fmt.Sprintf("%d entities matched", total),
+		"query":     query,
+		"total":     total,
+		"offset":    req.Offset,
+		"limit":     req.Limit,
+		"hasMore":   req.Offset+len(page) < total,
+		"results":   results,
+		"relations": toSemanticRelations(relations),
 	}
 
+	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
 }
 
+// toSemanticRelations converts relations for a response, never returning
+// nil so that empty lists are encoded as []
+func toSemanticRelations(relations []*semantics.Relation) []semanticRelation {
+	result := make([]semanticRelation, 0, len(relations))
+	for _, relation := range relations {
+		result = append(result, semanticRelation{Type: relation.Type, From: relation.From.ID, To: relation.To.ID})
+	}
+	return result
+}
+
+// writeSemanticsError reports a failed semantic query
+func writeSemanticsError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":  "error",
+		"message": message,
+	})
+}
+
+// exportContentTypes are the content types of the graph export formats
+var exportContentTypes = map[string]string{
+	semantics.FormatDOT:     "text/vnd.graphviz; charset=utf-8",
+	semantics.FormatGraphML: "application/graphml+xml; charset=utf-8",
+	semantics.FormatMermaid: "text/plain; charset=utf-8",
+}
+
+// handleSemanticsExport exports the semantic graph, or the part of it
+// selected by a semantic query, as DOT, GraphML or Mermaid text:
+//
+//	GET /api/semantics/export?format=mermaid&query=package%3Dauth+follow:*
+func (s *Server) handleSemanticsExport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = semantics.FormatDOT
+	}
+	contentType, ok := exportContentTypes[format]
+	if !ok {
+		http.Error(w, fmt.Sprintf("Bad request: unknown format %q, expected one of %s", format, strings.Join(semantics.ExportFormats, ", ")), http.StatusBadRequest)
+		return
+	}
+	
+	var query *semantics.Query
+	if text := r.URL.Query().Get("query"); text != "" {
+		var err error
+		if query, err = semantics.ParseQuery(text); err != nil {
+			http.Error(w, "Bad request: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	
+	if s.semanticModel == nil {
+		http.Error(w, "No semantic model is loaded", http.StatusServiceUnavailable)
+		return
+	}
+	
+	var buf bytes.Buffer
+	if err := s.semanticModel.Export(&buf, format, query); err != nil {
+		http.Error(w, "Failed to export semantic model: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	
+	w.Header().Set("Content-Type", contentType)
+	w.Write(buf.Bytes())
+}
+
+// handleUsage returns the usage and cost of the requests to the LLM for
+// the session, the project and the day, with the budget
+func (s *Server) handleUsage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.usage.Report())
+}
+
 // handleHealth provides a simple health check endpoint
 func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
 	response := map[string]string{
@@ -546,6 +856,6 @@ func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
 }
 
 // GetLLMClient returns the LLM client for the server
-func (s *Server) GetLLMClient() *llm.Client {
+func (s *Server) GetLLMClient() llm.Provider {
 	return s.llmClient
 }
\ No newline at end of file
@@ -0,0 +1,242 @@
+package server
+
+import (
+	"encoding/json"
+	"go/parser"
+	"go/token"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/knoxai/AI-Native-Development-System/pkg/ast"
+	"github.com/knoxai/AI-Native-Development-System/pkg/intent"
+	"github.com/knoxai/AI-Native-Development-System/pkg/llm"
+	"github.com/knoxai/AI-Native-Development-System/pkg/semantics"
+)
+
+const (
+	createIntent = `{"type": "Create", "target": "Function", "parameters": {"name": "Login"}}`
+	loginCode    = "package auth\n\nfunc Login() bool {\n\treturn true\n}\n"
+	loginBundle  = `{"code": "package auth\n\nfunc Login() bool {\n\treturn true\n}\n", "semantics": {"entities": [{"id": "Login", "type": "Function", "name": "Login"}], "relations": []}}`
+)
+
+// newTestServer creates a server answering intents with provider
+func newTestServer(provider llm.Provider) *Server {
+	model := semantics.NewModel()
+	astProc := ast.NewProcessor(model)
+	return &Server{
+		intentProcessor: intent.NewProcessor(astProc, model),
+		astProcessor:    astProc,
+		semanticModel:   model,
+		llmClient:       provider,
+	}
+}
+
+// postIntent sends an intent request to handler
+func postIntent(handler http.HandlerFunc, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/api/intent", strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	return rec
+}
+
+func TestHandleIntent(t *testing.T) {
+	toolCall := llm.ToolCall{ID: "call-1", Type: "function"}
+	toolCall.Function.Name = "code_bundle"
+	toolCall.Function.Arguments = loginBundle
+
+	tests := []struct {
+		name   string
+		rule   llm.FakeRule
+		status int
+		code   string
+	}{
+		{"code bundle", llm.FakeRule{Format: "code_bundle", Response: loginBundle}, http.StatusOK, loginCode},
+		{"tool call", llm.FakeRule{Format: "code_bundle", ToolCalls: []llm.ToolCall{toolCall}}, http.StatusOK, loginCode},
+		{"rate limited", llm.FakeRule{Format: "code_bundle", Err: &llm.APIError{
+			StatusCode: 429, Status: "429 Too Many Requests", Kind: llm.ErrRateLimited,
+		}}, http.StatusTooManyRequests, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := llm.NewFakeProvider(llm.FakeRule{Format: "intent", Response: createIntent}, tt.rule)
+			s := newTestServer(fake)
+
+			rec := postIntent(s.handleIntent, `{"intent": "create a login function", "model_id": "small"}`)
+			if rec.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+
+			var response struct {
+				GeneratedCode string `json:"generatedCode"`
+				AST           struct {
+					Root struct {
+						Type string `json:"type"`
+					} `json:"root"`
+				} `json:"ast"`
+				SemanticsIngest semantics.IngestResult `json:"semanticsIngest"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+				t.Fatalf("decoding response: %v", err)
+			}
+			if response.GeneratedCode != tt.code || response.AST.Root.Type != "Program" ||
+				len(response.SemanticsIngest.Added) != 1 {
+				t.Errorf("unexpected response %s", rec.Body)
+			}
+			for _, exchange := range fake.Transcript() {
+				if exchange.Model != "small" {
+					t.Errorf("request for model %q, want small", exchange.Model)
+				}
+			}
+		})
+	}
+}
+
+func TestHandleIntentStream(t *testing.T) {
+	fake := llm.NewFakeProvider(
+		llm.FakeRule{Format: "intent", Response: createIntent},
+		llm.FakeRule{Format: "code_bundle", Response: loginBundle},
+	)
+	s := newTestServer(fake)
+
+	rec := postIntent(s.handleIntentStream, `{"intent": "create a login function"}`)
+	var code strings.Builder
+	events := map[string]int{}
+	for _, event := range strings.Split(rec.Body.String(), "\n\n") {
+		name, data, ok := strings.Cut(event, "\ndata: ")
+		if !ok {
+			continue
+		}
+		name = strings.TrimPrefix(name, "event: ")
+		events[name]++
+		if name == "code" {
+			var piece string
+			if err := json.Unmarshal([]byte(data), &piece); err != nil {
+				t.Fatalf("decoding code event %q: %v", data, err)
+			}
+			code.WriteString(piece)
+		}
+	}
+	if events["intent"] != 1 || events["result"] != 1 || events["error"] != 0 {
+		t.Errorf("unexpected events %v", events)
+	}
+	if code.String() != loginCode {
+		t.Errorf("streamed code %q, want %q", code.String(), loginCode)
+	}
+}
+
+func TestHandleModelSelect(t *testing.T) {
+	fake := llm.NewFakeProvider(
+		llm.FakeRule{Format: "intent", Response: createIntent},
+		llm.FakeRule{Format: "code_bundle", Response: loginBundle},
+	)
+	s := newTestServer(fake)
+
+	req := httptest.NewRequest(http.MethodPost, "/api/models/select", strings.NewReader(`{"model_id": "small"}`))
+	rec := httptest.NewRecorder()
+	s.handleModelSelect(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	if fake.DefaultModel != "fake" {
+		t.Errorf("selecting a model changed the shared provider's model to %q", fake.DefaultModel)
+	}
+
+	// Intents naming no model use the selected one
+	if rec := postIntent(s.handleIntent, `{"intent": "create a login function"}`); rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	for _, exchange := range fake.Transcript() {
+		if exchange.Model != "small" {
+			t.Errorf("request for model %q, want small", exchange.Model)
+		}
+	}
+}
+
+func TestHandleIntentImpact(t *testing.T) {
+	fake := llm.NewFakeProvider(llm.FakeRule{Format: "intent", Response: `{"type": "Delete", "target": "Function", "parameters": {"name": "hashPassword"}}`})
+	s := newTestServer(fake)
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "auth.go", "package auth\nfunc Login() { hashPassword() }\nfunc hashPassword() {}\n", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.semanticModel.UpdateFromFile(fset, file); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := postIntent(s.handleIntent, `{"intent": "delete the hashPassword function"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	var response struct {
+		Result    []semantics.Entity `json:"result"`
+		Impact    []json.RawMessage  `json:"impact"`
+		Semantics string             `json:"semantics"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		t.Fatalf("result is no longer a list of entities: %v\n%s", err, rec.Body)
+	}
+	if len(response.Result) == 0 || len(response.Impact) != len(response.Result) || response.Semantics == "" {
+		t.Errorf("unexpected response %s", rec.Body)
+	}
+}
+
+func TestHandleAST(t *testing.T) {
+	const code = "package p\n\nfunc Login() {}\n"
+	tests := []struct {
+		name   string
+		body   string
+		status int
+		target string
+	}{
+		{"modified", `{"operation": "rename_function", "params": {"name": "Login", "new_name": "SignIn"}}`, http.StatusOK, ""},
+		{"target not found", `{"operation": "remove_function", "params": {"name": "Logout"}}`, http.StatusNotFound, "Logout"},
+		{"invalid parameters", `{"operation": "rename_function", "params": {"name": "Login", "new_name": "sign in"}}`, http.StatusBadRequest, "sign in"},
+		{"unknown operation", `{"operation": "rewrite"}`, http.StatusBadRequest, "rewrite"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{astProcessor: ast.NewProcessor(semantics.NewModel())}
+			body := strings.Replace(tt.body, "{", `{"code": `+strconv.Quote(code)+`, `, 1)
+			rec := httptest.NewRecorder()
+			s.handleAST(rec, httptest.NewRequest(http.MethodPost, "/api/ast", strings.NewReader(body)))
+			if rec.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+
+			var response struct {
+				Code      string `json:"code"`
+				Operation string `json:"operation"`
+				Target    string `json:"target"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+				t.Fatalf("decoding response: %v", err)
+			}
+			if tt.status == http.StatusOK {
+				if !strings.Contains(response.Code, "func SignIn()") {
+					t.Errorf("code not modified: %q", response.Code)
+				}
+				return
+			}
+			if response.Operation == "" || response.Target != tt.target {
+				t.Errorf("operation error not reported: %s", rec.Body)
+			}
+		})
+	}
+}
+
+func TestHandleSemanticsExportWithoutModel(t *testing.T) {
+	s := &Server{}
+
+	rec := httptest.NewRecorder()
+	s.handleSemanticsExport(rec, httptest.NewRequest(http.MethodGet, "/api/semantics/export", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("status %d, want 503 without a semantic model", rec.Code)
+	}
+}
@@ -20,7 +20,7 @@ func main() {
 	fmt.Println("API Key found:", maskAPIKey(apiKey))
 	
 	// Create HTTP request to models endpoint
-	req, err := http.NewRequest("GET", "https://openrouter.co/v1/models", nil)
+	req, err := http.NewRequest("GET", "https://openrouter.ai/api/v1/models", nil)
 	if err != nil {
 		fmt.Printf("Error creating request: %v\n", err)
 		os.Exit(1)